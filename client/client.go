@@ -0,0 +1,324 @@
+// Package client — клиент API сервера monalert для Go-сервисов:
+//
+//	c := client.New(client.Config{Address: "localhost:8080", Gzip: true, Retry: client.NewRetryPolicy(3, nil)})
+//	if _, err := c.UpdateGauge(ctx, "queue_size", 42); err != nil { ... }
+//
+// Для частых обновлений из приложения удобнее Reporter: он копит значения
+// и отправляет их батчем раз в интервал.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"monalert/internal/compress"
+	"monalert/internal/models"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metric и Metadata — типы API сервера. Псевдонимы нужны, чтобы ими могли
+// пользоваться пакеты за пределами модуля.
+type (
+	Metric   = models.Metrics
+	Metadata = models.Metadata
+)
+
+// SignatureHeader — заголовок с подписью запроса в hex, см. SignRequest.
+const SignatureHeader = "HashSHA256"
+
+// Config — настройки клиента.
+type Config struct {
+	Address string      // адрес сервера host:port
+	Scheme  string      // http или https, по умолчанию http
+	Token   string      // API-токен, передаётся как bearer
+	TLS     *tls.Config // настройки TLS для https, nil — системные
+	Gzip    bool        // сжимать тела запросов gzip, то же, что Compression: "gzip"
+	// Compression — алгоритм сжатия тел запросов: gzip, deflate, zstd или br. Пустой — без сжатия
+	Compression string
+	// Key — ключ подписи. Если задан, каждый запрос подписывается, см. SignRequest
+	Key string
+	// Timeout ограничивает одну попытку запроса, кроме подписки Watch. 0 — без ограничения
+	Timeout time.Duration
+	// Retry — политика повторов, нулевое значение — одна попытка
+	Retry RetryPolicy
+	// Header вызывается для каждого запроса и может добавить в него свои заголовки
+	Header func(req *http.Request)
+}
+
+type Client struct {
+	cfg  Config
+	base string
+	http *http.Client
+	// compression — алгоритм сжатия тел, nil — тела не сжимаются
+	compression compress.Codec
+	// compressionErr — неизвестный алгоритм в Config; возвращается из каждого запроса с телом
+	compressionErr error
+}
+
+func New(cfg Config) *Client {
+	if cfg.Scheme == "" {
+		cfg.Scheme = "http"
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.TLS != nil {
+		transport.TLSClientConfig = cfg.TLS
+	}
+	c := &Client{
+		cfg:  cfg,
+		base: cfg.Scheme + "://" + cfg.Address,
+		http: &http.Client{Transport: transport},
+	}
+	if cfg.Compression == "" && cfg.Gzip {
+		cfg.Compression = "gzip"
+	}
+	if cfg.Compression != "" {
+		codec, ok := compress.Default().Get(cfg.Compression)
+		if ok {
+			c.compression = codec
+		} else {
+			c.compressionErr = fmt.Errorf("unknown compression %q, supported: %s",
+				cfg.Compression, strings.Join(compress.Default().Names(), ", "))
+		}
+	}
+	return c
+}
+
+// Update отправляет метрику в JSON и возвращает её значение после обновления.
+func (c *Client) Update(ctx context.Context, m *Metric) (*Metric, error) {
+	var resp Metric
+	if err := c.doJSON(ctx, http.MethodPost, "/update/", m, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UpdateGauge записывает значение гауджа.
+func (c *Client) UpdateGauge(ctx context.Context, id string, value float64) (*Metric, error) {
+	return c.Update(ctx, &Metric{ID: id, MType: "gauge", Value: &value})
+}
+
+// UpdateCounter прибавляет delta к счётчику и возвращает его новое значение.
+func (c *Client) UpdateCounter(ctx context.Context, id string, delta int64) (*Metric, error) {
+	return c.Update(ctx, &Metric{ID: id, MType: "counter", Delta: &delta})
+}
+
+// UpdateBatch отправляет несколько метрик одним запросом и возвращает их значения после обновления.
+func (c *Client) UpdateBatch(ctx context.Context, metrics []Metric) ([]Metric, error) {
+	var resp []Metric
+	if err := c.doJSON(ctx, http.MethodPost, "/updates/", metrics, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// UpdateURL отправляет метрику в пути запроса: /update/{type}/{name}/{value}.
+func (c *Client) UpdateURL(ctx context.Context, m *Metric) error {
+	var value string
+	switch {
+	case m.Value != nil:
+		value = strconv.FormatFloat(*m.Value, 'f', -1, 64)
+	case m.Delta != nil:
+		value = strconv.FormatInt(*m.Delta, 10)
+	default:
+		return fmt.Errorf("metric %s has no value", m.ID)
+	}
+	path := "/update/" + url.PathEscape(m.MType) + "/" + url.PathEscape(m.ID) + "/" + value
+	if m.Cumulative {
+		path += "?cumulative=true"
+	}
+	_, err := c.do(ctx, http.MethodPost, path, nil, "")
+	return err
+}
+
+// Get возвращает текущее значение метрики.
+func (c *Client) Get(ctx context.Context, mType, id string) (*Metric, error) {
+	var resp Metric
+	if err := c.doJSON(ctx, http.MethodPost, "/value/", &Metric{ID: id, MType: mType}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// List возвращает все метрики сервера.
+func (c *Client) List(ctx context.Context) ([]Metric, error) {
+	var resp []Metric
+	if err := c.doJSON(ctx, http.MethodGet, "/", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ListOptions — фильтры ListMetrics. Пустые поля не фильтруют.
+type ListOptions struct {
+	Type   string // gauge или counter
+	Prefix string // начало имени
+	Match  string // регулярное выражение для имени
+}
+
+// listPageSize — размер страницы ListMetrics, наибольший, который принимает сервер.
+const listPageSize = 1000
+
+// ListMetrics возвращает метрики, подходящие под фильтры, по порядку имён. Фильтры применяет
+// сервер: клиент проходит по страницам /api/v1/metrics и не скачивает лишнего.
+func (c *Client) ListMetrics(ctx context.Context, opts ListOptions) ([]Metric, error) {
+	query := url.Values{"limit": {strconv.Itoa(listPageSize)}}
+	for name, value := range map[string]string{"type": opts.Type, "prefix": opts.Prefix, "match": opts.Match} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	var metrics []Metric
+	// ссылка на следующую страницу уже содержит путь и все параметры
+	for path := "/api/v1/metrics?" + query.Encode(); path != ""; {
+		var page models.MetricList
+		if err := c.doJSON(ctx, http.MethodGet, path, nil, &page); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, page.Metrics...)
+		path = page.Next
+	}
+	return metrics, nil
+}
+
+func (c *Client) GetMetadata(ctx context.Context, name string) (*Metadata, error) {
+	var resp Metadata
+	if err := c.doJSON(ctx, http.MethodGet, "/meta/"+url.PathEscape(name), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) SetMetadata(ctx context.Context, name string, md Metadata) error {
+	return c.doJSON(ctx, http.MethodPut, "/meta/"+url.PathEscape(name), md, nil)
+}
+
+// AllMetadata возвращает описания всех метрик по именам.
+func (c *Client) AllMetadata(ctx context.Context) (map[string]Metadata, error) {
+	var resp map[string]Metadata
+	if err := c.doJSON(ctx, http.MethodGet, "/meta/", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// doJSON отправляет in в JSON (если он не nil) и разбирает ответ в out (если он не nil).
+func (c *Client) doJSON(ctx context.Context, method, path string, in, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("cannot encode request: %w", err)
+		}
+	}
+	data, err := c.do(ctx, method, path, body, "application/json")
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("cannot decode response: %w", err)
+	}
+	return nil
+}
+
+// do выполняет запрос с повторами и возвращает тело успешного ответа.
+// Ответ не из 2xx возвращается как *StatusError.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, contentType string) ([]byte, error) {
+	// подписывается несжатое тело: сервер проверяет подпись после распаковки
+	rawBody := body
+	contentEncoding := ""
+	if len(body) > 0 {
+		if c.compressionErr != nil {
+			return nil, c.compressionErr
+		}
+		if c.compression != nil {
+			compressed, err := compress.Encode(c.compression, body)
+			if err != nil {
+				return nil, err
+			}
+			body, contentEncoding = compressed, c.compression.Name()
+		}
+	}
+	var data []byte
+	resp, err := c.cfg.Retry.Do(ctx, func() (*http.Response, error) {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if c.cfg.Timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, c.cfg.Timeout)
+		}
+		defer cancel()
+		req, err := http.NewRequestWithContext(attemptCtx, method, c.base+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+			req.Header.Set("Accept", contentType)
+		}
+		if contentEncoding != "" {
+			req.Header.Set("Content-Encoding", contentEncoding)
+		}
+		if c.cfg.Key != "" {
+			req.Header.Set(SignatureHeader, SignRequest(c.cfg.Key, method, req.URL.RequestURI(), rawBody))
+		}
+		c.setHeaders(req)
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		// тело дочитывается здесь, пока не истёк таймаут попытки
+		data, err = io.ReadAll(resp.Body)
+		resp.Body.Close() //nolint:gosec // response.Body.Close() error is intentionally ignored
+		if err != nil {
+			return nil, fmt.Errorf("cannot read response: %w", err)
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+		return resp, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close() //nolint:gosec // тело уже прочитано в data
+	return data, nil
+}
+
+// Sign вычисляет HMAC-SHA256 данных в hex.
+func Sign(key string, data []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest вычисляет значение заголовка HashSHA256: HMAC-SHA256 строки
+// "METHOD /path?query\n", за которой следует несжатое тело. Путь и метод входят в подпись,
+// чтобы её нельзя было перенести на другой запрос: у /update/{type}/{name}/{value}
+// тело пустое, и подпись одного тела подошла бы к любой метрике.
+func SignRequest(key, method, requestURI string, body []byte) string {
+	data := make([]byte, 0, len(method)+len(requestURI)+2+len(body))
+	data = append(data, method...)
+	data = append(data, ' ')
+	data = append(data, requestURI...)
+	data = append(data, '\n')
+	return Sign(key, append(data, body...))
+}
+
+func (c *Client) setHeaders(req *http.Request) {
+	if c.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+	if c.cfg.Header != nil {
+		c.cfg.Header(req)
+	}
+}
@@ -0,0 +1,202 @@
+package client
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"monalert/internal/compress"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	return New(Config{Address: strings.TrimPrefix(ts.URL, "http://"), Token: "secret", Gzip: true})
+}
+
+func TestUpdate(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/update/", r.URL.Path)
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		zr, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		var m Metric
+		require.NoError(t, json.NewDecoder(zr).Decode(&m))
+		*m.Delta += 10
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(m)
+	})
+	delta := int64(5)
+	resp, err := c.Update(context.Background(), &Metric{ID: "hits", MType: "counter", Delta: &delta})
+	require.NoError(t, err)
+	assert.Equal(t, int64(15), *resp.Delta)
+}
+
+func TestStatusError(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	})
+	_, err := c.Get(context.Background(), "gauge", "x")
+	var statusErr *StatusError
+	require.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusForbidden, statusErr.StatusCode)
+	assert.Equal(t, "forbidden", statusErr.Message)
+	assert.ErrorIs(t, err, ErrForbidden)
+	assert.NotErrorIs(t, err, ErrNotFound)
+
+	c = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"code":"invalid_value","message":"gauge a without value","field":"value"}`)
+	})
+	_, err = c.UpdateGauge(context.Background(), "a", 1)
+	require.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, "invalid_value", statusErr.Code)
+	assert.Equal(t, "value", statusErr.Field)
+	assert.Equal(t, "gauge a without value", statusErr.Message)
+	assert.ErrorIs(t, err, ErrBadRequest)
+
+	var calls int
+	c = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprint(w, `{"code":"cardinality_limit","message":"cardinality limit exceeded: too many series"}`)
+	})
+	c.cfg.Retry = RetryPolicy{Retries: 3}
+	_, err = c.UpdateGauge(context.Background(), "a", 1)
+	assert.ErrorIs(t, err, ErrCardinalityLimit)
+	assert.NotErrorIs(t, err, ErrTooManyRequests)
+	require.True(t, errors.As(err, &statusErr))
+	assert.False(t, statusErr.Retryable())
+	assert.Equal(t, 1, calls)
+}
+
+func TestUpdateBatch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/updates/", r.URL.Path)
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		// подписываются метод, путь и несжатое тело
+		assert.Equal(t, SignRequest("key", http.MethodPost, "/updates/", body), r.Header.Get(SignatureHeader))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	}))
+	defer ts.Close()
+	c := New(Config{Address: strings.TrimPrefix(ts.URL, "http://"), Key: "key"})
+	resp, err := c.UpdateBatch(context.Background(), []Metric{
+		{ID: "a", MType: "gauge", Value: ptr(1.5)},
+		{ID: "b", MType: "counter", Delta: ptr(int64(2))},
+	})
+	require.NoError(t, err)
+	require.Len(t, resp, 2)
+	assert.Equal(t, "b", resp[1].ID)
+}
+
+func TestReporter(t *testing.T) {
+	var (
+		batches [][]Metric
+		fail    = true
+	)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, "storage is full", http.StatusTooManyRequests)
+			return
+		}
+		zr, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		var batch []Metric
+		require.NoError(t, json.NewDecoder(zr).Decode(&batch))
+		batches = append(batches, batch)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(batch)
+	})
+	r := NewReporter(c, time.Hour)
+	require.NoError(t, r.Flush(context.Background()), "nothing to send")
+
+	r.Gauge("load", 1)
+	r.Counter("hits", 2)
+	require.ErrorIs(t, r.Flush(context.Background()), ErrTooManyRequests)
+
+	// неотправленное вернулось в буфер: гаудж не затёр новое значение, приращения сложились
+	r.Gauge("load", 3)
+	r.Counter("hits", 5)
+	fail = false
+	require.NoError(t, r.Flush(context.Background()))
+	require.Len(t, batches, 1)
+	got := map[string]Metric{}
+	for _, m := range batches[0] {
+		got[m.ID] = m
+	}
+	assert.InDelta(t, 3.0, *got["load"].Value, 0)
+	assert.Equal(t, int64(7), *got["hits"].Delta)
+
+	require.NoError(t, r.Flush(context.Background()))
+	assert.Len(t, batches, 1, "buffer is empty after a successful flush")
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestWatch(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "gauge", r.URL.Query().Get("type"))
+		assert.Equal(t, "7", r.Header.Get("Last-Event-ID"))
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, ": keep-alive\n\n")
+		fmt.Fprint(w, "id: 8\nevent: update\ndata: {\"id\":\"a\",\"type\":\"gauge\",\"value\":1}\n\n")
+		fmt.Fprint(w, "id: 9\nevent: update\ndata: {\"id\":\"b\",\"type\":\"gauge\",\"value\":2}\n\n")
+	})
+	var events []Event
+	err := c.Watch(context.Background(), Filter{Type: "gauge"}, 7, func(ev Event) error {
+		events = append(events, ev)
+		return nil
+	})
+	require.Error(t, err, "stream ends when the server closes it")
+	require.Len(t, events, 2)
+	assert.Equal(t, uint64(8), events[0].ID)
+	assert.Equal(t, "b", events[1].Metric.ID)
+	assert.InDelta(t, 2.0, *events[1].Metric.Value, 0)
+}
+
+func TestCompression(t *testing.T) {
+	for _, name := range compress.Default().Names() {
+		t.Run(name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, name, r.Header.Get("Content-Encoding"))
+				codec, ok := compress.Default().Get(r.Header.Get("Content-Encoding"))
+				require.True(t, ok)
+				zr, err := codec.NewReader(r.Body)
+				require.NoError(t, err)
+				defer zr.Close()
+				var batch []Metric
+				require.NoError(t, json.NewDecoder(zr).Decode(&batch))
+				w.Header().Set("Content-Type", "application/json")
+				_ = json.NewEncoder(w).Encode(batch)
+			}))
+			defer ts.Close()
+			c := New(Config{Address: strings.TrimPrefix(ts.URL, "http://"), Compression: name})
+			value := 1.5
+			_, err := c.UpdateBatch(context.Background(), []Metric{{ID: "load", MType: "gauge", Value: &value}})
+			require.NoError(t, err)
+		})
+	}
+
+	c := New(Config{Address: "localhost:1", Compression: "lz4"})
+	value := 1.5
+	_, err := c.UpdateBatch(context.Background(), []Metric{{ID: "load", MType: "gauge", Value: &value}})
+	assert.ErrorContains(t, err, `unknown compression "lz4"`)
+}
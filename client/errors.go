@@ -0,0 +1,91 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Ошибки, в которые отображаются коды ответа сервера. Проверяются через errors.Is:
+//
+//	if errors.Is(err, client.ErrNotFound) { ... }
+var (
+	ErrBadRequest       = errors.New("bad request")
+	ErrUnauthorized     = errors.New("unauthorized")
+	ErrForbidden        = errors.New("forbidden")
+	ErrNotFound         = errors.New("not found")
+	ErrPayloadTooLarge  = errors.New("payload too large")
+	ErrCardinalityLimit = errors.New("cardinality limit exceeded")
+	ErrTooManyRequests  = errors.New("too many requests")
+	ErrServer           = errors.New("server error")
+)
+
+// StatusError — сервер ответил кодом не из 2xx.
+type StatusError struct {
+	StatusCode int
+	Code       string        // машиночитаемый код ошибки из тела ответа, например not_found
+	Message    string        // сообщение сервера, а если тело не в JSON — тело целиком
+	Field      string        // поле запроса, к которому относится ошибка
+	RetryAfter time.Duration // из заголовка Retry-After
+}
+
+func newStatusError(resp *http.Response) *StatusError {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return parseStatusError(resp.StatusCode, resp.Header, data)
+}
+
+// parseStatusError разбирает тело ошибки сервера {"code":..., "message":..., "field":...}.
+// Тело в другом формате, например от прокси перед сервером, попадает в Message как есть.
+func parseStatusError(status int, header http.Header, data []byte) *StatusError {
+	e := &StatusError{
+		StatusCode: status,
+		Message:    strings.TrimSpace(string(data)),
+		RetryAfter: parseRetryAfter(header.Get("Retry-After"), time.Now()),
+	}
+	var body struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Field   string `json:"field"`
+	}
+	if json.Unmarshal(data, &body) == nil && body.Code != "" {
+		e.Code, e.Message, e.Field = body.Code, body.Message, body.Field
+	}
+	return e
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("server returned status %d: %s", e.StatusCode, e.Message)
+}
+
+// Is сопоставляет код ответа с ошибками ErrNotFound, ErrForbidden и остальными.
+func (e *StatusError) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusBadRequest:
+		return target == ErrBadRequest
+	case http.StatusUnauthorized:
+		return target == ErrUnauthorized
+	case http.StatusForbidden:
+		return target == ErrForbidden
+	case http.StatusNotFound:
+		return target == ErrNotFound
+	case http.StatusRequestEntityTooLarge:
+		return target == ErrPayloadTooLarge
+	case http.StatusUnprocessableEntity:
+		return target == ErrCardinalityLimit
+	case http.StatusTooManyRequests:
+		return target == ErrTooManyRequests
+	}
+	return e.StatusCode >= 500 && target == ErrServer
+}
+
+// Retryable сообщает, имеет ли смысл повторять запрос с таким ответом.
+func (e *StatusError) Retryable() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Reporter копит метрики приложения в памяти и отправляет их батчем:
+// для гауджей — последнее значение, для счётчиков — сумму приращений.
+//
+//	r := client.NewReporter(c, 10*time.Second)
+//	go r.Run(ctx)
+//	r.Counter("requests", 1)
+//
+// Если отправка не удалась, значения возвращаются в буфер и уйдут со следующим батчем.
+type Reporter struct {
+	client   *Client
+	interval time.Duration
+	// OnError вызывается при ошибке отправки, nil — ошибки игнорируются
+	OnError func(err error)
+
+	mux      sync.Mutex
+	gauges   map[string]float64
+	counters map[string]int64
+}
+
+func NewReporter(c *Client, interval time.Duration) *Reporter {
+	return &Reporter{
+		client:   c,
+		interval: interval,
+		gauges:   make(map[string]float64),
+		counters: make(map[string]int64),
+	}
+}
+
+// Gauge запоминает значение гауджа, предыдущее неотправленное значение заменяется.
+func (r *Reporter) Gauge(id string, value float64) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.gauges[id] = value
+}
+
+// Counter прибавляет delta к неотправленному приращению счётчика.
+func (r *Reporter) Counter(id string, delta int64) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.counters[id] += delta
+}
+
+// Flush отправляет накопленное одним батчем.
+func (r *Reporter) Flush(ctx context.Context) error {
+	r.mux.Lock()
+	gauges, counters := r.gauges, r.counters
+	r.gauges, r.counters = make(map[string]float64), make(map[string]int64)
+	r.mux.Unlock()
+	if len(gauges)+len(counters) == 0 {
+		return nil
+	}
+	batch := make([]Metric, 0, len(gauges)+len(counters))
+	for id, value := range gauges {
+		batch = append(batch, Metric{ID: id, MType: "gauge", Value: &value})
+	}
+	for id, delta := range counters {
+		batch = append(batch, Metric{ID: id, MType: "counter", Delta: &delta})
+	}
+	if _, err := r.client.UpdateBatch(ctx, batch); err != nil {
+		r.restore(gauges, counters)
+		return err
+	}
+	return nil
+}
+
+// restore возвращает неотправленные значения: более свежие гауджи не затираются,
+// приращения счётчиков складываются.
+func (r *Reporter) restore(gauges map[string]float64, counters map[string]int64) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for id, value := range gauges {
+		if _, ok := r.gauges[id]; !ok {
+			r.gauges[id] = value
+		}
+	}
+	for id, delta := range counters {
+		r.counters[id] += delta
+	}
+}
+
+// Run отправляет накопленное раз в интервал до отмены ctx и делает последнюю отправку при выходе.
+func (r *Reporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// контекст уже отменён, поэтому последняя отправка идёт со своим таймаутом
+			flushCtx, cancel := context.WithTimeout(context.Background(), r.interval)
+			r.report(r.Flush(flushCtx))
+			cancel()
+			return
+		case <-ticker.C:
+			r.report(r.Flush(ctx))
+		}
+	}
+}
+
+func (r *Reporter) report(err error) {
+	if err != nil && r.OnError != nil {
+		r.OnError(err)
+	}
+}
@@ -0,0 +1,161 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultRetryDelays — паузы перед повторными попытками по умолчанию.
+var DefaultRetryDelays = []time.Duration{1 * time.Second, 3 * time.Second, 5 * time.Second}
+
+// RetryPolicy повторяет запрос при сетевых ошибках и ответах 5xx/429.
+// Пауза из заголовка Retry-After ограничивается самой длинной паузой из Delays.
+type RetryPolicy struct {
+	Retries int             // число повторов после первой попытки
+	Delays  []time.Duration // паузы перед повторами, последняя используется для всех следующих
+	Jitter  float64         // доля случайного отклонения паузы, 0.2 — ±20%
+}
+
+func NewRetryPolicy(retries int, delays []time.Duration) RetryPolicy {
+	if len(delays) == 0 {
+		delays = DefaultRetryDelays
+	}
+	return RetryPolicy{
+		Retries: retries,
+		Delays:  delays,
+		Jitter:  0.2,
+	}
+}
+
+// Do выполняет do до успешного ответа 2xx или неповторяемой ошибки.
+// do вызывается заново на каждую попытку, поэтому тело запроса нужно создавать внутри.
+// При успехе вызывающий закрывает тело ответа сам. Нулевая политика делает одну попытку.
+func (p RetryPolicy) Do(ctx context.Context, do func() (*http.Response, error)) (*http.Response, error) {
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		resp, err := do()
+		var wait time.Duration
+		switch {
+		case err != nil:
+			if ctx.Err() != nil || !isRetryableError(err) {
+				return nil, err
+			}
+			lastErr = err
+		case resp.StatusCode < 200 || resp.StatusCode >= 300:
+			statusErr := newStatusError(resp)
+			// дочитываем тело, чтобы соединение вернулось в пул
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close() //nolint:gosec // response.Body.Close() error is intentionally ignored
+			if !statusErr.Retryable() {
+				return nil, statusErr
+			}
+			lastErr = statusErr
+			// сервер не должен заставить клиента ждать дольше, чем позволяет политика
+			wait = min(statusErr.RetryAfter, p.maxDelay())
+		default:
+			return resp, nil
+		}
+		if attempt >= p.Retries {
+			break
+		}
+		if wait == 0 {
+			wait = p.delay(attempt)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("retry interrupted: %w", ctx.Err())
+		case <-timer.C:
+		}
+	}
+	return nil, fmt.Errorf("request failed after %d retries: %w", p.Retries, lastErr)
+}
+
+// maxDelay — самая длинная пауза политики, больше неё клиент не ждёт даже по Retry-After.
+func (p RetryPolicy) maxDelay() time.Duration {
+	var d time.Duration
+	for _, delay := range p.Delays {
+		d = max(d, delay)
+	}
+	return d
+}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	if len(p.Delays) == 0 {
+		return 0
+	}
+	d := p.Delays[min(attempt, len(p.Delays)-1)]
+	if p.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// isRetryableError отделяет временные сетевые ошибки от ошибок, которые повтор не исправит.
+func isRetryableError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// parseRetryAfter разбирает заголовок Retry-After в секундах или в виде HTTP-даты.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// ParseRetryDelays разбирает список пауз вида "1s,3s,5s".
+func ParseRetryDelays(v string) ([]time.Duration, error) {
+	var delays []time.Duration
+	for _, s := range strings.Split(v, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid retry delay %q", s)
+		}
+		delays = append(delays, d)
+	}
+	return delays, nil
+}
@@ -0,0 +1,190 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRetryPolicy(retries int) RetryPolicy {
+	return RetryPolicy{
+		Retries: retries,
+		Delays:  []time.Duration{time.Millisecond, 2 * time.Millisecond},
+	}
+}
+
+func failingServer(t *testing.T, failures int32, failStatus int, header http.Header) (*httptest.Server, *int32) {
+	t.Helper()
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= failures {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(failStatus)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &calls
+}
+
+func TestRetryPolicy_Do(t *testing.T) {
+	tests := []struct {
+		name        string
+		retries     int
+		delays      []time.Duration
+		failures    int32
+		failStatus  int
+		header      http.Header
+		wantErr     bool
+		wantStatus  int
+		wantCalls   int32
+		minDuration time.Duration
+		maxDuration time.Duration
+	}{
+		{
+			name:       "1 success without retries",
+			retries:    3,
+			wantCalls:  1,
+			failStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "2 retry on 5xx until success",
+			retries:    3,
+			failures:   2,
+			failStatus: http.StatusServiceUnavailable,
+			wantCalls:  3,
+		},
+		{
+			name:       "3 give up after retries",
+			retries:    2,
+			failures:   10,
+			failStatus: http.StatusBadGateway,
+			wantErr:    true,
+			wantStatus: http.StatusBadGateway,
+			wantCalls:  3,
+		},
+		{
+			name:       "4 no retry on 4xx",
+			retries:    3,
+			failures:   10,
+			failStatus: http.StatusBadRequest,
+			wantErr:    true,
+			wantStatus: http.StatusBadRequest,
+			wantCalls:  1,
+		},
+		{
+			name:        "5 retry on 429 honouring Retry-After",
+			retries:     3,
+			delays:      []time.Duration{time.Millisecond, 2 * time.Second},
+			failures:    1,
+			failStatus:  http.StatusTooManyRequests,
+			header:      http.Header{"Retry-After": []string{"1"}},
+			wantCalls:   2,
+			minDuration: time.Second,
+		},
+		{
+			name:        "6 Retry-After is capped by the longest delay",
+			retries:     3,
+			failures:    2,
+			failStatus:  http.StatusServiceUnavailable,
+			header:      http.Header{"Retry-After": []string{"3600"}},
+			wantCalls:   3,
+			maxDuration: 5 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, calls := failingServer(t, tt.failures, tt.failStatus, tt.header)
+			policy := testRetryPolicy(tt.retries)
+			if tt.delays != nil {
+				policy.Delays = tt.delays
+			}
+			start := time.Now()
+			resp, err := policy.Do(context.Background(), func() (*http.Response, error) {
+				return ts.Client().Post(ts.URL, "text/plain", nil)
+			})
+			if tt.wantErr {
+				require.Error(t, err)
+				var statusErr *StatusError
+				require.ErrorAs(t, err, &statusErr)
+				assert.Equal(t, tt.wantStatus, statusErr.StatusCode)
+			} else {
+				require.NoError(t, err)
+				resp.Body.Close()
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+			}
+			assert.Equal(t, tt.wantCalls, atomic.LoadInt32(calls))
+			assert.GreaterOrEqual(t, time.Since(start), tt.minDuration)
+			if tt.maxDuration > 0 {
+				assert.Less(t, time.Since(start), tt.maxDuration)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_DoConnectionRefused(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	url := ts.URL
+	ts.Close()
+
+	var calls int
+	_, err := testRetryPolicy(2).Do(context.Background(), func() (*http.Response, error) {
+		calls++
+		return http.Post(url, "text/plain", nil)
+	})
+	require.Error(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestRetryPolicy_DoNotRetryable(t *testing.T) {
+	var calls int
+	_, err := testRetryPolicy(3).Do(context.Background(), func() (*http.Response, error) {
+		calls++
+		return nil, errors.New("malformed request")
+	})
+	require.Error(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryPolicy_DoCanceled(t *testing.T) {
+	ts, calls := failingServer(t, 10, http.StatusServiceUnavailable, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := RetryPolicy{Retries: 3, Delays: []time.Duration{time.Hour}}
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err := policy.Do(ctx, func() (*http.Response, error) {
+		return ts.Client().Post(ts.URL, "text/plain", nil)
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int32(1), atomic.LoadInt32(calls))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, 5*time.Second, parseRetryAfter("5", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+	assert.Equal(t, 10*time.Second, parseRetryAfter(now.Add(10*time.Second).Format(http.TimeFormat), now))
+}
+
+func TestRetryPolicy_maxDelay(t *testing.T) {
+	assert.Equal(t, 5*time.Second, RetryPolicy{Delays: []time.Duration{time.Second, 5 * time.Second, 3 * time.Second}}.maxDelay())
+	assert.Equal(t, time.Duration(0), RetryPolicy{}.maxDelay())
+}
+
+func TestRetryPolicy_delay(t *testing.T) {
+	policy := RetryPolicy{Delays: DefaultRetryDelays, Jitter: 0.2}
+	for attempt, base := range []time.Duration{time.Second, 3 * time.Second, 5 * time.Second, 5 * time.Second} {
+		d := policy.delay(attempt)
+		assert.InDelta(t, float64(base), float64(d), 0.2*float64(base))
+	}
+}
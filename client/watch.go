@@ -0,0 +1,105 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Filter отбирает события подписки по типу и префиксу имени метрики.
+type Filter struct {
+	Type   string
+	Prefix string
+}
+
+// Event — обновление метрики, принятое сервером.
+type Event struct {
+	ID     uint64
+	Metric Metric
+}
+
+// Watch подписывается на обновления через /stream и вызывает fn на каждое событие,
+// пока не отменён ctx, не закрыт поток или fn не вернула ошибку. lastEventID > 0
+// запрашивает события, пропущенные после этого идентификатора.
+func (c *Client) Watch(ctx context.Context, filter Filter, lastEventID uint64, fn func(Event) error) error {
+	query := url.Values{}
+	if filter.Type != "" {
+		query.Set("type", filter.Type)
+	}
+	if filter.Prefix != "" {
+		query.Set("prefix", filter.Prefix)
+	}
+	target := c.base + "/stream"
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, http.NoBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastEventID > 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatUint(lastEventID, 10))
+	}
+	c.setHeaders(req)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError(resp)
+	}
+	err = readEvents(resp.Body, fn)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// readEvents разбирает поток text/event-stream. Комментарии (keep-alive) пропускаются.
+func readEvents(r io.Reader, fn func(Event) error) error {
+	sc := bufio.NewScanner(r)
+	var (
+		ev      Event
+		data    strings.Builder
+		isEvent bool
+	)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			if isEvent && data.Len() > 0 {
+				if err := json.Unmarshal([]byte(data.String()), &ev.Metric); err != nil {
+					return fmt.Errorf("cannot decode event: %w", err)
+				}
+				if err := fn(ev); err != nil {
+					return err
+				}
+			}
+			ev, isEvent = Event{}, false
+			data.Reset()
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			ev.ID, _ = strconv.ParseUint(value, 10, 64)
+		case "event":
+			isEvent = value == "update"
+		case "data":
+			data.WriteString(value)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return errors.New("stream closed by server")
+}
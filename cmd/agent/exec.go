@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"monalert/internal/logger"
+	"monalert/internal/models"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxExecOutput ограничивает объём stdout, который читается от одной команды.
+const maxExecOutput = 1 << 20
+
+// ExecCommand описывает внешнюю команду, stdout которой агент превращает в метрики.
+// Команда печатает либо строки вида "name type value", либо JSON-массив models.Metrics.
+type ExecCommand struct {
+	Name     string   `json:"name"`     // имя команды, используется в метрике <name>.exec_errors
+	Command  []string `json:"command"`  // путь к исполняемому файлу и аргументы
+	Interval string   `json:"interval"` // интервал запуска, например "30s"
+	Timeout  string   `json:"timeout"`  // максимальное время работы команды, например "5s"
+
+	interval time.Duration
+	timeout  time.Duration
+}
+
+func LoadExecCommands(path string, defaultInterval time.Duration) ([]ExecCommand, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read exec config: %w", err)
+	}
+	var commands []ExecCommand
+	if err := json.Unmarshal(data, &commands); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal exec config: %w", err)
+	}
+	for i := range commands {
+		c := &commands[i]
+		if c.Name == "" {
+			return nil, fmt.Errorf("exec command %d: empty name", i)
+		}
+		if len(c.Command) == 0 {
+			return nil, fmt.Errorf("exec command %s: empty command", c.Name)
+		}
+		c.interval = defaultInterval
+		if c.Interval != "" {
+			c.interval, err = time.ParseDuration(c.Interval)
+			if err != nil || c.interval <= 0 {
+				return nil, fmt.Errorf("exec command %s: invalid interval %q", c.Name, c.Interval)
+			}
+		}
+		c.timeout = c.interval
+		if c.Timeout != "" {
+			c.timeout, err = time.ParseDuration(c.Timeout)
+			if err != nil || c.timeout <= 0 {
+				return nil, fmt.Errorf("exec command %s: invalid timeout %q", c.Name, c.Timeout)
+			}
+		}
+	}
+	return commands, nil
+}
+
+// ExecCollector запускает команду со своим интервалом и добавляет полученные метрики в очередь на отправку.
+func (cm *CollectedMetricPolls) ExecCollector(c ExecCommand) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		cm.Add(runExecCommand(c))
+		<-ticker.C
+	}
+}
+
+func runExecCommand(c ExecCommand) *MetricPoll {
+	poll := NewMetricPoll()
+	poll.PollNumber = atomic.LoadInt64(&pollID)
+	errorsID := c.Name + ".exec_errors"
+
+	out, err := execOutput(c)
+	if err == nil {
+		err = parseExecOutput(out, poll)
+	}
+	if err != nil {
+		logger.Log.Error("exec collector failed", zap.String("command", c.Name), zap.Error(err))
+		// частично разобранный вывод не отправляем
+		poll = NewMetricPoll()
+		poll.PollNumber = atomic.LoadInt64(&pollID)
+		poll.CounterMetrics[errorsID] = 1
+		return poll
+	}
+	poll.CounterMetrics[errorsID] = 0
+	return poll
+}
+
+func execOutput(c ExecCommand) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, c.Command[0], c.Command[1:]...)
+	killProcessGroup(cmd)
+	// если дочерние процессы держат stdout открытым, не ждём их дольше секунды
+	cmd.WaitDelay = time.Second
+	stdout := &limitedBuffer{limit: maxExecOutput}
+	stderr := &limitedBuffer{limit: 4096}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("command timed out after %s: %w", c.timeout, ctx.Err())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("command failed: %w, stderr: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.truncated {
+		return nil, fmt.Errorf("command output exceeds %d bytes", maxExecOutput)
+	}
+	return stdout.Bytes(), nil
+}
+
+// parseExecOutput добавляет в poll метрики из вывода команды. Метрики проверяются так же,
+// как на сервере: одна некорректная метрика, например с зарезервированным именем,
+// иначе сорвала бы отправку всего батча.
+func parseExecOutput(out []byte, poll *MetricPoll) error {
+	trimmed := bytes.TrimSpace(out)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var metrics []models.Metrics
+		if err := json.Unmarshal(trimmed, &metrics); err != nil {
+			return fmt.Errorf("cannot unmarshal command output: %w", err)
+		}
+		for i, m := range metrics {
+			if err := models.ValidateMetric(&m); err != nil {
+				return fmt.Errorf("metric %d in command output: %w", i, err)
+			}
+			addExecMetric(poll, m)
+		}
+		return nil
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(trimmed))
+	for lineNum := 1; sc.Scan(); lineNum++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return fmt.Errorf("line %d: expected \"name type value\", got %q", lineNum, line)
+		}
+		m := models.Metrics{ID: fields[0], MType: fields[1]}
+		value := fields[2]
+		switch m.MType {
+		case "gauge":
+			val, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid gauge value %q", lineNum, value)
+			}
+			m.Value = &val
+		case "counter":
+			val, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid counter value %q", lineNum, value)
+			}
+			m.Delta = &val
+		default:
+			return fmt.Errorf("line %d: unsupported metric type %q", lineNum, m.MType)
+		}
+		if err := models.ValidateMetric(&m); err != nil {
+			return fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		addExecMetric(poll, m)
+	}
+	return sc.Err()
+}
+
+// addExecMetric добавляет проверенную метрику в опрос. Приращения одного счётчика
+// складываются, а накопленные значения, как и гауджи, заменяются последним.
+func addExecMetric(poll *MetricPoll, m models.Metrics) {
+	switch {
+	case m.MType == "gauge":
+		poll.GaugeMetrics[m.ID] = *m.Value
+	case m.Cumulative:
+		poll.CumulativeMetrics[m.ID] = *m.Delta
+	default:
+		poll.CounterMetrics[m.ID] += *m.Delta
+	}
+}
+
+// limitedBuffer накапливает не больше limit байт и молча отбрасывает остальное,
+// чтобы болтливая команда не съела память агента.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); len(p) > room {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// killProcessGroup на платформах без групп процессов полагается на exec.CommandContext.
+func killProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExecOutput(t *testing.T) {
+	tests := []struct {
+		name       string
+		out        string
+		gauges     map[string]float64
+		counters   map[string]int64
+		cumulative map[string]int64
+		wantErr    string
+	}{
+		{
+			name:     "lines",
+			out:      "# queue stats\nqueue.depth gauge 12.5\n\nqueue.processed counter 3\nqueue.processed counter 4\n",
+			gauges:   map[string]float64{"queue.depth": 12.5},
+			counters: map[string]int64{"queue.processed": 7},
+		},
+		{
+			name:       "json",
+			out:        ` [{"id":"cert.expiry","type":"gauge","value":86400},{"id":"jobs","type":"counter","delta":2},{"id":"total","type":"counter","delta":10,"cumulative":true}] `,
+			gauges:     map[string]float64{"cert.expiry": 86400},
+			counters:   map[string]int64{"jobs": 2},
+			cumulative: map[string]int64{"total": 10},
+		},
+		{name: "empty output"},
+		{name: "too few fields", out: "queue.depth 12\n", wantErr: `line 1: expected "name type value", got "queue.depth 12"`},
+		{name: "too many fields", out: "a gauge 1 2\n", wantErr: `line 1: expected "name type value"`},
+		{name: "bad type", out: "a histogram 1\n", wantErr: `line 1: unsupported metric type "histogram"`},
+		{name: "bad gauge value", out: "a gauge one\n", wantErr: `line 1: invalid gauge value "one"`},
+		{name: "non-finite gauge", out: "a gauge NaN\n", wantErr: "line 1: gauge a has non-finite value"},
+		{name: "fractional counter", out: "ok gauge 1\na counter 1.5\n", wantErr: `line 2: invalid counter value "1.5"`},
+		{name: "reserved name", out: "monalert_series gauge 1\n", wantErr: "line 1: metric name monalert_series is reserved for server metrics"},
+		{name: "json without id", out: `[{"type":"gauge","value":1}]`, wantErr: "metric 0 in command output: empty metric id"},
+		{name: "json without value", out: `[{"id":"a","type":"gauge"}]`, wantErr: "metric 0 in command output: gauge a without value"},
+		{name: "json bad type", out: `[{"id":"a","type":"summary","value":1}]`, wantErr: "metric 0 in command output: unsupported metric type: summary"},
+		{name: "json reserved name", out: `[{"id":"a","type":"gauge","value":1},{"id":"monalert_series","type":"gauge","value":1}]`, wantErr: "metric 1 in command output"},
+		{name: "malformed json", out: `[{"id":`, wantErr: "cannot unmarshal command output"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			poll := NewMetricPoll()
+			err := parseExecOutput([]byte(tt.out), poll)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, orEmpty(tt.gauges), poll.GaugeMetrics)
+			assert.Equal(t, orEmpty(tt.counters), poll.CounterMetrics)
+			assert.Equal(t, orEmpty(tt.cumulative), poll.CumulativeMetrics)
+		})
+	}
+}
+
+func TestRunExecCommandErrors(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	c := ExecCommand{Name: "broken", Command: []string{"sh", "-c", "echo 'a gauge 1'; echo 'bad line'"}, timeout: 5 * time.Second}
+	poll := runExecCommand(c)
+	// частично разобранный вывод отбрасывается, остаётся только счётчик ошибок
+	assert.Empty(t, poll.GaugeMetrics)
+	assert.Equal(t, map[string]int64{"broken.exec_errors": 1}, poll.CounterMetrics)
+}
+
+func orEmpty[V any](m map[string]V) map[string]V {
+	if m == nil {
+		return map[string]V{}
+	}
+	return m
+}
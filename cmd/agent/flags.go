@@ -3,8 +3,10 @@ package main
 import (
 	"flag"
 	"log"
+	"monalert/internal/compress"
 	"os"
 	"strconv"
+	"strings"
 )
 
 type Config struct {
@@ -16,6 +18,20 @@ var (
 	flagPollInterval   int
 	flagUseJSON        bool
 	flagLogLevel       string
+	flagScrapeConfig   string
+	flagExecConfig     string
+	flagSpoolDir       string
+	flagSpoolMaxBytes  int64
+	flagSpoolMaxAge    int
+	flagRetries        int
+	flagRetryDelays    string
+	flagToken          string
+	flagScheme         string
+	flagTLSCA          string
+	flagTLSCert        string
+	flagTLSKey         string
+	flagKey            string
+	flagCompression    string
 )
 
 func parseFlags() {
@@ -24,6 +40,20 @@ func parseFlags() {
 	flag.IntVar(&flagPollInterval, "p", 1, "interval for collecting metrics")
 	flag.BoolVar(&flagUseJSON, "j", false, "use JSON for metric sender")
 	flag.StringVar(&flagLogLevel, "l", "INFO", "logger level")
+	flag.StringVar(&flagScrapeConfig, "scrape-config", "", "JSON file with Prometheus targets to scrape")
+	flag.StringVar(&flagExecConfig, "exec-config", "", "JSON file with commands producing custom metrics")
+	flag.StringVar(&flagSpoolDir, "spool-dir", "", "directory for batches that failed to send, disabled if empty")
+	flag.Int64Var(&flagSpoolMaxBytes, "spool-max-bytes", 64<<20, "max total size of spooled batches")
+	flag.IntVar(&flagSpoolMaxAge, "spool-max-age", 86400, "max age of spooled batch in seconds")
+	flag.IntVar(&flagRetries, "retries", 3, "number of retries for failed requests")
+	flag.StringVar(&flagToken, "token", "", "API token sent to the server as a bearer token")
+	flag.StringVar(&flagRetryDelays, "retry-delays", "1s,3s,5s", "comma-separated delays between retries")
+	flag.StringVar(&flagScheme, "scheme", "http", "scheme for server requests: http or https")
+	flag.StringVar(&flagTLSCA, "tls-ca", "", "CA bundle to verify the server certificate, system roots if empty")
+	flag.StringVar(&flagTLSCert, "tls-cert", "", "client certificate for mutual TLS")
+	flag.StringVar(&flagTLSKey, "tls-key", "", "client private key for mutual TLS")
+	flag.StringVar(&flagKey, "k", "", "key for HMAC-SHA256 signature of requests")
+	flag.StringVar(&flagCompression, "compression", "gzip", "compression of request bodies: gzip, deflate, zstd, br or none")
 	flag.Parse()
 
 	if envRunAddr := os.Getenv("ADDRESS"); envRunAddr != "" {
@@ -43,4 +73,64 @@ func parseFlags() {
 		}
 		flagReportInterval = envReportInterval
 	}
+	if envScrapeConfig := os.Getenv("SCRAPE_CONFIG"); envScrapeConfig != "" {
+		flagScrapeConfig = envScrapeConfig
+	}
+	if envExecConfig := os.Getenv("EXEC_CONFIG"); envExecConfig != "" {
+		flagExecConfig = envExecConfig
+	}
+	if envSpoolDir := os.Getenv("SPOOL_DIR"); envSpoolDir != "" {
+		flagSpoolDir = envSpoolDir
+	}
+	if v := os.Getenv("SPOOL_MAX_BYTES"); v != "" {
+		envSpoolMaxBytes, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Fatalf("invalid SPOOL_MAX_BYTES=%q: %v", v, err)
+		}
+		flagSpoolMaxBytes = envSpoolMaxBytes
+	}
+	if v := os.Getenv("SPOOL_MAX_AGE"); v != "" {
+		envSpoolMaxAge, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("invalid SPOOL_MAX_AGE=%q: %v", v, err)
+		}
+		flagSpoolMaxAge = envSpoolMaxAge
+	}
+	if v := os.Getenv("RETRIES"); v != "" {
+		envRetries, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("invalid RETRIES=%q: %v", v, err)
+		}
+		flagRetries = envRetries
+	}
+	if envRetryDelays := os.Getenv("RETRY_DELAYS"); envRetryDelays != "" {
+		flagRetryDelays = envRetryDelays
+	}
+	if envToken := os.Getenv("TOKEN"); envToken != "" {
+		flagToken = envToken
+	}
+	if envScheme := os.Getenv("SCHEME"); envScheme != "" {
+		flagScheme = envScheme
+	}
+	if envTLSCA := os.Getenv("TLS_CA"); envTLSCA != "" {
+		flagTLSCA = envTLSCA
+	}
+	if envTLSCert := os.Getenv("TLS_CERT"); envTLSCert != "" {
+		flagTLSCert = envTLSCert
+	}
+	if envTLSKey := os.Getenv("TLS_KEY"); envTLSKey != "" {
+		flagTLSKey = envTLSKey
+	}
+	if envKey := os.Getenv("KEY"); envKey != "" {
+		flagKey = envKey
+	}
+	if envCompression := os.Getenv("COMPRESSION"); envCompression != "" {
+		flagCompression = envCompression
+	}
+	if _, ok := compress.Default().Get(flagCompression); !ok && flagCompression != "none" {
+		log.Fatalf("invalid compression %q: must be one of %s or none", flagCompression, strings.Join(compress.Default().Names(), ", "))
+	}
+	if flagScheme != "http" && flagScheme != "https" {
+		log.Fatalf("invalid scheme %q: must be http or https", flagScheme)
+	}
 }
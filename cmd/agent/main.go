@@ -1,16 +1,16 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"math/rand"
-	"monalert/internal/compress"
+	"monalert/client"
 	"monalert/internal/logger"
 	"monalert/internal/models"
+	"monalert/internal/tlsutil"
 	"net/http"
 	"runtime"
 	"strconv"
@@ -23,9 +23,13 @@ import (
 )
 
 type MetricPoll struct {
+	// приращения счётчиков; при постановке в очередь они переводятся в CumulativeMetrics,
+	// а отправляются только из сегментов очереди, записанных прежними версиями агента
 	CounterMetrics map[string]int64
 	GaugeMetrics   map[string]float64
-	PollNumber     int64
+	// абсолютные значения счётчиков, которые сервер сам переводит в приращения
+	CumulativeMetrics map[string]int64 `json:",omitempty"`
+	PollNumber        int64
 }
 
 var pollID int64
@@ -33,24 +37,37 @@ var pollID int64
 type CollectedMetricPolls struct {
 	mux   *sync.Mutex
 	Items []*MetricPoll
+	spool *Spool
+	// накопленные с запуска агента значения его счётчиков
+	totals map[string]int64
 }
 
 func NewMetricPoll() *MetricPoll {
 	return &MetricPoll{
-		CounterMetrics: make(map[string]int64),
-		GaugeMetrics:   make(map[string]float64),
+		CounterMetrics:    make(map[string]int64),
+		GaugeMetrics:      make(map[string]float64),
+		CumulativeMetrics: make(map[string]int64),
 	}
 }
 
 func NewCollectedMetricPoll() CollectedMetricPolls {
 	return CollectedMetricPolls{
-		mux: &sync.Mutex{},
+		mux:    &sync.Mutex{},
+		totals: make(map[string]int64),
 	}
 }
 
+// Add ставит опрос в очередь на отправку. Приращения счётчиков опроса заменяются
+// накопленными итогами: сервер сам переводит итог в приращение, поэтому повтор запроса,
+// ответ на который потерялся, не задваивает счётчик.
 func (cm *CollectedMetricPolls) Add(mp *MetricPoll) {
 	cm.mux.Lock()
 	defer cm.mux.Unlock()
+	for name, delta := range mp.CounterMetrics {
+		cm.totals[name] += delta
+		mp.CumulativeMetrics[name] = cm.totals[name]
+	}
+	clear(mp.CounterMetrics)
 	cm.Items = append(cm.Items, mp)
 }
 
@@ -62,6 +79,38 @@ func (cm *CollectedMetricPolls) Swap() []*MetricPoll {
 	return toSend
 }
 
+// Requeue возвращает неотправленные опросы в начало очереди. Опросы сливаются в один,
+// чтобы приращения счётчиков не потерялись и не задвоились, а память агента
+// не росла, пока сервер недоступен.
+func (cm *CollectedMetricPolls) Requeue(polls []*MetricPoll) {
+	if len(polls) == 0 {
+		return
+	}
+	merged := mergePolls(polls)
+	cm.mux.Lock()
+	defer cm.mux.Unlock()
+	cm.Items = append([]*MetricPoll{merged}, cm.Items...)
+}
+
+// mergePolls складывает приращения счётчиков, а для гауджей и накопительных
+// счётчиков оставляет самое свежее значение.
+func mergePolls(polls []*MetricPoll) *MetricPoll {
+	merged := NewMetricPoll()
+	for _, poll := range polls {
+		for name, delta := range poll.CounterMetrics {
+			merged.CounterMetrics[name] += delta
+		}
+		for name, value := range poll.GaugeMetrics {
+			merged.GaugeMetrics[name] = value
+		}
+		for name, value := range poll.CumulativeMetrics {
+			merged.CumulativeMetrics[name] = value
+		}
+		merged.PollNumber = poll.PollNumber
+	}
+	return merged
+}
+
 func CollectMetrics() *MetricPoll {
 	poll := NewMetricPoll()
 	var rtm runtime.MemStats
@@ -94,9 +143,9 @@ func CollectMetrics() *MetricPoll {
 	poll.GaugeMetrics["Sys"] = float64(rtm.Sys)
 	poll.GaugeMetrics["TotalAlloc"] = float64(rtm.TotalAlloc)
 	poll.GaugeMetrics["RandomValue"] = rand.Float64()
-	poll.CounterMetrics["PollCount"] = pollID
-	poll.PollNumber = pollID
-	atomic.AddInt64(&pollID, 1)
+	// приращение — один опрос; в очереди оно превращается в число опросов с запуска агента
+	poll.CounterMetrics["PollCount"] = 1
+	poll.PollNumber = atomic.AddInt64(&pollID, 1)
 	return poll
 }
 
@@ -114,138 +163,232 @@ func (cm *CollectedMetricPolls) Sender() {
 	c := time.Tick(reportInterval)
 	for range c {
 		batch := cm.Swap()
+		if cm.spool != nil {
+			batch = append(batch, cm.spool.SelfMetrics())
+			// сначала досылаем накопленное, чтобы сервер получал данные в порядке сбора
+			if err := cm.spool.Replay(Send); err != nil {
+				log.Printf("failed to replay spooled metric polls %v", err)
+				cm.spoolBatch(batch)
+				continue
+			}
+		}
 		if len(batch) == 0 {
 			continue
 		}
-		err := Send(batch)
+		rest, err := Send(batch)
 		if err != nil {
 			log.Printf("failed to send metric poll %v", err)
+			if cm.spool != nil {
+				cm.spoolBatch(rest)
+			} else {
+				cm.Requeue(rest)
+			}
 		}
 	}
 }
 
-func SendRequest(address string) error {
-	var lastErr error
-	for range 3 {
-		response, err := http.Post(address, "text/html; charset=utf-8", nil)
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				lastErr = err
-				continue
-			}
-			if errors.Is(err, io.EOF) || strings.Contains(err.Error(), "connection refused") {
-				lastErr = err
-				time.Sleep(100 * time.Millisecond)
-				continue
-			}
-			logger.Log.Error("error in sending request", zap.String("request:", address), zap.Error(err))
-			return fmt.Errorf("error in sending request: %w", err)
-		}
-		if response.StatusCode != http.StatusOK {
-			response.Body.Close() //nolint:gosec // response.Body.Close() error is intentionally ignored
-			logger.Log.Error("server returned status", zap.Int("code:", response.StatusCode))
-			return fmt.Errorf("server returned status: %d", response.StatusCode)
-		}
-		response.Body.Close() //nolint:gosec // response.Body.Close() error is intentionally ignored
-		return nil
+func (cm *CollectedMetricPolls) spoolBatch(batch []*MetricPoll) {
+	if err := cm.spool.Push(batch); err != nil {
+		logger.Log.Error("failed to spool metric polls", zap.Error(err))
 	}
-	return fmt.Errorf("request failed after retry: %w", lastErr)
 }
 
-func SendJSONRequest(buf *bytes.Buffer) error {
-	client := http.Client{
-		Timeout: 1 * time.Second,
+// apiClient отправляет метрики на сервер, создаётся в main после разбора флагов
+var apiClient *client.Client
+
+func newAPIClient(tlsConfig *tls.Config, retry client.RetryPolicy) *client.Client {
+	compression := flagCompression
+	if compression == "none" {
+		compression = ""
 	}
+	return client.New(client.Config{
+		Address:     flagServerAddr,
+		Scheme:      flagScheme,
+		Token:       flagToken,
+		TLS:         tlsConfig,
+		Compression: compression,
+		Key:         flagKey,
+		Timeout:     1 * time.Second,
+		Retry:       retry,
+		Header:      setRealIP,
+	})
+}
 
-	url := "http://" + flagServerAddr + "/update"
-	body, err := compress.Compress(buf.Bytes())
-	if err != nil {
-		return err
+// setRealIP передаёт адрес агента для проверки доверенной подсети на сервере.
+func setRealIP(req *http.Request) {
+	if ip := outboundIP(); ip != "" {
+		req.Header.Set("X-Real-IP", ip)
 	}
+}
 
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+// sendMetric отправляет одну метрику в пути запроса.
+func sendMetric(m *models.Metrics) error {
+	if err := apiClient.UpdateURL(context.Background(), m); err != nil {
+		logger.Log.Error("error in sending metric", zap.String("metric", m.ID), zap.Error(err))
+		return fmt.Errorf("error in sending metric: %w", err)
+	}
+	return nil
+}
 
-	if err != nil {
-		return err
+// pollMetrics собирает метрики опроса в один батч.
+func pollMetrics(poll *MetricPoll) []models.Metrics {
+	metrics := make([]models.Metrics, 0, len(poll.GaugeMetrics)+len(poll.CounterMetrics)+len(poll.CumulativeMetrics))
+	for m, v := range poll.GaugeMetrics {
+		metrics = append(metrics, models.Metrics{ID: m, MType: "gauge", Value: &v})
+	}
+	for m, v := range poll.CounterMetrics {
+		metrics = append(metrics, models.Metrics{ID: m, MType: "counter", Delta: &v})
+	}
+	for m, v := range poll.CumulativeMetrics {
+		metrics = append(metrics, models.Metrics{ID: m, MType: "counter", Delta: &v, Cumulative: true})
 	}
+	return metrics
+}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Content-Encoding", "gzip")
-	req.Header.Set("Accept-Encoding", "gzip")
+// removeMetric удаляет метрику из опроса, чтобы она не ушла на сервер повторно.
+func removeMetric(poll *MetricPoll, m models.Metrics) {
+	switch {
+	case m.MType == "gauge":
+		delete(poll.GaugeMetrics, m.ID)
+	case m.Cumulative:
+		delete(poll.CumulativeMetrics, m.ID)
+	default:
+		delete(poll.CounterMetrics, m.ID)
+	}
+}
 
-	resp, err := client.Do(req)
+// isPermanent сообщает, что сервер отверг запрос и повтор ничего не изменит,
+// например при 400 на некорректную метрику или 413 на слишком большой батч.
+// Сетевые ошибки и ответы 5xx и 429 временные.
+func isPermanent(err error) bool {
+	var statusErr *client.StatusError
+	return errors.As(err, &statusErr) && !statusErr.Retryable()
+}
 
-	if err != nil {
-		return fmt.Errorf("sending request failed: %w", err)
+// rejectedIndex находит номер метрики батча, на которую указывает ошибка сервера,
+// по полю вида "[3].delta".
+func rejectedIndex(err error, n int) (int, bool) {
+	var statusErr *client.StatusError
+	if !errors.As(err, &statusErr) || !strings.HasPrefix(statusErr.Field, "[") {
+		return 0, false
+	}
+	end := strings.IndexByte(statusErr.Field, ']')
+	if end < 0 {
+		return 0, false
 	}
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned status: %d", resp.StatusCode)
+	i, convErr := strconv.Atoi(statusErr.Field[1:end])
+	if convErr != nil || i < 0 || i >= n {
+		return 0, false
+	}
+	return i, true
+}
+
+// sendPoll отправляет опрос одним батчем. Метрику, которую сервер отверг окончательно,
+// агент пишет в лог и выбрасывает, а остальное отправляет снова; если сервер не указал
+// метрику, выбрасывается весь опрос. Ошибку возвращают только временные сбои, после
+// которых опрос стоит отправить позже.
+func sendPoll(poll *MetricPoll) error {
+	metrics := pollMetrics(poll)
+	for len(metrics) > 0 {
+		_, err := apiClient.UpdateBatch(context.Background(), metrics)
+		if err == nil {
+			return nil
+		}
+		if !isPermanent(err) {
+			logger.Log.Error("error in sending metric poll", zap.Int64("poll", poll.PollNumber), zap.Error(err))
+			return fmt.Errorf("error in sending metric poll: %w", err)
+		}
+		i, ok := rejectedIndex(err, len(metrics))
+		if !ok {
+			logger.Log.Error("server rejected metric poll, dropping it", zap.Int64("poll", poll.PollNumber), zap.Error(err))
+			*poll = *NewMetricPoll()
+			return nil
+		}
+		logger.Log.Error("server rejected metric, dropping it", zap.String("metric", metrics[i].ID), zap.Error(err))
+		removeMetric(poll, metrics[i])
+		metrics = append(metrics[:i], metrics[i+1:]...)
 	}
-	defer resp.Body.Close()
 	return nil
 }
 
-func Send(cm []*MetricPoll) error {
-	for _, poll := range cm {
-		if flagUseJSON {
-			logger.Log.Debug("using JSON for sending", zap.Bool("JSON flag", flagUseJSON))
-			for m, v := range poll.GaugeMetrics {
-				var buf bytes.Buffer
-				enc := json.NewEncoder(&buf)
-				if err := enc.Encode(&models.Metrics{
-					ID:    m,
-					MType: "gauge",
-					Value: &v,
-				}); err != nil {
-					logger.Log.Error("error encoding response", zap.Error(err))
-					return fmt.Errorf("error encoding response %w", err)
-				}
-				if err := SendJSONRequest(&buf); err != nil {
-					return err
-				}
-			}
-			for m, v := range poll.CounterMetrics {
-				var buf bytes.Buffer
-				enc := json.NewEncoder(&buf)
-				if err := enc.Encode(&models.Metrics{
-					ID:    m,
-					MType: "counter",
-					Delta: &v,
-				}); err != nil {
-					logger.Log.Error("error encoding response", zap.Error(err))
-					return err
-				}
-				if err := SendJSONRequest(&buf); err != nil {
-					return err
-				}
-			}
-		} else {
-			logger.Log.Debug("using URL for sending", zap.Bool("JSON flag", flagUseJSON))
-			for metricName, value := range poll.GaugeMetrics {
-				address := "http://" + flagServerAddr + "/update/gauge/" + metricName + "/" + strconv.FormatFloat(value, 'f', -1, 64)
-				if err := SendRequest(address); err != nil {
-					return err
-				}
-			}
-			for metricName, value := range poll.CounterMetrics {
-				address := "http://" + flagServerAddr + "/update/counter/" + metricName + "/" + strconv.FormatInt(value, 10)
-				if err := SendRequest(address); err != nil {
-					return err
-				}
+// sendPollURL отправляет метрики опроса по одной в пути запроса. Отправленные и окончательно
+// отвергнутые сервером метрики удаляются из опроса, поэтому после временного сбоя
+// в нём остаются только неотправленные.
+func sendPollURL(poll *MetricPoll) error {
+	for _, m := range pollMetrics(poll) {
+		if err := sendMetric(&m); err != nil {
+			if !isPermanent(err) {
+				return err
 			}
+			logger.Log.Error("server rejected metric, dropping it", zap.String("metric", m.ID), zap.Error(err))
 		}
-		logger.Log.Info("new poll sent", zap.Int64("poll:", poll.PollNumber))
+		removeMetric(poll, m)
 	}
 	return nil
 }
 
+// Send отправляет опросы на сервер: с флагом -j — каждый опрос одним батчем, иначе по метрике
+// в пути запроса. Отправленное удаляется из опросов, поэтому при ошибке возвращаются
+// только неотправленные опросы. Метрики, которые сервер отверг окончательно, не возвращаются:
+// повтор их не исправит, а в очереди они задержали бы всё, что собрано после.
+func Send(cm []*MetricPoll) ([]*MetricPoll, error) {
+	logger.Log.Debug("sending metrics", zap.Bool("JSON flag", flagUseJSON))
+	for i, poll := range cm {
+		send := sendPollURL
+		if flagUseJSON {
+			send = sendPoll
+		}
+		if err := send(poll); err != nil {
+			return cm[i:], err
+		}
+		logger.Log.Info("new poll sent", zap.Int64("poll:", poll.PollNumber))
+	}
+	return nil, nil
+}
+
 func main() {
 	parseFlags()
 	if err := logger.Initialize(flagLogLevel); err != nil {
 		log.Fatal(err)
 	}
+	delays, err := client.ParseRetryDelays(flagRetryDelays)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var tlsConfig *tls.Config
+	if flagScheme == "https" {
+		tlsConfig, err = tlsutil.ClientConfig(flagTLSCA, flagTLSCert, flagTLSKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	apiClient = newAPIClient(tlsConfig, client.NewRetryPolicy(flagRetries, delays))
 	collection := NewCollectedMetricPoll()
+	if flagSpoolDir != "" {
+		spool, err := OpenSpool(flagSpoolDir, flagSpoolMaxBytes, time.Duration(flagSpoolMaxAge)*time.Second)
+		if err != nil {
+			log.Fatal(err)
+		}
+		collection.spool = spool
+	}
+	go RegisterMetadata()
 	go collection.Collector()
+	if flagScrapeConfig != "" {
+		targets, err := LoadScrapeTargets(flagScrapeConfig, time.Duration(flagPollInterval)*time.Second)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go collection.ScrapeCollector(NewScraper(targets))
+	}
+	if flagExecConfig != "" {
+		commands, err := LoadExecCommands(flagExecConfig, time.Duration(flagPollInterval)*time.Second)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, c := range commands {
+			go collection.ExecCollector(c)
+		}
+	}
 	go collection.Sender()
 	select {}
 }
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"monalert/client"
+	"monalert/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServer отвечает как сервер monalert: метрику "bad" отвергает с 400,
+// а пока down == true, на всё отвечает 503.
+type fakeServer struct {
+	down     bool
+	received []string
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	reject := func(field string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"code":"invalid_value","message":"metric rejected","field":%q}`, field)
+	}
+	if r.URL.Path == "/updates/" {
+		var metrics []models.Metrics
+		if err := json.NewDecoder(r.Body).Decode(&metrics); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for i, m := range metrics {
+			if m.ID == "bad" {
+				reject(fmt.Sprintf("[%d].id", i))
+				return
+			}
+		}
+		for _, m := range metrics {
+			f.received = append(f.received, m.ID)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(metrics)
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 4 || parts[0] != "update" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if parts[2] == "bad" {
+		reject("id")
+		return
+	}
+	f.received = append(f.received, parts[2])
+}
+
+func setupSend(t *testing.T, useJSON bool) *fakeServer {
+	f := &fakeServer{}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	prevClient, prevJSON := apiClient, flagUseJSON
+	t.Cleanup(func() { apiClient, flagUseJSON = prevClient, prevJSON })
+	apiClient = client.New(client.Config{Address: strings.TrimPrefix(srv.URL, "http://")})
+	flagUseJSON = useJSON
+	return f
+}
+
+func sendPolls() []*MetricPoll {
+	first := NewMetricPoll()
+	first.PollNumber = 1
+	first.GaugeMetrics["good"] = 1
+	first.GaugeMetrics["bad"] = 2
+	first.CounterMetrics["hits"] = 3
+	second := NewMetricPoll()
+	second.PollNumber = 2
+	second.CumulativeMetrics["total"] = 10
+	return []*MetricPoll{first, second}
+}
+
+func TestSendDropsRejectedMetrics(t *testing.T) {
+	for _, useJSON := range []bool{true, false} {
+		t.Run(fmt.Sprintf("json=%v", useJSON), func(t *testing.T) {
+			f := setupSend(t, useJSON)
+			rest, err := Send(sendPolls())
+			require.NoError(t, err)
+			assert.Empty(t, rest)
+			assert.ElementsMatch(t, []string{"good", "hits", "total"}, f.received)
+		})
+	}
+}
+
+func TestSendReturnsUnsentPollsOnTransientError(t *testing.T) {
+	for _, useJSON := range []bool{true, false} {
+		t.Run(fmt.Sprintf("json=%v", useJSON), func(t *testing.T) {
+			f := setupSend(t, useJSON)
+			f.down = true
+			polls := sendPolls()
+			rest, err := Send(polls)
+			require.Error(t, err)
+			assert.Equal(t, polls, rest)
+			assert.Contains(t, rest[0].GaugeMetrics, "bad", "nothing is dropped on transient errors")
+
+			f.down = false
+			rest, err = Send(rest)
+			require.NoError(t, err)
+			assert.Empty(t, rest)
+			assert.ElementsMatch(t, []string{"good", "hits", "total"}, f.received)
+		})
+	}
+}
+
+func TestRejectedIndex(t *testing.T) {
+	tests := []struct {
+		field string
+		want  int
+		ok    bool
+	}{
+		{field: "[2].delta", want: 2, ok: true},
+		{field: "[0]", want: 0, ok: true},
+		{field: "[5].id"},
+		{field: "[-1].id"},
+		{field: "[x].id"},
+		{field: "id"},
+		{field: ""},
+	}
+	for _, tt := range tests {
+		i, ok := rejectedIndex(&client.StatusError{StatusCode: http.StatusBadRequest, Field: tt.field}, 3)
+		assert.Equal(t, tt.ok, ok, tt.field)
+		assert.Equal(t, tt.want, i, tt.field)
+	}
+	_, ok := rejectedIndex(fmt.Errorf("plain error"), 3)
+	assert.False(t, ok)
+}
+
+func TestAddSendsCounterTotals(t *testing.T) {
+	cm := NewCollectedMetricPoll()
+	for i := 0; i < 3; i++ {
+		poll := NewMetricPoll()
+		poll.CounterMetrics["PollCount"] = 1
+		poll.CounterMetrics["errors"] = int64(i)
+		cm.Add(poll)
+	}
+	var totals []int64
+	for _, poll := range cm.Items {
+		assert.Empty(t, poll.CounterMetrics)
+		totals = append(totals, poll.CumulativeMetrics["PollCount"])
+	}
+	assert.Equal(t, []int64{1, 2, 3}, totals)
+
+	// после неудачной отправки в очереди остаётся последний итог, а не сумма
+	cm.Requeue(cm.Swap())
+	require.Len(t, cm.Items, 1)
+	assert.Equal(t, map[string]int64{"PollCount": 3, "errors": 3}, cm.Items[0].CumulativeMetrics)
+}
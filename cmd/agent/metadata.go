@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"monalert/internal/logger"
+	"monalert/internal/models"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// runtimeMetadata описывает метрики, которые агент собирает из runtime.MemStats.
+var runtimeMetadata = map[string]models.Metadata{
+	"Alloc":         {Unit: "bytes", Type: "gauge", Help: "Bytes of allocated heap objects."},
+	"BuckHashSys":   {Unit: "bytes", Type: "gauge", Help: "Bytes of memory in profiling bucket hash tables."},
+	"Frees":         {Type: "gauge", Help: "Cumulative count of heap objects freed."},
+	"GCCPUFraction": {Type: "gauge", Display: "percent", Help: "Fraction of CPU time used by the GC since the program started."},
+	"GCSys":         {Unit: "bytes", Type: "gauge", Help: "Bytes of memory in garbage collection metadata."},
+	"HeapAlloc":     {Unit: "bytes", Type: "gauge", Help: "Bytes of allocated heap objects."},
+	"HeapIdle":      {Unit: "bytes", Type: "gauge", Help: "Bytes in idle (unused) spans."},
+	"HeapInuse":     {Unit: "bytes", Type: "gauge", Help: "Bytes in in-use spans."},
+	"HeapObjects":   {Type: "gauge", Help: "Number of allocated heap objects."},
+	"HeapReleased":  {Unit: "bytes", Type: "gauge", Help: "Bytes of physical memory returned to the OS."},
+	"HeapSys":       {Unit: "bytes", Type: "gauge", Help: "Bytes of heap memory obtained from the OS."},
+	"LastGC":        {Unit: "nanoseconds", Type: "gauge", Display: "timestamp", Help: "Time the last garbage collection finished."},
+	"Lookups":       {Type: "gauge", Help: "Number of pointer lookups performed by the runtime."},
+	"MCacheInuse":   {Unit: "bytes", Type: "gauge", Help: "Bytes of allocated mcache structures."},
+	"MCacheSys":     {Unit: "bytes", Type: "gauge", Help: "Bytes of memory obtained from the OS for mcache structures."},
+	"MSpanInuse":    {Unit: "bytes", Type: "gauge", Help: "Bytes of allocated mspan structures."},
+	"MSpanSys":      {Unit: "bytes", Type: "gauge", Help: "Bytes of memory obtained from the OS for mspan structures."},
+	"Mallocs":       {Type: "gauge", Help: "Cumulative count of heap objects allocated."},
+	"NextGC":        {Unit: "bytes", Type: "gauge", Help: "Target heap size of the next GC cycle."},
+	"NumForcedGC":   {Type: "gauge", Help: "Number of GC cycles forced by the application."},
+	"NumGC":         {Type: "gauge", Help: "Number of completed GC cycles."},
+	"OtherSys":      {Unit: "bytes", Type: "gauge", Help: "Bytes of memory in miscellaneous off-heap runtime allocations."},
+	"PauseTotalNs":  {Unit: "nanoseconds", Type: "gauge", Help: "Cumulative time spent in GC stop-the-world pauses."},
+	"StackInuse":    {Unit: "bytes", Type: "gauge", Help: "Bytes in stack spans."},
+	"StackSys":      {Unit: "bytes", Type: "gauge", Help: "Bytes of stack memory obtained from the OS."},
+	"Sys":           {Unit: "bytes", Type: "gauge", Help: "Total bytes of memory obtained from the OS."},
+	"TotalAlloc":    {Unit: "bytes", Type: "gauge", Help: "Cumulative bytes allocated for heap objects."},
+	"RandomValue":   {Type: "gauge", Help: "Random value in [0, 1)."},
+	"PollCount":     {Type: "counter", Help: "Number of metric polls made by the agent."},
+}
+
+// Паузы между попытками зарегистрировать описания: после каждой неудачи пауза удваивается.
+var (
+	metadataRetryDelay    = time.Second
+	metadataMaxRetryDelay = 5 * time.Minute
+)
+
+// RegisterMetadata сообщает серверу описания встроенных метрик агента.
+// Ошибки не мешают отправке метрик, поэтому только пишутся в лог. Пока сервер недоступен,
+// регистрация повторяется в фоне с растущей паузой; описание, которое сервер отверг
+// ответом 4xx, не повторяется.
+func RegisterMetadata() {
+	registerMetadata(runtimeMetadata, metadataRetryDelay, metadataMaxRetryDelay)
+}
+
+func registerMetadata(metadata map[string]models.Metadata, delay, maxDelay time.Duration) {
+	pending := maps.Clone(metadata)
+	for {
+		for name, md := range pending {
+			err := sendMetadata(name, md)
+			if err != nil && !isPermanent(err) {
+				// сервер недоступен: остальные описания тоже не пройдут
+				logger.Log.Warn("cannot register metric metadata, will retry",
+					zap.String("metric", name), zap.Duration("retry_in", delay), zap.Error(err))
+				break
+			}
+			if err != nil {
+				logger.Log.Error("server rejected metric metadata", zap.String("metric", name), zap.Error(err))
+			}
+			delete(pending, name)
+		}
+		if len(pending) == 0 {
+			logger.Log.Info("metric metadata registered", zap.Int("count", len(metadata)))
+			return
+		}
+		time.Sleep(delay)
+		delay = min(2*delay, maxDelay)
+	}
+}
+
+func sendMetadata(name string, md models.Metadata) error {
+	if err := apiClient.SetMetadata(context.Background(), name, md); err != nil {
+		return fmt.Errorf("error in sending metadata: %w", err)
+	}
+	return nil
+}
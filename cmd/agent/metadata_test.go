@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"monalert/client"
+	"monalert/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterMetadataRetries(t *testing.T) {
+	var (
+		mux      sync.Mutex
+		failures = 2
+		attempts int
+		stored   = map[string]models.Metadata{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		attempts++
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/meta/")
+		if name == "Broken" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var md models.Metadata
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&md))
+		stored[name] = md
+	}))
+	defer srv.Close()
+	prev := apiClient
+	t.Cleanup(func() { apiClient = prev })
+	apiClient = client.New(client.Config{Address: strings.TrimPrefix(srv.URL, "http://")})
+
+	metadata := map[string]models.Metadata{
+		"Alloc":  {Unit: "bytes", Type: "gauge"},
+		"NumGC":  {Type: "gauge"},
+		"Broken": {Type: "gauge"},
+	}
+	done := make(chan struct{})
+	go func() {
+		registerMetadata(metadata, time.Millisecond, 2*time.Millisecond)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("metadata registration did not finish")
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+	// две неудачные попытки, затем по одной на каждое описание; отвергнутое не повторяется
+	assert.Equal(t, 5, attempts)
+	assert.Equal(t, map[string]models.Metadata{
+		"Alloc": {Unit: "bytes", Type: "gauge"},
+		"NumGC": {Type: "gauge"},
+	}, stored)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"monalert/internal/logger"
+	"net"
+	"net/netip"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+var (
+	realIPMux sync.Mutex
+	realIP    string
+)
+
+// outboundIP определяет адрес интерфейса, через который агент ходит на сервер.
+// UDP-сокет ничего не отправляет: connect только выбирает маршрут и локальный адрес.
+// Удачный результат кешируется, неудачный — пробуем снова при следующем запросе.
+func outboundIP() string {
+	realIPMux.Lock()
+	defer realIPMux.Unlock()
+	if realIP != "" {
+		return realIP
+	}
+	conn, err := net.Dial("udp", flagServerAddr)
+	if err != nil {
+		logger.Log.Warn("cannot determine outbound address", zap.Error(err))
+		return ""
+	}
+	defer conn.Close()
+	addrPort, err := netip.ParseAddrPort(conn.LocalAddr().String())
+	if err != nil {
+		logger.Log.Warn("cannot parse outbound address", zap.Error(err))
+		return ""
+	}
+	realIP = addrPort.Addr().WithZone("").Unmap().String()
+	logger.Log.Info("outbound address detected", zap.String("X-Real-IP", realIP))
+	return realIP
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"monalert/internal/logger"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ScrapeTarget описывает один HTTP-эндпоинт в формате Prometheus, который агент опрашивает.
+type ScrapeTarget struct {
+	Name    string `json:"name"`    // префикс для имён метрик цели
+	URL     string `json:"url"`     // адрес эндпоинта, например http://localhost:9100/metrics
+	Timeout string `json:"timeout"` // таймаут опроса цели, например "2s"
+
+	timeout time.Duration
+}
+
+// Scraper опрашивает цели и превращает счётчики и гауджи в MetricPoll.
+// Счётчики Prometheus накопительные, поэтому отправляются как есть,
+// а приращение сервер считает сам.
+type Scraper struct {
+	targets []ScrapeTarget
+	client  *http.Client
+}
+
+func LoadScrapeTargets(path string, defaultTimeout time.Duration) ([]ScrapeTarget, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read scrape config: %w", err)
+	}
+	var targets []ScrapeTarget
+	if err := json.Unmarshal(data, &targets); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal scrape config: %w", err)
+	}
+	for i := range targets {
+		t := &targets[i]
+		if t.URL == "" {
+			return nil, fmt.Errorf("scrape target %d: empty url", i)
+		}
+		if t.Name == "" {
+			return nil, fmt.Errorf("scrape target %s: empty name", t.URL)
+		}
+		t.timeout = defaultTimeout
+		if t.Timeout != "" {
+			t.timeout, err = time.ParseDuration(t.Timeout)
+			if err != nil || t.timeout <= 0 {
+				return nil, fmt.Errorf("scrape target %s: invalid timeout %q", t.Name, t.Timeout)
+			}
+		}
+	}
+	return targets, nil
+}
+
+func NewScraper(targets []ScrapeTarget) *Scraper {
+	return &Scraper{
+		targets: targets,
+		client:  &http.Client{},
+	}
+}
+
+func (s *Scraper) Scrape() *MetricPoll {
+	poll := NewMetricPoll()
+	poll.PollNumber = atomic.LoadInt64(&pollID)
+	var (
+		wg  sync.WaitGroup
+		mux sync.Mutex
+	)
+	for _, target := range s.targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			samples, err := s.scrapeTarget(target)
+			duration := time.Since(start).Seconds()
+
+			mux.Lock()
+			defer mux.Unlock()
+			poll.GaugeMetrics[target.Name+".scrape_duration_seconds"] = duration
+			if err != nil {
+				logger.Log.Error("scrape failed", zap.String("target", target.Name), zap.Error(err))
+				poll.GaugeMetrics[target.Name+".scrape_success"] = 0
+				return
+			}
+			poll.GaugeMetrics[target.Name+".scrape_success"] = 1
+			for _, sample := range samples {
+				s.addSample(poll, target.Name+"."+sample.id(), sample)
+			}
+		}()
+	}
+	wg.Wait()
+	return poll
+}
+
+// addSample добавляет сэмпл в опрос. Тип серии задаёт только строка # TYPE, поэтому он
+// не меняется ни между опросами, ни между запусками агента. Счётчик сервера целочисленный,
+// поэтому накопленное значение счётчика округляется до целого: сервер считает приращение
+// между округлёнными значениями, и ошибка не накапливается. Отрицательный или не влезающий
+// в int64 счётчик пропускается.
+func (s *Scraper) addSample(poll *MetricPoll, id string, sample promSample) {
+	switch sample.kind {
+	case "counter":
+		value := math.Round(sample.value)
+		if value < 0 || value >= math.MaxInt64 {
+			logger.Log.Debug("scraped counter is out of range, skipping", zap.String("id", id), zap.Float64("value", sample.value))
+			return
+		}
+		poll.CumulativeMetrics[id] = int64(value)
+	case "gauge", "untyped", "":
+		poll.GaugeMetrics[id] = sample.value
+	}
+}
+
+func (s *Scraper) scrapeTarget(target ScrapeTarget) ([]promSample, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), target.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.URL, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/plain;version=0.0.4")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("scrape request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scrape target returned status: %d", resp.StatusCode)
+	}
+	return parsePromText(resp.Body)
+}
+
+func (cm *CollectedMetricPolls) ScrapeCollector(s *Scraper) {
+	pollInterval := time.Duration(flagPollInterval) * time.Second
+	c := time.Tick(pollInterval)
+	for range c {
+		cm.Add(s.Scrape())
+	}
+}
+
+type promSample struct {
+	name   string
+	labels map[string]string
+	kind   string
+	value  float64
+}
+
+// id собирает имя серии из имени метрики и отсортированных меток.
+// Символы, которые могут сломать URL-путь, заменяются на '_'.
+func (p promSample) id() string {
+	var b strings.Builder
+	b.WriteString(p.name)
+	keys := make([]string, 0, len(p.labels))
+	for k := range p.labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteByte('.')
+		b.WriteString(sanitizeName(k))
+		b.WriteByte('_')
+		b.WriteString(sanitizeName(p.labels[k]))
+	}
+	return b.String()
+}
+
+func sanitizeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '_', r == ':', r == '.', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
+
+// parsePromText разбирает текстовый формат экспозиции Prometheus.
+// Гистограммы и summary пропускаются, NaN и бесконечности тоже.
+func parsePromText(r io.Reader) ([]promSample, error) {
+	types := make(map[string]string)
+	var samples []promSample
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNum := 1; sc.Scan(); lineNum++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+		sample, err := parsePromSample(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		if math.IsNaN(sample.value) || math.IsInf(sample.value, 0) {
+			continue
+		}
+		sample.kind = promSampleType(types, sample.name)
+		if sample.kind != "counter" && sample.kind != "gauge" && sample.kind != "untyped" && sample.kind != "" {
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read scrape response: %w", err)
+	}
+	return samples, nil
+}
+
+// promSampleType определяет тип семейства, к которому относится сэмпл,
+// с учётом суффиксов _bucket, _sum и _count у гистограмм и summary.
+func promSampleType(types map[string]string, name string) string {
+	if kind, ok := types[name]; ok {
+		return kind
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if base, ok := strings.CutSuffix(name, suffix); ok {
+			if kind := types[base]; kind == "histogram" || kind == "summary" {
+				return kind
+			}
+		}
+	}
+	return ""
+}
+
+func parsePromSample(line string) (promSample, error) {
+	sample := promSample{labels: make(map[string]string)}
+	i := strings.IndexAny(line, "{ \t")
+	if i <= 0 {
+		return sample, fmt.Errorf("malformed sample %q", line)
+	}
+	sample.name = line[:i]
+	rest := line[i:]
+	if rest[0] == '{' {
+		var err error
+		rest, err = parsePromLabels(rest[1:], sample.labels)
+		if err != nil {
+			return sample, err
+		}
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return sample, fmt.Errorf("malformed sample %q", line)
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return sample, fmt.Errorf("invalid sample value %q: %w", fields[0], err)
+	}
+	sample.value = value
+	return sample, nil
+}
+
+// parsePromLabels разбирает метки до закрывающей '}' и возвращает остаток строки.
+func parsePromLabels(s string, labels map[string]string) (string, error) {
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return "", fmt.Errorf("unterminated label set")
+		}
+		if s[0] == '}' {
+			return s[1:], nil
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return "", fmt.Errorf("malformed label in %q", s)
+		}
+		name := strings.TrimSpace(s[:eq])
+		// пробелы вокруг '=' допустимы
+		s = strings.TrimLeft(s[eq+1:], " \t")
+		if name == "" || s == "" || s[0] != '"' {
+			return "", fmt.Errorf("malformed label in %q", s)
+		}
+		s = s[1:]
+		var value strings.Builder
+		closed := false
+		for j := 0; j < len(s); j++ {
+			c := s[j]
+			if c == '\\' && j+1 < len(s) {
+				j++
+				switch s[j] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(s[j])
+				}
+				continue
+			}
+			if c == '"' {
+				s = s[j+1:]
+				closed = true
+				break
+			}
+			value.WriteByte(c)
+		}
+		if !closed {
+			return "", fmt.Errorf("unterminated label value for %q", name)
+		}
+		labels[name] = value.String()
+	}
+}
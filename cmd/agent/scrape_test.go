@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePromText(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []promSample
+		wantErr string
+	}{
+		{
+			name: "typed families",
+			input: "# HELP http_requests_total Requests.\n" +
+				"# TYPE http_requests_total counter\n" +
+				"http_requests_total{code=\"200\",method=\"get\"} 1027 1395066363000\n" +
+				"# TYPE temperature gauge\n" +
+				"temperature -3.5\n" +
+				"untyped_metric 7\n",
+			want: []promSample{
+				{name: "http_requests_total", labels: map[string]string{"code": "200", "method": "get"}, kind: "counter", value: 1027},
+				{name: "temperature", labels: map[string]string{}, kind: "gauge", value: -3.5},
+				{name: "untyped_metric", labels: map[string]string{}, value: 7},
+			},
+		},
+		{
+			name: "histogram and summary are skipped",
+			input: "# TYPE latency histogram\n" +
+				"latency_bucket{le=\"0.1\"} 3\n" +
+				"latency_bucket{le=\"+Inf\"} 5\n" +
+				"latency_sum 1.2\n" +
+				"latency_count 5\n" +
+				"# TYPE rpc summary\n" +
+				"rpc{quantile=\"0.5\"} 0.2\n" +
+				"rpc_count 2\n" +
+				"# TYPE up gauge\n" +
+				"up 1\n",
+			want: []promSample{{name: "up", labels: map[string]string{}, kind: "gauge", value: 1}},
+		},
+		{
+			name:  "non-finite values are skipped",
+			input: "a NaN\nb +Inf\nc -Inf\nd 1e3\n",
+			want:  []promSample{{name: "d", labels: map[string]string{}, value: 1000}},
+		},
+		{
+			name:  "escaped label values",
+			input: `a{path="C:\\dir",msg="say \"hi\"\nbye",empty=""} 1` + "\n",
+			want:  []promSample{{name: "a", labels: map[string]string{"path": `C:\dir`, "msg": "say \"hi\"\nbye", "empty": ""}, value: 1}},
+		},
+		{
+			name:  "trailing comma and spaces",
+			input: "a{ x = \"1\", } 2\n\n   \n",
+			want:  []promSample{{name: "a", labels: map[string]string{"x": "1"}, value: 2}},
+		},
+		{name: "missing value", input: "a\n", wantErr: `line 1: malformed sample "a"`},
+		{name: "invalid value", input: "ok 1\na abc\n", wantErr: `line 2: invalid sample value "abc"`},
+		{name: "too many fields", input: "a 1 2 3\n", wantErr: `line 1: malformed sample "a 1 2 3"`},
+		{name: "unterminated label set", input: `a{x="1"` + "\n", wantErr: "line 1: unterminated label set"},
+		{name: "unterminated label value", input: `a{x="1} 1` + "\n", wantErr: `line 1: unterminated label value for "x"`},
+		{name: "label without quotes", input: "a{x=1} 1\n", wantErr: "line 1: malformed label"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples, err := parsePromText(strings.NewReader(tt.input))
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, samples)
+		})
+	}
+}
+
+func TestPromSampleID(t *testing.T) {
+	sample := promSample{name: "http_requests_total", labels: map[string]string{"path": "/api/v1", "code": "200"}}
+	assert.Equal(t, "http_requests_total.code_200.path__api_v1", sample.id())
+	assert.Equal(t, "up", promSample{name: "up"}.id())
+}
+
+func TestScrape(t *testing.T) {
+	cpu := "0.25"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "# TYPE process_cpu_seconds_total counter\nprocess_cpu_seconds_total %s\n", cpu)
+		fmt.Fprint(w, "# TYPE requests_total counter\nrequests_total 42\n# TYPE queue gauge\nqueue 3\n")
+	}))
+	defer srv.Close()
+
+	s := NewScraper([]ScrapeTarget{
+		{Name: "api", URL: srv.URL, timeout: time.Second},
+		{Name: "down", URL: "http://127.0.0.1:1/metrics", timeout: time.Second},
+	})
+	poll := s.Scrape()
+	// дробный счётчик округляется и остаётся счётчиком
+	assert.Equal(t, map[string]int64{"api.requests_total": 42, "api.process_cpu_seconds_total": 0}, poll.CumulativeMetrics)
+	assert.NotContains(t, poll.GaugeMetrics, "api.process_cpu_seconds_total")
+	assert.Equal(t, 3.0, poll.GaugeMetrics["api.queue"])
+	assert.Equal(t, 1.0, poll.GaugeMetrics["api.scrape_success"])
+	assert.Equal(t, 0.0, poll.GaugeMetrics["down.scrape_success"])
+	assert.Contains(t, poll.GaugeMetrics, "down.scrape_duration_seconds")
+
+	// тип не зависит от значения: новый агент с тем же ответом цели шлёт тот же счётчик
+	cpu = "1.5"
+	for _, scraper := range []*Scraper{s, NewScraper(s.targets)} {
+		poll = scraper.Scrape()
+		assert.Equal(t, int64(2), poll.CumulativeMetrics["api.process_cpu_seconds_total"])
+		assert.NotContains(t, poll.GaugeMetrics, "api.process_cpu_seconds_total")
+	}
+
+	cpu = "-1"
+	poll = s.Scrape()
+	assert.NotContains(t, poll.CumulativeMetrics, "api.process_cpu_seconds_total")
+}
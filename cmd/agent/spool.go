@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"monalert/internal/logger"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const spoolSegmentExt = ".json"
+
+// Spool — очередь на диске для батчей, которые не удалось отправить на сервер.
+// Каждый батч лежит в отдельном сегменте, имя которого — время создания в наносекундах,
+// поэтому сегменты воспроизводятся в порядке поступления и переживают рестарт агента.
+type Spool struct {
+	mux      sync.Mutex
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+	lastSeq  int64
+}
+
+type spoolSegment struct {
+	path string
+	seq  int64
+	size int64
+}
+
+func OpenSpool(dir string, maxBytes int64, maxAge time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("cannot create spool dir: %w", err)
+	}
+	s := &Spool{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+	}
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) > 0 {
+		s.lastSeq = segments[len(segments)-1].seq
+		logger.Log.Info("spool restored", zap.Int("segments", len(segments)))
+	}
+	return s, nil
+}
+
+// Push сохраняет батч в новый сегмент. Если очередь превышает лимит по размеру,
+// самые старые сегменты удаляются.
+func (s *Spool) Push(batch []*MetricPoll) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("cannot marshal spool segment: %w", err)
+	}
+	if s.maxBytes > 0 && int64(len(data)) > s.maxBytes {
+		return fmt.Errorf("batch of %d bytes exceeds spool limit %d", len(data), s.maxBytes)
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	seq := time.Now().UnixNano()
+	if seq <= s.lastSeq {
+		seq = s.lastSeq + 1
+	}
+	if err := s.writeSegment(s.segmentPath(seq), data); err != nil {
+		return err
+	}
+	s.lastSeq = seq
+	return s.enforceLimits()
+}
+
+// Replay по порядку отправляет сегменты функцией send. Отправленный сегмент удаляется,
+// а при ошибке в сегменте остаётся только то, что send вернула как неотправленное,
+// чтобы приращения счётчиков не ушли на сервер дважды.
+func (s *Spool) Replay(send func([]*MetricPoll) ([]*MetricPoll, error)) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+	for _, seg := range segments {
+		if s.expired(seg) {
+			logger.Log.Warn("spool segment expired, dropping", zap.String("segment", seg.path))
+			s.remove(seg)
+			continue
+		}
+		data, err := os.ReadFile(seg.path)
+		if err != nil {
+			return fmt.Errorf("cannot read spool segment: %w", err)
+		}
+		var batch []*MetricPoll
+		if err := json.Unmarshal(data, &batch); err != nil {
+			logger.Log.Error("corrupted spool segment, dropping", zap.String("segment", seg.path), zap.Error(err))
+			s.remove(seg)
+			continue
+		}
+		rest, err := send(batch)
+		if err != nil {
+			if len(rest) == 0 {
+				s.remove(seg)
+			} else if data, mErr := json.Marshal(rest); mErr == nil {
+				if wErr := s.writeSegment(seg.path, data); wErr != nil {
+					logger.Log.Error("cannot rewrite spool segment", zap.String("segment", seg.path), zap.Error(wErr))
+				}
+			}
+			return err
+		}
+		s.remove(seg)
+		logger.Log.Info("spool segment replayed", zap.String("segment", seg.path))
+	}
+	return nil
+}
+
+// Depth возвращает число сегментов в очереди и их суммарный размер.
+func (s *Spool) Depth() (int, int64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	segments, err := s.segments()
+	if err != nil {
+		logger.Log.Error("cannot list spool", zap.Error(err))
+		return 0, 0
+	}
+	var size int64
+	for _, seg := range segments {
+		size += seg.size
+	}
+	return len(segments), size
+}
+
+// SelfMetrics возвращает гауджи с глубиной очереди для отправки вместе с остальными метриками.
+func (s *Spool) SelfMetrics() *MetricPoll {
+	segments, size := s.Depth()
+	poll := NewMetricPoll()
+	poll.GaugeMetrics["SpoolQueueDepth"] = float64(segments)
+	poll.GaugeMetrics["SpoolQueueBytes"] = float64(size)
+	return poll
+}
+
+func (s *Spool) enforceLimits() error {
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, seg := range segments {
+		total += seg.size
+	}
+	for _, seg := range segments {
+		if !s.expired(seg) && (s.maxBytes <= 0 || total <= s.maxBytes) {
+			break
+		}
+		logger.Log.Warn("spool limit exceeded, dropping oldest segment", zap.String("segment", seg.path))
+		s.remove(seg)
+		total -= seg.size
+	}
+	return nil
+}
+
+func (s *Spool) expired(seg spoolSegment) bool {
+	return s.maxAge > 0 && time.Since(time.Unix(0, seg.seq)) > s.maxAge
+}
+
+func (s *Spool) segments() ([]spoolSegment, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read spool dir: %w", err)
+	}
+	segments := make([]spoolSegment, 0, len(entries))
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), spoolSegmentExt)
+		if !ok || e.IsDir() {
+			continue
+		}
+		seq, err := strconv.ParseInt(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		segments = append(segments, spoolSegment{
+			path: filepath.Join(s.dir, e.Name()),
+			seq:  seq,
+			size: info.Size(),
+		})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].seq < segments[j].seq })
+	return segments, nil
+}
+
+func (s *Spool) segmentPath(seq int64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolSegmentExt))
+}
+
+// writeSegment пишет сегмент через временный файл, чтобы при падении агента
+// на диске не остался обрезанный JSON.
+func (s *Spool) writeSegment(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("cannot write spool segment: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("cannot commit spool segment: %w", err)
+	}
+	return nil
+}
+
+func (s *Spool) remove(seg spoolSegment) {
+	if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+		logger.Log.Error("cannot remove spool segment", zap.String("segment", seg.path), zap.Error(err))
+	}
+}
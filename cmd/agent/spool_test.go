@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPoll(n int64, gauge float64) *MetricPoll {
+	poll := NewMetricPoll()
+	poll.PollNumber = n
+	poll.GaugeMetrics["g"] = gauge
+	return poll
+}
+
+func pollNumbers(batch []*MetricPoll) []int64 {
+	numbers := make([]int64, 0, len(batch))
+	for _, poll := range batch {
+		numbers = append(numbers, poll.PollNumber)
+	}
+	return numbers
+}
+
+func TestSpoolPushReplay(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSpool(dir, 0, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Push(nil))
+	require.NoError(t, s.Push([]*MetricPoll{testPoll(1, 1), testPoll(2, 2)}))
+	require.NoError(t, s.Push([]*MetricPoll{testPoll(3, 3)}))
+	segments, _ := s.Depth()
+	assert.Equal(t, 2, segments)
+
+	// очередь переживает рестарт агента
+	s, err = OpenSpool(dir, 0, 0)
+	require.NoError(t, err)
+	var sent []int64
+	require.NoError(t, s.Replay(func(batch []*MetricPoll) ([]*MetricPoll, error) {
+		sent = append(sent, pollNumbers(batch)...)
+		return nil, nil
+	}))
+	assert.Equal(t, []int64{1, 2, 3}, sent)
+	segments, size := s.Depth()
+	assert.Zero(t, segments)
+	assert.Zero(t, size)
+}
+
+func TestSpoolReplayPartialFailure(t *testing.T) {
+	s, err := OpenSpool(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, s.Push([]*MetricPoll{testPoll(1, 1), testPoll(2, 2), testPoll(3, 3)}))
+	require.NoError(t, s.Push([]*MetricPoll{testPoll(4, 4)}))
+
+	errDown := errors.New("server is down")
+	calls := 0
+	err = s.Replay(func(batch []*MetricPoll) ([]*MetricPoll, error) {
+		calls++
+		// первый опрос отправлен, остальные вернулись неотправленными
+		return batch[1:], errDown
+	})
+	require.ErrorIs(t, err, errDown)
+	assert.Equal(t, 1, calls, "replay stops at the first failure")
+
+	var sent []int64
+	require.NoError(t, s.Replay(func(batch []*MetricPoll) ([]*MetricPoll, error) {
+		sent = append(sent, pollNumbers(batch)...)
+		return nil, nil
+	}))
+	assert.Equal(t, []int64{2, 3, 4}, sent)
+}
+
+func TestSpoolReplayDropsBrokenSegments(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSpool(dir, 0, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(s.segmentPath(time.Now().Add(-2*time.Hour).UnixNano()), []byte(`[{"PollNumber":1}]`), 0o600))
+	require.NoError(t, os.WriteFile(s.segmentPath(time.Now().UnixNano()), []byte(`[{"PollNumber":`), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("keep"), 0o600))
+
+	calls := 0
+	require.NoError(t, s.Replay(func(batch []*MetricPoll) ([]*MetricPoll, error) {
+		calls++
+		return nil, nil
+	}))
+	assert.Zero(t, calls, "expired and corrupted segments are dropped without sending")
+	segments, _ := s.Depth()
+	assert.Zero(t, segments)
+	assert.FileExists(t, filepath.Join(dir, "notes.txt"))
+}
+
+func TestSpoolEnforceLimits(t *testing.T) {
+	t.Run("max bytes", func(t *testing.T) {
+		batch := []*MetricPoll{testPoll(1, 1)}
+		s, err := OpenSpool(t.TempDir(), 0, 0)
+		require.NoError(t, err)
+		require.NoError(t, s.Push(batch))
+		_, size := s.Depth()
+
+		// в лимит помещаются ровно два сегмента
+		s.maxBytes = 2 * size
+		require.NoError(t, s.Push([]*MetricPoll{testPoll(2, 1)}))
+		require.NoError(t, s.Push([]*MetricPoll{testPoll(3, 1)}))
+		segments, total := s.Depth()
+		assert.Equal(t, 2, segments)
+		assert.LessOrEqual(t, total, s.maxBytes)
+
+		var sent []int64
+		require.NoError(t, s.Replay(func(batch []*MetricPoll) ([]*MetricPoll, error) {
+			sent = append(sent, pollNumbers(batch)...)
+			return nil, nil
+		}))
+		assert.Equal(t, []int64{2, 3}, sent, "oldest segment is dropped first")
+	})
+
+	t.Run("max age", func(t *testing.T) {
+		s, err := OpenSpool(t.TempDir(), 0, time.Hour)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(s.segmentPath(time.Now().Add(-2*time.Hour).UnixNano()), []byte(`[]`), 0o600))
+		require.NoError(t, s.Push([]*MetricPoll{testPoll(1, 1)}))
+		segments, _ := s.Depth()
+		assert.Equal(t, 1, segments)
+	})
+
+	t.Run("batch larger than limit", func(t *testing.T) {
+		s, err := OpenSpool(t.TempDir(), 10, 0)
+		require.NoError(t, err)
+		err = s.Push([]*MetricPoll{testPoll(1, 1)})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "exceeds spool limit 10")
+		segments, _ := s.Depth()
+		assert.Zero(t, segments)
+	})
+}
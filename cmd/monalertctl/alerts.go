@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"monalert/client"
+	"os"
+	"text/tabwriter"
+)
+
+// У сервера нет своих правил алертов, поэтому alerts проверяет правила из файла
+// по текущим значениям метрик. Ненулевой код выхода при сработавших правилах
+// позволяет запускать проверку из cron или CI.
+
+// alertRule — правило из файла alerts: метрика срабатывает, если её значение
+// удовлетворяет условию "значение op threshold".
+type alertRule struct {
+	Name      string  `json:"name"`
+	Type      string  `json:"type"`
+	Metric    string  `json:"metric"`
+	Op        string  `json:"op"`
+	Threshold float64 `json:"threshold"`
+}
+
+// Состояния правила. Метрики нет на сервере — nodata, это тоже считается срабатыванием:
+// пропавшая метрика обычно означает, что её источник перестал работать.
+const (
+	alertOK     = "ok"
+	alertFiring = "firing"
+	alertNoData = "nodata"
+)
+
+// alertResult — результат проверки одного правила.
+type alertResult struct {
+	Name      string   `json:"name"`
+	State     string   `json:"state"`
+	Metric    string   `json:"metric"`
+	Type      string   `json:"type"`
+	Value     *float64 `json:"value,omitempty"`
+	Condition string   `json:"condition"`
+}
+
+var alertOps = map[string]func(v, threshold float64) bool{
+	">":  func(v, threshold float64) bool { return v > threshold },
+	">=": func(v, threshold float64) bool { return v >= threshold },
+	"<":  func(v, threshold float64) bool { return v < threshold },
+	"<=": func(v, threshold float64) bool { return v <= threshold },
+	"==": func(v, threshold float64) bool { return v == threshold },
+	"!=": func(v, threshold float64) bool { return v != threshold },
+}
+
+func loadAlertRules(path string) ([]alertRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read alert rules: %w", err)
+	}
+	var rules []alertRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal alert rules: %w", err)
+	}
+	for i, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("alert rule %d: empty name", i)
+		}
+		if rule.Type != "gauge" && rule.Type != "counter" {
+			return nil, fmt.Errorf("alert rule %s: unsupported metric type %q", rule.Name, rule.Type)
+		}
+		if rule.Metric == "" {
+			return nil, fmt.Errorf("alert rule %s: empty metric", rule.Name)
+		}
+		if _, ok := alertOps[rule.Op]; !ok {
+			return nil, fmt.Errorf("alert rule %s: unsupported op %q", rule.Name, rule.Op)
+		}
+	}
+	return rules, nil
+}
+
+func runAlerts(ctx context.Context, c *client.Client, args []string) error {
+	fs := newFlagSet("alerts")
+	file := fs.String("f", "", "JSON file with alert rules")
+	all := fs.Bool("all", false, "also print rules that are ok")
+	output := fs.String("o", "table", "output format: table or json")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 || *file == "" {
+		return errUsage
+	}
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("unsupported output format: %s", *output)
+	}
+	rules, err := loadAlertRules(*file)
+	if err != nil {
+		return err
+	}
+	results := make([]alertResult, 0, len(rules))
+	firing := 0
+	for _, rule := range rules {
+		result, err := checkAlert(ctx, c, rule)
+		if err != nil {
+			return fmt.Errorf("alert rule %s: %w", rule.Name, err)
+		}
+		if result.State != alertOK {
+			firing++
+		}
+		if result.State != alertOK || *all {
+			results = append(results, result)
+		}
+	}
+	if err := writeAlerts(stdout, *output, results); err != nil {
+		return err
+	}
+	if firing > 0 {
+		return fmt.Errorf("%d of %d alert rules firing", firing, len(rules))
+	}
+	return nil
+}
+
+func checkAlert(ctx context.Context, c *client.Client, rule alertRule) (alertResult, error) {
+	result := alertResult{
+		Name:      rule.Name,
+		Metric:    rule.Metric,
+		Type:      rule.Type,
+		Condition: fmt.Sprintf("%s %g", rule.Op, rule.Threshold),
+	}
+	m, err := c.Get(ctx, rule.Type, rule.Metric)
+	if errors.Is(err, client.ErrNotFound) {
+		result.State = alertNoData
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+	var value float64
+	switch {
+	case m.Value != nil:
+		value = *m.Value
+	case m.Delta != nil:
+		value = float64(*m.Delta)
+	}
+	result.Value = &value
+	result.State = alertOK
+	if alertOps[rule.Op](value, rule.Threshold) {
+		result.State = alertFiring
+	}
+	return result, nil
+}
+
+func writeAlerts(w io.Writer, format string, results []alertResult) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATE\tNAME\tMETRIC\tVALUE\tCONDITION")
+	for _, r := range results {
+		value := "-"
+		if r.Value != nil {
+			value = formatValue(client.Metric{Value: r.Value})
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s/%s\t%s\t%s\n", r.State, r.Name, r.Type, r.Metric, value, r.Condition)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"monalert/client"
+	"monalert/internal/models"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// errUsage означает, что подкоманде передали неверные аргументы.
+var errUsage = errors.New("usage error")
+
+// stdout — куда команды пишут результат; тесты подменяют его буфером.
+var stdout io.Writer = os.Stdout
+
+// exportFile — формат export и import.
+type exportFile struct {
+	Metrics  []client.Metric            `json:"metrics"`
+	Metadata map[string]client.Metadata `json:"metadata,omitempty"`
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {}
+	return fs
+}
+
+func runGet(ctx context.Context, c *client.Client, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	m, err := c.Get(ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, formatValue(*m))
+	return nil
+}
+
+func runSet(ctx context.Context, c *client.Client, args []string) error {
+	fs := newFlagSet("set")
+	cumulative := fs.Bool("cumulative", false, "counter value is cumulative, the server computes the increment")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 3 {
+		return errUsage
+	}
+	m := client.Metric{MType: fs.Arg(0), ID: fs.Arg(1), Cumulative: *cumulative}
+	switch m.MType {
+	case "gauge":
+		v, err := strconv.ParseFloat(fs.Arg(2), 64)
+		if err != nil {
+			return fmt.Errorf("invalid gauge value %q: %w", fs.Arg(2), err)
+		}
+		m.Value = &v
+	case "counter":
+		v, err := strconv.ParseInt(fs.Arg(2), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid counter value %q: %w", fs.Arg(2), err)
+		}
+		m.Delta = &v
+	default:
+		return fmt.Errorf("unsupported metric type: %s", m.MType)
+	}
+	resp, err := c.Update(ctx, &m)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, formatValue(*resp))
+	return nil
+}
+
+func runList(ctx context.Context, c *client.Client, args []string) error {
+	fs := newFlagSet("list")
+	mType := fs.String("type", "", "only metrics of this type")
+	prefix := fs.String("prefix", "", "only metrics with names starting with prefix")
+	match := fs.String("match", "", "only metrics with names matching regexp")
+	output := fs.String("o", "table", "output format: table, json or csv")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+	if *match != "" {
+		if _, err := regexp.Compile(*match); err != nil {
+			return fmt.Errorf("invalid -match: %w", err)
+		}
+	}
+	metrics, err := c.ListMetrics(ctx, client.ListOptions{Type: *mType, Prefix: *prefix, Match: *match})
+	if err != nil {
+		return err
+	}
+	sortMetrics(metrics)
+	return writeMetrics(stdout, *output, metrics)
+}
+
+func runWatch(ctx context.Context, c *client.Client, args []string) error {
+	fs := newFlagSet("watch")
+	mType := fs.String("type", "", "only metrics of this type")
+	prefix := fs.String("prefix", "", "only metrics with names starting with prefix")
+	lastEventID := fs.Uint64("last-event-id", 0, "replay updates after this event id")
+	output := fs.String("o", "table", "output format: table or json")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("unsupported output format: %s", *output)
+	}
+	enc := json.NewEncoder(stdout)
+	return c.Watch(ctx, client.Filter{Type: *mType, Prefix: *prefix}, *lastEventID, func(ev client.Event) error {
+		if *output == "json" {
+			return enc.Encode(ev.Metric)
+		}
+		_, err := fmt.Fprintf(stdout, "%d\t%s\t%s\t%s\n", ev.ID, ev.Metric.MType, ev.Metric.ID, formatValue(ev.Metric))
+		return err
+	})
+}
+
+func runExport(ctx context.Context, c *client.Client, args []string) error {
+	fs := newFlagSet("export")
+	file := fs.String("f", "", "output file, stdout if empty")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+	metrics, err := c.List(ctx)
+	if err != nil {
+		return err
+	}
+	metadata, err := c.AllMetadata(ctx)
+	if err != nil {
+		return err
+	}
+	export := exportFile{Metadata: make(map[string]client.Metadata)}
+	// метрики самого сервера записать нельзя, поэтому они не выгружаются
+	for _, m := range metrics {
+		if !models.IsReservedName(m.ID) {
+			export.Metrics = append(export.Metrics, m)
+		}
+	}
+	for name, md := range metadata {
+		if !models.IsReservedName(name) {
+			export.Metadata[name] = md
+		}
+	}
+	sortMetrics(export.Metrics)
+
+	w := stdout
+	if *file != "" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(export)
+}
+
+// importBatchSize — сколько метрик import отправляет одним батчем: так запрос
+// укладывается в ограничение сервера на размер тела.
+const importBatchSize = 1000
+
+// runImport загружает файл export батчами. Счётчики прибавляются к значениям на сервере,
+// поэтому точную копию даёт импорт в пустой сервер.
+func runImport(ctx context.Context, c *client.Client, args []string) error {
+	fs := newFlagSet("import")
+	file := fs.String("f", "", "input file, stdin if empty")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+	var r io.Reader = os.Stdin
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	var data exportFile
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return fmt.Errorf("cannot decode import file: %w", err)
+	}
+	for name, md := range data.Metadata {
+		if err := c.SetMetadata(ctx, name, md); err != nil {
+			return fmt.Errorf("metadata %s: %w", name, err)
+		}
+	}
+	for i := range data.Metrics {
+		data.Metrics[i].Cumulative = false
+	}
+	for start := 0; start < len(data.Metrics); start += importBatchSize {
+		batch := data.Metrics[start:min(start+importBatchSize, len(data.Metrics))]
+		if _, err := c.UpdateBatch(ctx, batch); err != nil {
+			return fmt.Errorf("metrics %d-%d: %w", start, start+len(batch)-1, err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "imported %d metrics and %d metadata entries\n", len(data.Metrics), len(data.Metadata))
+	return nil
+}
+
+func sortMetrics(metrics []client.Metric) {
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].MType != metrics[j].MType {
+			return metrics[i].MType < metrics[j].MType
+		}
+		return metrics[i].ID < metrics[j].ID
+	})
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"monalert/client"
+	"monalert/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServer хранит метрики и описания в памяти и отвечает на те запросы,
+// которые делают команды monalertctl.
+type fakeServer struct {
+	mux      sync.Mutex
+	metrics  map[string]client.Metric
+	metadata map[string]client.Metadata
+	// pageSize, если не 0, заменяет limit из запроса, чтобы проверить переход по страницам
+	pageSize int
+	// listRequests — параметры запросов к /api/v1/metrics, batches — размеры батчей /updates/
+	listRequests []string
+	batches      []int
+}
+
+func newFakeServer(t *testing.T) (*fakeServer, *client.Client) {
+	t.Helper()
+	f := &fakeServer{metrics: map[string]client.Metric{}, metadata: map[string]client.Metadata{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, client.New(client.Config{Address: strings.TrimPrefix(srv.URL, "http://")})
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	var resp any
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/":
+		list := make([]client.Metric, 0, len(f.metrics))
+		for _, m := range f.metrics {
+			list = append(list, m)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+		resp = list
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/metrics":
+		page, ok := f.listPage(r.URL.Query())
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		resp = page
+	case r.Method == http.MethodPost && r.URL.Path == "/value/":
+		var m client.Metric
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		stored, ok := f.metrics[m.MType+"/"+m.ID]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		resp = stored
+	case r.Method == http.MethodPost && r.URL.Path == "/update/":
+		var m client.Metric
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		resp = f.update(m)
+	case r.Method == http.MethodPost && r.URL.Path == "/updates/":
+		var batch []client.Metric
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.batches = append(f.batches, len(batch))
+		updated := make([]client.Metric, 0, len(batch))
+		for _, m := range batch {
+			updated = append(updated, f.update(m))
+		}
+		resp = updated
+	case r.Method == http.MethodGet && r.URL.Path == "/meta/":
+		resp = f.metadata
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/meta/"):
+		var md client.Metadata
+		if err := json.NewDecoder(r.Body).Decode(&md); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.metadata[strings.TrimPrefix(r.URL.Path, "/meta/")] = md
+		return
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// update записывает метрику как сервер: счётчики складываются. Вызывается под блокировкой.
+func (f *fakeServer) update(m client.Metric) client.Metric {
+	if old, ok := f.metrics[m.MType+"/"+m.ID]; ok && m.MType == "counter" {
+		total := *old.Delta + *m.Delta
+		m.Delta = &total
+	}
+	f.metrics[m.MType+"/"+m.ID] = m
+	return m
+}
+
+// listPage отдаёт страницу /api/v1/metrics: фильтры type, prefix и match, курсор — номер
+// первой метрики страницы. Вызывается под блокировкой.
+func (f *fakeServer) listPage(query url.Values) (models.MetricList, bool) {
+	f.listRequests = append(f.listRequests, query.Encode())
+	var re *regexp.Regexp
+	if match := query.Get("match"); match != "" {
+		var err error
+		if re, err = regexp.Compile(match); err != nil {
+			return models.MetricList{}, false
+		}
+	}
+	var matched []client.Metric
+	for _, m := range f.metrics {
+		if (query.Get("type") == "" || m.MType == query.Get("type")) && strings.HasPrefix(m.ID, query.Get("prefix")) &&
+			(re == nil || re.MatchString(m.ID)) {
+			matched = append(matched, m)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].ID < matched[j].ID })
+	start, _ := strconv.Atoi(query.Get("cursor"))
+	limit := f.pageSize
+	if limit == 0 {
+		limit, _ = strconv.Atoi(query.Get("limit"))
+	}
+	end := min(start+limit, len(matched))
+	page := models.MetricList{Metrics: matched[start:end], Total: len(matched)}
+	if page.Metrics == nil {
+		page.Metrics = []client.Metric{}
+	}
+	if end < len(matched) {
+		query.Set("cursor", strconv.Itoa(end))
+		page.Next = "/api/v1/metrics?" + query.Encode()
+	}
+	return page, true
+}
+
+func (f *fakeServer) set(m client.Metric) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.metrics[m.MType+"/"+m.ID] = m
+}
+
+func captureStdout(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := stdout
+	stdout = &buf
+	t.Cleanup(func() { stdout = prev })
+	return &buf
+}
+
+func gauge(id string, v float64) client.Metric {
+	return client.Metric{ID: id, MType: "gauge", Value: &v}
+}
+
+func counter(id string, d int64) client.Metric {
+	return client.Metric{ID: id, MType: "counter", Delta: &d}
+}
+
+func TestRunList(t *testing.T) {
+	f, c := newFakeServer(t)
+	for _, m := range []client.Metric{
+		gauge("HeapAlloc", 1), gauge("HeapInuse", 2), gauge("Sys", 3),
+		counter("PollCount", 5), counter("HeapObjectsFreed", 7),
+	} {
+		f.set(m)
+	}
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "all sorted by type and name",
+			args: []string{"-o", "csv"},
+			want: "type,name,value\ncounter,HeapObjectsFreed,7\ncounter,PollCount,5\n" +
+				"gauge,HeapAlloc,1\ngauge,HeapInuse,2\ngauge,Sys,3\n",
+		},
+		{name: "type", args: []string{"-type", "counter", "-o", "csv"}, want: "type,name,value\ncounter,HeapObjectsFreed,7\ncounter,PollCount,5\n"},
+		{name: "prefix", args: []string{"-prefix", "Heap", "-type", "gauge", "-o", "csv"}, want: "type,name,value\ngauge,HeapAlloc,1\ngauge,HeapInuse,2\n"},
+		{name: "match", args: []string{"-match", "(Alloc|Sys)$", "-o", "csv"}, want: "type,name,value\ngauge,HeapAlloc,1\ngauge,Sys,3\n"},
+		{name: "nothing matches", args: []string{"-prefix", "Nope", "-o", "json"}, want: "[]\n"},
+		{name: "bad regexp", args: []string{"-match", "("}, wantErr: "invalid -match"},
+		{name: "unexpected argument", args: []string{"Heap"}, wantErr: errUsage.Error()},
+		{name: "bad format", args: []string{"-o", "yaml"}, wantErr: "unsupported output format: yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := captureStdout(t)
+			err := runList(context.Background(), c, tt.args)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+}
+
+func TestRunListFiltersOnServer(t *testing.T) {
+	f, c := newFakeServer(t)
+	for _, m := range []client.Metric{gauge("HeapAlloc", 1), gauge("HeapInuse", 2), gauge("HeapSys", 3), gauge("Sys", 4), counter("HeapObjects", 5)} {
+		f.set(m)
+	}
+	f.pageSize = 2
+	out := captureStdout(t)
+	require.NoError(t, runList(context.Background(), c, []string{"-type", "gauge", "-prefix", "Heap", "-match", "^Heap(Alloc|Sys)$", "-o", "csv"}))
+	assert.Equal(t, "type,name,value\ngauge,HeapAlloc,1\ngauge,HeapSys,3\n", out.String())
+	// все фильтры ушли на сервер, а страницы прочитаны по ссылке next
+	require.Len(t, f.listRequests, 1)
+	query, err := url.ParseQuery(f.listRequests[0])
+	require.NoError(t, err)
+	assert.Equal(t, "gauge", query.Get("type"))
+	assert.Equal(t, "Heap", query.Get("prefix"))
+	assert.Equal(t, "^Heap(Alloc|Sys)$", query.Get("match"))
+
+	f.listRequests = nil
+	out.Reset()
+	require.NoError(t, runList(context.Background(), c, []string{"-prefix", "Heap", "-o", "csv"}))
+	assert.Equal(t, "type,name,value\ncounter,HeapObjects,5\ngauge,HeapAlloc,1\ngauge,HeapInuse,2\ngauge,HeapSys,3\n", out.String())
+	assert.Len(t, f.listRequests, 2, "four metrics are read in two pages")
+}
+
+func TestExportImport(t *testing.T) {
+	src, srcClient := newFakeServer(t)
+	src.set(gauge("HeapAlloc", 1.5))
+	src.set(counter("PollCount", 10))
+	src.set(gauge("monalert_queue_depth", 4))
+	src.set(gauge("monalert_series", 3))
+	src.set(counter("monalert_http_requests_total.route__update_.status_200", 8))
+	src.metadata["HeapAlloc"] = client.Metadata{Unit: "bytes", Type: "gauge"}
+	src.metadata["monalert_series"] = client.Metadata{Type: "gauge", Help: "Number of series in storage."}
+
+	file := filepath.Join(t.TempDir(), "dump.json")
+	captureStdout(t)
+	require.NoError(t, runExport(context.Background(), srcClient, []string{"-f", file}))
+
+	var dump exportFile
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &dump))
+	// метрики сервера не выгружаются, а свои метрики с тем же префиксом — да
+	assert.Equal(t, []client.Metric{counter("PollCount", 10), gauge("HeapAlloc", 1.5), gauge("monalert_queue_depth", 4)}, dump.Metrics)
+	assert.Equal(t, map[string]client.Metadata{"HeapAlloc": {Unit: "bytes", Type: "gauge"}}, dump.Metadata)
+
+	dst, dstClient := newFakeServer(t)
+	dst.set(counter("PollCount", 1))
+	require.NoError(t, runImport(context.Background(), dstClient, []string{"-f", file}))
+	assert.Equal(t, map[string]client.Metric{
+		"gauge/HeapAlloc":            gauge("HeapAlloc", 1.5),
+		"gauge/monalert_queue_depth": gauge("monalert_queue_depth", 4),
+		// счётчики прибавляются к значению на сервере
+		"counter/PollCount": counter("PollCount", 11),
+	}, dst.metrics)
+	assert.Equal(t, dump.Metadata, dst.metadata)
+	assert.Equal(t, []int{3}, dst.batches, "metrics are imported in one batch")
+
+	require.Error(t, runImport(context.Background(), dstClient, []string{"-f", filepath.Join(t.TempDir(), "missing.json")}))
+	require.ErrorIs(t, runImport(context.Background(), dstClient, []string{"extra"}), errUsage)
+}
+
+func TestRunAlerts(t *testing.T) {
+	f, c := newFakeServer(t)
+	f.set(gauge("HeapAlloc", 2048))
+	f.set(gauge("Sys", 10))
+	f.set(counter("queue.exec_errors", 0))
+	rules := filepath.Join(t.TempDir(), "rules.json")
+	require.NoError(t, os.WriteFile(rules, []byte(`[
+		{"name": "heap", "type": "gauge", "metric": "HeapAlloc", "op": ">", "threshold": 1024},
+		{"name": "sys", "type": "gauge", "metric": "Sys", "op": ">=", "threshold": 100},
+		{"name": "exec", "type": "counter", "metric": "queue.exec_errors", "op": ">", "threshold": 0},
+		{"name": "gone", "type": "gauge", "metric": "Missing", "op": "<", "threshold": 1}
+	]`), 0o600))
+
+	out := captureStdout(t)
+	err := runAlerts(context.Background(), c, []string{"-f", rules, "-o", "json"})
+	require.EqualError(t, err, "2 of 4 alert rules firing")
+	var results []alertResult
+	require.NoError(t, json.Unmarshal(out.Bytes(), &results))
+	require.Len(t, results, 2)
+	assert.Equal(t, "heap", results[0].Name)
+	assert.Equal(t, alertFiring, results[0].State)
+	assert.Equal(t, 2048.0, *results[0].Value)
+	assert.Equal(t, "> 1024", results[0].Condition)
+	assert.Equal(t, "gone", results[1].Name)
+	assert.Equal(t, alertNoData, results[1].State)
+	assert.Nil(t, results[1].Value)
+
+	out.Reset()
+	err = runAlerts(context.Background(), c, []string{"-f", rules, "-all"})
+	require.Error(t, err)
+	assert.Equal(t, 5, strings.Count(out.String(), "\n"), "header and every rule")
+	assert.Contains(t, out.String(), "ok")
+
+	// когда ни одно правило не сработало, команда завершается успешно
+	f.set(gauge("HeapAlloc", 1))
+	f.set(gauge("Missing", 5))
+	out.Reset()
+	require.NoError(t, runAlerts(context.Background(), c, []string{"-f", rules}))
+	assert.Equal(t, "STATE  NAME  METRIC  VALUE  CONDITION\n", out.String())
+
+	for _, tt := range []struct{ rules, wantErr string }{
+		{rules: `[{"name":"a","type":"gauge","metric":"m","op":"=>","threshold":1}]`, wantErr: `alert rule a: unsupported op "=>"`},
+		{rules: `[{"name":"a","type":"histogram","metric":"m","op":">"}]`, wantErr: `alert rule a: unsupported metric type "histogram"`},
+		{rules: `[{"type":"gauge","metric":"m","op":">"}]`, wantErr: "alert rule 0: empty name"},
+		{rules: `[{"name":"a","type":"gauge","op":">"}]`, wantErr: "alert rule a: empty metric"},
+		{rules: `{}`, wantErr: "cannot unmarshal alert rules"},
+	} {
+		require.NoError(t, os.WriteFile(rules, []byte(tt.rules), 0o600))
+		assert.ErrorContains(t, runAlerts(context.Background(), c, []string{"-f", rules}), tt.wantErr)
+	}
+	assert.ErrorIs(t, runAlerts(context.Background(), c, nil), errUsage)
+	assert.ErrorContains(t, runAlerts(context.Background(), c, []string{"-f", rules, "-o", "csv"}), "unsupported output format: csv")
+}
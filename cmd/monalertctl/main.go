@@ -0,0 +1,138 @@
+// monalertctl — консольный клиент сервера monalert.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"monalert/client"
+	"monalert/internal/tlsutil"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var (
+	flagServerAddr string
+	flagScheme     string
+	flagToken      string
+	flagTLSCA      string
+	flagTLSCert    string
+	flagTLSKey     string
+	flagGzip       bool
+	flagKey        string
+)
+
+// command — подкоманда monalertctl. run получает аргументы после имени подкоманды.
+type command struct {
+	usage string
+	run   func(ctx context.Context, c *client.Client, args []string) error
+}
+
+var commands = map[string]command{
+	"get":    {usage: "get <type> <name>", run: runGet},
+	"set":    {usage: "set [-cumulative] <type> <name> <value>", run: runSet},
+	"list":   {usage: "list [-type t] [-prefix p] [-match regexp] [-o table|json|csv]", run: runList},
+	"watch":  {usage: "watch [-type t] [-prefix p] [-last-event-id n] [-o table|json]", run: runWatch},
+	"alerts": {usage: "alerts -f rules.json [-all] [-o table|json]", run: runAlerts},
+	"export": {usage: "export [-f file]", run: runExport},
+	"import": {usage: "import [-f file]", run: runImport},
+}
+
+var commandOrder = []string{"get", "set", "list", "watch", "alerts", "export", "import"}
+
+func parseFlags() {
+	flag.StringVar(&flagServerAddr, "a", "localhost:8080", "server address")
+	flag.StringVar(&flagScheme, "scheme", "http", "scheme for server requests: http or https")
+	flag.StringVar(&flagToken, "token", "", "API token")
+	flag.StringVar(&flagTLSCA, "tls-ca", "", "CA bundle to verify the server certificate, system roots if empty")
+	flag.StringVar(&flagTLSCert, "tls-cert", "", "client certificate for mutual TLS")
+	flag.StringVar(&flagTLSKey, "tls-key", "", "client private key for mutual TLS")
+	flag.BoolVar(&flagGzip, "gzip", true, "compress request bodies")
+	flag.StringVar(&flagKey, "k", "", "key for HMAC-SHA256 signature of requests")
+	flag.Usage = usage
+	flag.Parse()
+
+	if envRunAddr := os.Getenv("ADDRESS"); envRunAddr != "" {
+		flagServerAddr = envRunAddr
+	}
+	if envScheme := os.Getenv("SCHEME"); envScheme != "" {
+		flagScheme = envScheme
+	}
+	if envToken := os.Getenv("TOKEN"); envToken != "" {
+		flagToken = envToken
+	}
+	if envTLSCA := os.Getenv("TLS_CA"); envTLSCA != "" {
+		flagTLSCA = envTLSCA
+	}
+	if envTLSCert := os.Getenv("TLS_CERT"); envTLSCert != "" {
+		flagTLSCert = envTLSCert
+	}
+	if envTLSKey := os.Getenv("TLS_KEY"); envTLSKey != "" {
+		flagTLSKey = envTLSKey
+	}
+	if envKey := os.Getenv("KEY"); envKey != "" {
+		flagKey = envKey
+	}
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: monalertctl [flags] <command> [args]\n\nCommands:\n")
+	for _, name := range commandOrder {
+		fmt.Fprintf(out, "  %s\n", commands[name].usage)
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	parseFlags()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	if flagScheme != "http" && flagScheme != "https" {
+		fmt.Fprintf(os.Stderr, "invalid scheme %q: must be http or https\n", flagScheme)
+		os.Exit(2)
+	}
+	var tlsConfig *tls.Config
+	if flagScheme == "https" {
+		var err error
+		tlsConfig, err = tlsutil.ClientConfig(flagTLSCA, flagTLSCert, flagTLSKey)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	c := client.New(client.Config{
+		Address: flagServerAddr,
+		Scheme:  flagScheme,
+		Token:   flagToken,
+		TLS:     tlsConfig,
+		Gzip:    flagGzip,
+		Key:     flagKey,
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := cmd.run(ctx, c, flag.Args()[1:]); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(os.Stderr, "usage: monalertctl %s\n", cmd.usage)
+			os.Exit(2)
+		}
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"monalert/client"
+	"strconv"
+	"text/tabwriter"
+)
+
+func formatValue(m client.Metric) string {
+	switch {
+	case m.Value != nil:
+		return strconv.FormatFloat(*m.Value, 'f', -1, 64)
+	case m.Delta != nil:
+		return strconv.FormatInt(*m.Delta, 10)
+	default:
+		return ""
+	}
+}
+
+// writeMetrics выводит метрики таблицей, JSON-массивом или CSV с заголовком.
+func writeMetrics(w io.Writer, format string, metrics []client.Metric) error {
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "TYPE\tNAME\tVALUE")
+		for _, m := range metrics {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", m.MType, m.ID, formatValue(m))
+		}
+		return tw.Flush()
+	case "json":
+		if metrics == nil {
+			metrics = []client.Metric{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(metrics)
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"type", "name", "value"})
+		for _, m := range metrics {
+			_ = cw.Write([]string{m.MType, m.ID, formatValue(m)})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
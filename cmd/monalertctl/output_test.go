@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"monalert/client"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	value, delta := 0.25, int64(42)
+	metrics := []client.Metric{
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "disk,usage", MType: "gauge", Value: &value},
+	}
+	tests := []struct {
+		name    string
+		format  string
+		metrics []client.Metric
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "table",
+			format:  "table",
+			metrics: metrics,
+			want: "TYPE     NAME        VALUE\n" +
+				"counter  PollCount   42\n" +
+				"gauge    disk,usage  0.25\n",
+		},
+		{
+			name:    "json",
+			format:  "json",
+			metrics: metrics,
+			want: "[\n" +
+				"  {\n    \"id\": \"PollCount\",\n    \"type\": \"counter\",\n    \"delta\": 42\n  },\n" +
+				"  {\n    \"id\": \"disk,usage\",\n    \"type\": \"gauge\",\n    \"value\": 0.25\n  }\n" +
+				"]\n",
+		},
+		{name: "empty json", format: "json", want: "[]\n"},
+		{
+			name:    "csv",
+			format:  "csv",
+			metrics: metrics,
+			want:    "type,name,value\ncounter,PollCount,42\ngauge,\"disk,usage\",0.25\n",
+		},
+		{name: "empty table", format: "table", want: "TYPE  NAME  VALUE\n"},
+		{name: "unknown format", format: "yaml", metrics: metrics, wantErr: "unsupported output format: yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := writeMetrics(&buf, tt.format, tt.metrics)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"time"
 )
 
 var (
@@ -13,6 +14,23 @@ var (
 	flagStoreInterval   int
 	flagFileStoragePath string
 	flagRestore         bool
+	flagTokensFile      string
+	flagTrustedSubnet   string
+	flagTLSCert         string
+	flagTLSKey          string
+	flagTLSClientCA     string
+	flagRateLimit       float64
+	flagRateBurst       int
+	flagMaxBodyBytes    int64
+	flagMaxDecompressed int64
+	flagMaxBatch        int
+	flagMaxSeries       int
+	flagMaxNewSeries    int
+	flagMaxAgentSeries  int
+	flagMetricTTL       time.Duration
+	flagKey             string
+	flagRequestTimeout  time.Duration
+	flagCompressMinSize int
 )
 
 func parseFlags() {
@@ -21,6 +39,23 @@ func parseFlags() {
 	flag.IntVar(&flagStoreInterval, "i", 300, "store interval")
 	flag.StringVar(&flagFileStoragePath, "f", "/tmp/metrics-db.json", "file for storage")
 	flag.BoolVar(&flagRestore, "r", true, "restore data from storage file")
+	flag.StringVar(&flagTokensFile, "tokens", "", "JSON file with API tokens, authentication is disabled if empty")
+	flag.StringVar(&flagTrustedSubnet, "t", "", "comma-separated CIDR list of subnets allowed to send updates")
+	flag.StringVar(&flagTLSCert, "tls-cert", "", "TLS certificate file, enables HTTPS")
+	flag.StringVar(&flagTLSKey, "tls-key", "", "TLS private key file")
+	flag.StringVar(&flagTLSClientCA, "tls-client-ca", "", "CA bundle for client certificate verification (mTLS)")
+	flag.Float64Var(&flagRateLimit, "rate-limit", 0, "max update requests per second per client, 0 disables the limit")
+	flag.IntVar(&flagRateBurst, "rate-burst", 20, "max burst of update requests per client")
+	flag.Int64Var(&flagMaxBodyBytes, "max-body", 1<<20, "max request body size in bytes")
+	flag.Int64Var(&flagMaxDecompressed, "max-decompressed-body", 8<<20, "max request body size after decompression in bytes")
+	flag.IntVar(&flagMaxBatch, "max-batch", 1000, "max number of metrics in one batch")
+	flag.IntVar(&flagMaxSeries, "max-series", 0, "max number of series in storage, 0 means unlimited")
+	flag.IntVar(&flagMaxNewSeries, "max-new-series", 0, "max number of new series per minute, 0 means unlimited")
+	flag.IntVar(&flagMaxAgentSeries, "max-series-per-agent", 0, "max number of series created by one agent, 0 means unlimited")
+	flag.DurationVar(&flagMetricTTL, "ttl", 0, "evict series not updated within this duration, 0 disables expiry")
+	flag.StringVar(&flagKey, "k", "", "key for HMAC-SHA256 signature of updates, signatures are not checked if empty")
+	flag.DurationVar(&flagRequestTimeout, "request-timeout", 10*time.Second, "max time to handle a request, 0 disables the timeout")
+	flag.IntVar(&flagCompressMinSize, "compress-min-size", 1024, "responses smaller than this many bytes are not compressed")
 	flag.Parse()
 	if envLogLevel := os.Getenv("LOG_LEVEL"); envLogLevel != "" {
 		flagLogLevel = envLogLevel
@@ -45,4 +80,99 @@ func parseFlags() {
 		}
 		flagRestore = envRestore
 	}
+	if envTokensFile := os.Getenv("TOKENS_FILE"); envTokensFile != "" {
+		flagTokensFile = envTokensFile
+	}
+	if envTrustedSubnet := os.Getenv("TRUSTED_SUBNET"); envTrustedSubnet != "" {
+		flagTrustedSubnet = envTrustedSubnet
+	}
+	if envTLSCert := os.Getenv("TLS_CERT"); envTLSCert != "" {
+		flagTLSCert = envTLSCert
+	}
+	if envTLSKey := os.Getenv("TLS_KEY"); envTLSKey != "" {
+		flagTLSKey = envTLSKey
+	}
+	if envTLSClientCA := os.Getenv("TLS_CLIENT_CA"); envTLSClientCA != "" {
+		flagTLSClientCA = envTLSClientCA
+	}
+	if v := os.Getenv("RATE_LIMIT"); v != "" {
+		envRateLimit, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			log.Fatalf("invalid RATE_LIMIT=%q: %v", v, err)
+		}
+		flagRateLimit = envRateLimit
+	}
+	if v := os.Getenv("RATE_BURST"); v != "" {
+		envRateBurst, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("invalid RATE_BURST=%q: %v", v, err)
+		}
+		flagRateBurst = envRateBurst
+	}
+	if v := os.Getenv("MAX_BODY_BYTES"); v != "" {
+		envMaxBodyBytes, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Fatalf("invalid MAX_BODY_BYTES=%q: %v", v, err)
+		}
+		flagMaxBodyBytes = envMaxBodyBytes
+	}
+	if v := os.Getenv("MAX_DECOMPRESSED_BYTES"); v != "" {
+		envMaxDecompressed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Fatalf("invalid MAX_DECOMPRESSED_BYTES=%q: %v", v, err)
+		}
+		flagMaxDecompressed = envMaxDecompressed
+	}
+	if v := os.Getenv("COMPRESS_MIN_SIZE"); v != "" {
+		envCompressMinSize, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("invalid COMPRESS_MIN_SIZE=%q: %v", v, err)
+		}
+		flagCompressMinSize = envCompressMinSize
+	}
+	if v := os.Getenv("MAX_BATCH"); v != "" {
+		envMaxBatch, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("invalid MAX_BATCH=%q: %v", v, err)
+		}
+		flagMaxBatch = envMaxBatch
+	}
+	if v := os.Getenv("MAX_SERIES"); v != "" {
+		envMaxSeries, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("invalid MAX_SERIES=%q: %v", v, err)
+		}
+		flagMaxSeries = envMaxSeries
+	}
+	if v := os.Getenv("MAX_NEW_SERIES"); v != "" {
+		envMaxNewSeries, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("invalid MAX_NEW_SERIES=%q: %v", v, err)
+		}
+		flagMaxNewSeries = envMaxNewSeries
+	}
+	if v := os.Getenv("MAX_SERIES_PER_AGENT"); v != "" {
+		envMaxAgentSeries, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("invalid MAX_SERIES_PER_AGENT=%q: %v", v, err)
+		}
+		flagMaxAgentSeries = envMaxAgentSeries
+	}
+	if v := os.Getenv("METRIC_TTL"); v != "" {
+		envMetricTTL, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("invalid METRIC_TTL=%q: %v", v, err)
+		}
+		flagMetricTTL = envMetricTTL
+	}
+	if envKey := os.Getenv("KEY"); envKey != "" {
+		flagKey = envKey
+	}
+	if v := os.Getenv("REQUEST_TIMEOUT"); v != "" {
+		envRequestTimeout, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("invalid REQUEST_TIMEOUT=%q: %v", v, err)
+		}
+		flagRequestTimeout = envRequestTimeout
+	}
 }
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"monalert/internal/auth"
 	"monalert/internal/handlers"
+	"monalert/internal/hub"
 	"monalert/internal/logger"
 	"monalert/internal/repository"
 	"monalert/internal/service"
@@ -26,32 +29,86 @@ func run() error {
 	logger.Log.Info("Running server", zap.String("log level", flagLogLevel))
 	store := repository.NewStore(flagFileStoragePath, flagStoreInterval == 0)
 	if flagRestore {
-		if err := store.Restore(); err != nil {
+		if err := store.Restore(context.Background()); err != nil {
 			log.Fatal(err)
 		}
 	}
-	if flagStoreInterval > 0 {
-		go func() {
-			ticker := time.NewTicker(time.Duration(flagStoreInterval) * time.Second)
-			defer ticker.Stop()
-			for range ticker.C {
-				if err := store.Persist(); err != nil {
-					logger.Log.Error("persist error:", zap.Error(err))
-				}
-			}
-		}()
+	// лимиты задаются после восстановления, чтобы файл поднимался целиком
+	store.SetCardinalityLimits(repository.CardinalityLimits{
+		MaxSeries:          flagMaxSeries,
+		MaxNewSeriesPerMin: flagMaxNewSeries,
+		MaxSeriesPerAgent:  flagMaxAgentSeries,
+	})
+	trustedSubnets, err := handlers.ParseTrustedSubnets(flagTrustedSubnet)
+	if err != nil {
+		return err
+	}
+	cfg := handlers.Config{
+		Addr:            flagServerAddr,
+		TrustedSubnets:  trustedSubnets,
+		TLSCertFile:     flagTLSCert,
+		TLSKeyFile:      flagTLSKey,
+		TLSClientCAFile: flagTLSClientCA,
+		Key:             flagKey,
+		RequestTimeout:  flagRequestTimeout,
+		CompressMinSize: flagCompressMinSize,
+		Limits: handlers.Limits{
+			Rate:                 flagRateLimit,
+			Burst:                flagRateBurst,
+			MaxBodyBytes:         flagMaxBodyBytes,
+			MaxDecompressedBytes: flagMaxDecompressed,
+			MaxBatch:             flagMaxBatch,
+		},
 	}
-	if flagStoreInterval == 0 {
-		monalertService := service.NewMonalert(store, true)
-		if err := handlers.Serve(flagServerAddr, monalertService); err != nil {
-			return fmt.Errorf("failed to start server with config %s: %w", flagServerAddr, err)
+	if flagTokensFile != "" {
+		tokens, err := auth.Load(flagTokensFile)
+		if err != nil {
+			return err
 		}
-		return nil
-	} else {
-		monalertService := service.NewMonalert(store, false)
-		if err := handlers.Serve(flagServerAddr, monalertService); err != nil {
-			return fmt.Errorf("failed to start server with config %s: %w", flagServerAddr, err)
+		cfg.Tokens = tokens
+	}
+	updates := hub.New(1024, 256)
+	monalertService := service.NewMonalert(store, flagStoreInterval == 0, updates)
+	startPersist(monalertService, time.Duration(flagStoreInterval)*time.Second)
+	startExpiry(monalertService, flagMetricTTL)
+	if err := handlers.Serve(cfg, monalertService); err != nil {
+		return fmt.Errorf("failed to start server with config %s: %w", flagServerAddr, err)
+	}
+	return nil
+}
+
+// startPersist периодически сохраняет хранилище. Сохранение идёт через сервис,
+// чтобы его длительность и ошибки попадали в метрики сервера. Нулевой интервал —
+// синхронный режим, в нём сервис сохраняет хранилище сам после каждой записи.
+func startPersist(monalertService *service.Monalert, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := monalertService.Persist(context.Background()); err != nil {
+				logger.Log.Error("persist error:", zap.Error(err))
+			}
 		}
-		return nil
+	}()
+}
+
+// startExpiry запускает фоновое удаление серий, которые не обновлялись дольше ttl.
+// Проверка идёт с шагом в десятую часть ttl, но не чаще раза в секунду.
+func startExpiry(monalertService *service.Monalert, ttl time.Duration) {
+	if ttl <= 0 {
+		return
 	}
+	interval := max(ttl/10, time.Second)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := monalertService.Expire(context.Background(), ttl); err != nil {
+				logger.Log.Error("expiry error:", zap.Error(err))
+			}
+		}
+	}()
 }
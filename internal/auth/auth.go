@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+const (
+	ScopeRead  = "metrics:read"
+	ScopeWrite = "metrics:write"
+	// ScopeAdmin даёт доступ ко всем операциям
+	ScopeAdmin = "admin"
+
+	hashPrefix = "sha256:"
+)
+
+var (
+	// ErrNoToken — запрос пришёл без токена.
+	ErrNoToken = errors.New("auth: no bearer token")
+	// ErrInvalidToken — токен не найден среди настроенных.
+	ErrInvalidToken = errors.New("auth: invalid token")
+)
+
+// Token — API-токен из конфигурации. Сам секрет не хранится, только его хеш.
+type Token struct {
+	Name   string   `json:"name"`
+	Hash   string   `json:"hash"` // "sha256:<hex>" от секрета
+	Scopes []string `json:"scopes"`
+}
+
+// knownScopes — scope, которые понимает сервер. Опечатка в файле токенов иначе молча
+// оставила бы токен без доступа.
+var knownScopes = []string{ScopeRead, ScopeWrite, ScopeAdmin}
+
+func (t Token) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope) || slices.Contains(t.Scopes, ScopeAdmin)
+}
+
+// Tokens — набор токенов, загруженный из JSON-файла вида
+// [{"name":"agent-1","hash":"sha256:...","scopes":["metrics:write"]}].
+type Tokens struct {
+	byHash map[string]Token
+}
+
+// Hash возвращает хеш секрета в формате, который ожидает файл токенов.
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hashPrefix + hex.EncodeToString(sum[:])
+}
+
+func Load(path string) (*Tokens, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read tokens file: %w", err)
+	}
+	var list []Token
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal tokens file: %w", err)
+	}
+	tokens := &Tokens{byHash: make(map[string]Token, len(list))}
+	for i, t := range list {
+		if t.Name == "" {
+			return nil, fmt.Errorf("token %d: empty name", i)
+		}
+		hash := strings.ToLower(t.Hash)
+		if !strings.HasPrefix(hash, hashPrefix) || len(hash) != len(hashPrefix)+sha256.Size*2 {
+			return nil, fmt.Errorf("token %s: hash must be %q followed by hex digest", t.Name, hashPrefix)
+		}
+		for _, scope := range t.Scopes {
+			if !slices.Contains(knownScopes, scope) {
+				return nil, fmt.Errorf("token %s: unknown scope %q", t.Name, scope)
+			}
+		}
+		if _, ok := tokens.byHash[hash]; ok {
+			return nil, fmt.Errorf("token %s: duplicate hash", t.Name)
+		}
+		t.Hash = hash
+		tokens.byHash[hash] = t
+	}
+	return tokens, nil
+}
+
+// Authenticate находит токен запроса по заголовку Authorization: Bearer
+// или параметру access_token, см. BearerToken.
+func (t *Tokens) Authenticate(r *http.Request) (Token, error) {
+	secret := BearerToken(r)
+	if secret == "" {
+		return Token{}, ErrNoToken
+	}
+	token, ok := t.byHash[Hash(secret)]
+	if !ok {
+		return Token{}, ErrInvalidToken
+	}
+	return token, nil
+}
+
+// BearerToken возвращает секрет из заголовка Authorization: Bearer. Параметр access_token
+// принимается только в GET и HEAD: он нужен дашборду в браузере и WebSocket-клиентам,
+// которые не умеют в заголовки, а URL с секретом попадает в логи прокси, поэтому
+// запросы на запись обязаны передавать токен заголовком.
+func BearerToken(r *http.Request) string {
+	if v, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(v)
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return ""
+	}
+	return r.URL.Query().Get("access_token")
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTokens(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	agent, ops := Hash("agent-secret"), Hash("ops-secret")
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "valid",
+			content: `[{"name":"agent","hash":"` + agent + `","scopes":["metrics:write"]},{"name":"ops","hash":"` + ops + `","scopes":["admin"]}]`,
+		},
+		{name: "malformed json", content: `[{"name":"agent",`, wantErr: "cannot unmarshal tokens file"},
+		{name: "not a list", content: `{"name":"agent"}`, wantErr: "cannot unmarshal tokens file"},
+		{name: "empty name", content: `[{"hash":"` + agent + `"}]`, wantErr: "token 0: empty name"},
+		{name: "hash without prefix", content: `[{"name":"agent","hash":"` + agent[len(hashPrefix):] + `"}]`, wantErr: "token agent: hash must be"},
+		{name: "short hash", content: `[{"name":"agent","hash":"sha256:abc"}]`, wantErr: "token agent: hash must be"},
+		{
+			name:    "duplicate hash",
+			content: `[{"name":"agent","hash":"` + agent + `"},{"name":"agent-2","hash":"` + agent + `"}]`,
+			wantErr: "token agent-2: duplicate hash",
+		},
+		{
+			name:    "unknown scope",
+			content: `[{"name":"agent","hash":"` + agent + `","scopes":["metrics:wirte"]}]`,
+			wantErr: `token agent: unknown scope "metrics:wirte"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := Load(writeTokens(t, tt.content))
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, tokens.byHash, 2)
+		})
+	}
+
+	_, err := Load(filepath.Join(t.TempDir(), "missing.json"))
+	assert.ErrorContains(t, err, "cannot read tokens file")
+}
+
+func TestAuthenticate(t *testing.T) {
+	// регистр hex в файле не важен
+	reader := strings.ToUpper(Hash("reader-secret")[len(hashPrefix):])
+	tokens, err := Load(writeTokens(t, `[{"name":"agent","hash":"`+Hash("agent-secret")+`","scopes":["metrics:write"]},`+
+		`{"name":"reader","hash":"sha256:`+reader+`","scopes":["metrics:read"]}]`))
+	require.NoError(t, err)
+
+	request := func(method, target, header string) *http.Request {
+		r := httptest.NewRequest(method, target, nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		return r
+	}
+	tests := []struct {
+		name    string
+		req     *http.Request
+		want    string
+		wantErr error
+	}{
+		{name: "bearer header", req: request(http.MethodPost, "/updates/", "Bearer agent-secret"), want: "agent"},
+		{name: "header wins over query", req: request(http.MethodGet, "/?access_token=agent-secret", "Bearer reader-secret"), want: "reader"},
+		{name: "query token on GET", req: request(http.MethodGet, "/?access_token=reader-secret", ""), want: "reader"},
+		{name: "query token on HEAD", req: request(http.MethodHead, "/?access_token=reader-secret", ""), want: "reader"},
+		{name: "query token on POST is ignored", req: request(http.MethodPost, "/updates/?access_token=agent-secret", ""), wantErr: ErrNoToken},
+		{name: "query token on PUT is ignored", req: request(http.MethodPut, "/api/v1/metrics/counter/a?access_token=agent-secret", ""), wantErr: ErrNoToken},
+		{name: "no token", req: request(http.MethodGet, "/", ""), wantErr: ErrNoToken},
+		{name: "other scheme", req: request(http.MethodGet, "/", "Basic YWdlbnQ6c2VjcmV0"), wantErr: ErrNoToken},
+		{name: "wrong secret", req: request(http.MethodGet, "/", "Bearer agent-secret2"), wantErr: ErrInvalidToken},
+		{name: "hash instead of secret", req: request(http.MethodGet, "/", "Bearer "+Hash("agent-secret")), wantErr: ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := tokens.Authenticate(tt.req)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, token.Name)
+		})
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []string
+		scope  string
+		want   bool
+	}{
+		{name: "granted", scopes: []string{ScopeRead}, scope: ScopeRead, want: true},
+		{name: "read does not allow write", scopes: []string{ScopeRead}, scope: ScopeWrite},
+		{name: "write does not allow read", scopes: []string{ScopeWrite}, scope: ScopeRead},
+		{name: "admin allows everything", scopes: []string{ScopeAdmin}, scope: ScopeWrite, want: true},
+		{name: "no scopes", scope: ScopeRead},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Token{Scopes: tt.scopes}.HasScope(tt.scope))
+		})
+	}
+}
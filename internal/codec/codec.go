@@ -0,0 +1,185 @@
+// Package codec кодирует тела запросов и ответов в форматах, которые клиент выбирает
+// заголовками Accept и Content-Type: JSON, CSV, MessagePack и protobuf.
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"monalert/internal/models"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupportedValue — формат не умеет кодировать значение такого типа.
+var ErrUnsupportedValue = errors.New("codec: unsupported value")
+
+// ErrListLength — в список записано не то число метрик, что было объявлено при его создании.
+var ErrListLength = errors.New("codec: list length mismatch")
+
+// Codec — один формат тел запросов и ответов.
+//
+// Encode принимает models.Metrics, *models.Metrics, []models.Metrics, models.MetricList
+// и models.History. Decode читает как минимум *models.Metrics и *[]models.Metrics.
+// Остальные типы — ErrUnsupportedValue.
+type Codec interface {
+	// ContentType — MIME-тип формата без параметров.
+	ContentType() string
+	Encode(w io.Writer, v any) error
+	Decode(r io.Reader, v any) error
+	// NewListWriter пишет список из n метрик по одной, не собирая его в памяти. Формат, которому
+	// число элементов нужно заранее, пишет n в начале списка и при другом числе метрик возвращает
+	// ErrListLength; остальные форматы n не используют.
+	NewListWriter(w io.Writer, n int) ListWriter
+}
+
+// ListWriter пишет список метрик по одной. Close завершает список, но не закрывает w.
+type ListWriter interface {
+	Write(m models.Metrics) error
+	Close() error
+}
+
+// Registry — набор форматов. Первый зарегистрированный формат используется по умолчанию.
+type Registry struct {
+	codecs []Codec
+	// byType — формат по MIME-типу, включая дополнительные имена
+	byType map[string]Codec
+}
+
+func NewRegistry() *Registry {
+	return &Registry{byType: make(map[string]Codec)}
+}
+
+// Default — реестр со всеми форматами сервера, JSON по умолчанию.
+func Default() *Registry {
+	r := NewRegistry()
+	r.Register(JSON{})
+	r.Register(CSV{})
+	r.Register(MessagePack{}, "application/x-msgpack", "application/vnd.msgpack")
+	r.Register(Protobuf{}, "application/protobuf", "application/vnd.google.protobuf")
+	return r
+}
+
+// Register добавляет формат. aliases — другие MIME-типы, под которыми клиенты его присылают.
+func (r *Registry) Register(c Codec, aliases ...string) {
+	r.codecs = append(r.codecs, c)
+	for _, t := range append([]string{c.ContentType()}, aliases...) {
+		r.byType[t] = c
+	}
+}
+
+// Default возвращает формат по умолчанию.
+func (r *Registry) Default() Codec {
+	return r.codecs[0]
+}
+
+// ContentTypes перечисляет основные MIME-типы форматов в порядке регистрации.
+func (r *Registry) ContentTypes() []string {
+	types := make([]string, 0, len(r.codecs))
+	for _, c := range r.codecs {
+		types = append(types, c.ContentType())
+	}
+	return types
+}
+
+// ForContentType находит формат по заголовку Content-Type.
+func (r *Registry) ForContentType(contentType string) (Codec, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+	c, ok := r.byType[mediaType]
+	return c, ok
+}
+
+// Negotiate выбирает формат ответа по заголовку Accept с учётом весов q.
+// Пустой Accept означает формат по умолчанию. Если ни один формат не подходит, ok — false.
+func (r *Registry) Negotiate(accept string) (Codec, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return r.Default(), true
+	}
+	return r.best(parseAccept(accept), false)
+}
+
+// Explicit выбирает формат, только если клиент назвал его тип явно, без масок вроде */*.
+// Так страницы, которые отдают HTML браузеру, отличают запрос данных от обычного перехода.
+func (r *Registry) Explicit(accept string) (Codec, bool) {
+	return r.best(parseAccept(accept), true)
+}
+
+func (r *Registry) best(ranges []acceptRange, exactOnly bool) (Codec, bool) {
+	var best Codec
+	bestQ := 0.0
+	for _, c := range r.codecs {
+		q := 0.0
+		for t, registered := range r.byType {
+			if registered == c {
+				q = max(q, quality(ranges, t, exactOnly))
+			}
+		}
+		if q > bestQ {
+			best, bestQ = c, q
+		}
+	}
+	return best, best != nil
+}
+
+// acceptRange — элемент заголовка Accept: тип или маска и вес.
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+	// более конкретный диапазон важнее маски: text/csv;q=0 запрещает CSV даже при */*
+	sort.SliceStable(ranges, func(i, j int) bool { return specificity(ranges[i].mediaType) > specificity(ranges[j].mediaType) })
+	return ranges
+}
+
+func specificity(mediaType string) int {
+	switch {
+	case mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(mediaType, "/*"):
+		return 1
+	default:
+		return 2
+	}
+}
+
+// quality — вес типа по самому конкретному подходящему диапазону, 0 — тип не принимается.
+func quality(ranges []acceptRange, mediaType string, exactOnly bool) float64 {
+	major, _, _ := strings.Cut(mediaType, "/")
+	for _, rng := range ranges {
+		switch {
+		case rng.mediaType == mediaType:
+		case exactOnly:
+			continue
+		case rng.mediaType == "*/*", rng.mediaType == major+"/*":
+		default:
+			continue
+		}
+		return rng.q
+	}
+	return 0
+}
+
+func unsupported(format string, v any) error {
+	return fmt.Errorf("%w: %s cannot handle %T", ErrUnsupportedValue, format, v)
+}
@@ -0,0 +1,193 @@
+package codec
+
+import (
+	"bytes"
+	"monalert/internal/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testMetrics() []models.Metrics {
+	value, delta := 1.5, int64(42)
+	return []models.Metrics{
+		{ID: "Alloc", MType: "gauge", Value: &value},
+		{ID: "PollCount", MType: "counter", Delta: &delta, Cumulative: true},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, c := range Default().codecs {
+		t.Run(c.ContentType(), func(t *testing.T) {
+			metrics := testMetrics()
+			if _, ok := c.(CSV); ok {
+				// CSV в ответе не пишет cumulative: сервер не возвращает это поле
+				metrics[1].Cumulative = false
+			}
+
+			var buf bytes.Buffer
+			require.NoError(t, c.Encode(&buf, metrics))
+			var batch []models.Metrics
+			require.NoError(t, c.Decode(&buf, &batch))
+			assert.Equal(t, metrics, batch)
+
+			buf.Reset()
+			require.NoError(t, c.Encode(&buf, metrics[0]))
+			var single models.Metrics
+			require.NoError(t, c.Decode(&buf, &single))
+			assert.Equal(t, metrics[0], single)
+
+			buf.Reset()
+			list := c.NewListWriter(&buf, len(metrics))
+			for _, m := range metrics {
+				require.NoError(t, list.Write(m))
+			}
+			require.NoError(t, list.Close())
+			batch = nil
+			require.NoError(t, c.Decode(&buf, &batch))
+			assert.Equal(t, metrics, batch)
+
+			buf.Reset()
+			require.NoError(t, c.NewListWriter(&buf, 0).Close())
+			batch = nil
+			require.NoError(t, c.Decode(&buf, &batch))
+			assert.Empty(t, batch)
+		})
+	}
+}
+
+func TestMessagePackListWriter(t *testing.T) {
+	metrics := testMetrics()
+	var buf bytes.Buffer
+	list := MessagePack{}.NewListWriter(&buf, len(metrics))
+	require.NoError(t, list.Write(metrics[0]))
+	// элемент уходит в w сразу, а не копится до Close
+	assert.NotZero(t, buf.Len())
+	require.NoError(t, list.Write(metrics[1]))
+	require.ErrorIs(t, list.Write(metrics[0]), ErrListLength)
+	require.NoError(t, list.Close())
+
+	// записано меньше объявленного: серия пропала во время обхода
+	list = MessagePack{}.NewListWriter(&buf, 2)
+	require.NoError(t, list.Write(metrics[0]))
+	require.ErrorIs(t, list.Close(), ErrListLength)
+}
+
+func TestEncodeHistory(t *testing.T) {
+	h := models.History{
+		ID:    "Alloc",
+		MType: "gauge",
+		Samples: []models.Sample{
+			{Time: time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC), Value: 1},
+			{Time: time.Date(2024, 5, 1, 12, 0, 10, 0, time.UTC), Value: 2.25},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, CSV{}.Encode(&buf, h))
+	assert.Equal(t, "time,value\n2024-05-01T12:00:00.0000005Z,1\n2024-05-01T12:00:10Z,2.25\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, Protobuf{}.Encode(&buf, h))
+	var got models.History
+	require.NoError(t, Protobuf{}.Decode(&buf, &got))
+	assert.Equal(t, h, got)
+}
+
+func TestDecodeCSV(t *testing.T) {
+	var batch []models.Metrics
+	require.NoError(t, CSV{}.Decode(strings.NewReader("type,id,delta,cumulative\ncounter,PollCount,5,true\n"), &batch))
+	require.Len(t, batch, 1)
+	assert.Equal(t, "PollCount", batch[0].ID)
+	assert.Equal(t, int64(5), *batch[0].Delta)
+	assert.Nil(t, batch[0].Value)
+	assert.True(t, batch[0].Cumulative)
+
+	err := CSV{}.Decode(strings.NewReader("id,type,unit\nAlloc,gauge,bytes\n"), &batch)
+	assert.EqualError(t, err, `csv: unknown column "unit"`)
+	err = CSV{}.Decode(strings.NewReader("id,value\nAlloc,1\n"), &batch)
+	assert.EqualError(t, err, `csv: column "type" is required`)
+	err = CSV{}.Decode(strings.NewReader("id,type,value\nAlloc,gauge,x\n"), &batch)
+	assert.EqualError(t, err, `csv: line 2: invalid value "x"`)
+}
+
+func TestDecodeUnknownFields(t *testing.T) {
+	var m models.Metrics
+	err := JSON{}.Decode(strings.NewReader(`{"id":"a","type":"gauge","unit":"bytes"}`), &m)
+	assert.EqualError(t, err, `json: unknown field "unit"`)
+
+	var buf bytes.Buffer
+	require.NoError(t, MessagePack{}.Encode(&buf, map[string]any{"id": "a", "unit": "bytes"}))
+	err = MessagePack{}.Decode(&buf, &m)
+	assert.EqualError(t, err, `msgpack: unknown field "unit"`)
+}
+
+func TestUnsupportedValue(t *testing.T) {
+	for _, c := range Default().codecs {
+		if _, ok := c.(JSON); ok {
+			continue
+		}
+		err := c.Encode(&bytes.Buffer{}, map[string]int{"a": 1})
+		if _, ok := c.(MessagePack); ok {
+			// MessagePack, как и JSON, кодирует любые значения
+			assert.NoError(t, err)
+			continue
+		}
+		assert.ErrorIs(t, err, ErrUnsupportedValue, c.ContentType())
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	r := Default()
+	tests := []struct {
+		accept   string
+		want     string
+		explicit string
+	}{
+		{accept: "", want: "application/json"},
+		{accept: "*/*", want: "application/json"},
+		{accept: "text/csv", want: "text/csv", explicit: "text/csv"},
+		{accept: "text/*", want: "text/csv"},
+		{accept: "application/x-msgpack", want: "application/msgpack", explicit: "application/msgpack"},
+		{accept: "application/protobuf", want: "application/x-protobuf", explicit: "application/x-protobuf"},
+		{accept: "application/json;q=0.5, text/csv", want: "text/csv", explicit: "text/csv"},
+		{accept: "text/csv;q=0.2, application/json;q=0.9", want: "application/json", explicit: "application/json"},
+		{accept: "*/*, application/json;q=0", want: "text/csv"},
+		{accept: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", want: "application/json"},
+		{accept: "text/html", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			c, ok := r.Negotiate(tt.accept)
+			if tt.want == "" {
+				assert.False(t, ok)
+			} else if assert.True(t, ok) {
+				assert.Equal(t, tt.want, c.ContentType())
+			}
+
+			c, ok = r.Explicit(tt.accept)
+			if tt.explicit == "" {
+				assert.False(t, ok)
+			} else if assert.True(t, ok) {
+				assert.Equal(t, tt.explicit, c.ContentType())
+			}
+		})
+	}
+}
+
+func TestForContentType(t *testing.T) {
+	r := Default()
+	c, ok := r.ForContentType("text/csv; charset=utf-8")
+	require.True(t, ok)
+	assert.Equal(t, "text/csv", c.ContentType())
+	c, ok = r.ForContentType("application/vnd.msgpack")
+	require.True(t, ok)
+	assert.Equal(t, "application/msgpack", c.ContentType())
+	_, ok = r.ForContentType("text/plain")
+	assert.False(t, ok)
+	_, ok = r.ForContentType("")
+	assert.False(t, ok)
+}
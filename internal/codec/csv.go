@@ -0,0 +1,180 @@
+package codec
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"monalert/internal/models"
+	"strconv"
+	"time"
+)
+
+// CSV — таблица с заголовком для выгрузки в электронные таблицы.
+//
+// Метрики пишутся колонками id,type,value,delta; у gauge пуста колонка delta, у counter — value.
+// В батче на приём колонки можно переставлять, value, delta и cumulative необязательны.
+// История пишется колонками time,value. Поля total и next страницы списка в CSV не попадают,
+// сервер передаёт их заголовками.
+type CSV struct{}
+
+var (
+	csvMetricHeader  = []string{"id", "type", "value", "delta"}
+	csvHistoryHeader = []string{"time", "value"}
+)
+
+func (CSV) ContentType() string { return "text/csv" }
+
+func (c CSV) Encode(w io.Writer, v any) error {
+	switch v := v.(type) {
+	case models.Metrics:
+		return c.Encode(w, []models.Metrics{v})
+	case *models.Metrics:
+		return c.Encode(w, []models.Metrics{*v})
+	case models.MetricList:
+		return c.Encode(w, v.Metrics)
+	case []models.Metrics:
+		l := c.NewListWriter(w, len(v))
+		for _, m := range v {
+			if err := l.Write(m); err != nil {
+				return err
+			}
+		}
+		return l.Close()
+	case models.History:
+		cw := csv.NewWriter(w)
+		_ = cw.Write(csvHistoryHeader)
+		for _, s := range v.Samples {
+			_ = cw.Write([]string{s.Time.UTC().Format(time.RFC3339Nano), formatFloat(s.Value)})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return unsupported("csv", v)
+	}
+}
+
+func (CSV) Decode(r io.Reader, v any) error {
+	switch v := v.(type) {
+	case *[]models.Metrics:
+		metrics, err := readCSVMetrics(r)
+		if err != nil {
+			return err
+		}
+		*v = metrics
+		return nil
+	case *models.Metrics:
+		metrics, err := readCSVMetrics(r)
+		if err != nil {
+			return err
+		}
+		if len(metrics) != 1 {
+			return fmt.Errorf("csv: want exactly one metric, got %d", len(metrics))
+		}
+		*v = metrics[0]
+		return nil
+	default:
+		return unsupported("csv", v)
+	}
+}
+
+func (CSV) NewListWriter(w io.Writer, _ int) ListWriter {
+	return &csvListWriter{w: csv.NewWriter(w)}
+}
+
+// csvListWriter пишет заголовок перед первой строкой, так что пустой список — только заголовок.
+type csvListWriter struct {
+	w       *csv.Writer
+	started bool
+}
+
+func (l *csvListWriter) Write(m models.Metrics) error {
+	if !l.started {
+		l.started = true
+		if err := l.w.Write(csvMetricHeader); err != nil {
+			return err
+		}
+	}
+	row := []string{m.ID, m.MType, "", ""}
+	if m.Value != nil {
+		row[2] = formatFloat(*m.Value)
+	}
+	if m.Delta != nil {
+		row[3] = strconv.FormatInt(*m.Delta, 10)
+	}
+	return l.w.Write(row)
+}
+
+func (l *csvListWriter) Close() error {
+	if !l.started {
+		l.started = true
+		if err := l.w.Write(csvMetricHeader); err != nil {
+			return err
+		}
+	}
+	l.w.Flush()
+	return l.w.Error()
+}
+
+func readCSVMetrics(r io.Reader) ([]models.Metrics, error) {
+	cr := csv.NewReader(r)
+	cr.ReuseRecord = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("csv: cannot read header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		switch name {
+		case "id", "type", "value", "delta", "cumulative":
+		default:
+			return nil, fmt.Errorf("csv: unknown column %q", name)
+		}
+		columns[name] = i
+	}
+	for _, name := range []string{"id", "type"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("csv: column %q is required", name)
+		}
+	}
+	cell := func(record []string, name string) string {
+		if i, ok := columns[name]; ok {
+			return record[i]
+		}
+		return ""
+	}
+	var metrics []models.Metrics
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return metrics, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("csv: %w", err)
+		}
+		m := models.Metrics{ID: cell(record, "id"), MType: cell(record, "type")}
+		if s := cell(record, "value"); s != "" {
+			value, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, fmt.Errorf("csv: line %d: invalid value %q", line, s)
+			}
+			m.Value = &value
+		}
+		if s := cell(record, "delta"); s != "" {
+			delta, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("csv: line %d: invalid delta %q", line, s)
+			}
+			m.Delta = &delta
+		}
+		if s := cell(record, "cumulative"); s != "" {
+			if m.Cumulative, err = strconv.ParseBool(s); err != nil {
+				return nil, fmt.Errorf("csv: line %d: invalid cumulative %q", line, s)
+			}
+		}
+		metrics = append(metrics, m)
+	}
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
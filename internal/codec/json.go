@@ -0,0 +1,62 @@
+package codec
+
+import (
+	"encoding/json"
+	"io"
+	"monalert/internal/models"
+)
+
+// JSON — формат по умолчанию. Неизвестные поля в запросе — ошибка, чтобы опечатка
+// в имени поля не превращалась молча в пустое значение.
+type JSON struct{}
+
+func (JSON) ContentType() string { return "application/json" }
+
+func (JSON) Encode(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	// ссылки на следующую страницу содержат &, экранировать его для HTML незачем
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
+
+func (JSON) Decode(r io.Reader, v any) error {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+func (JSON) NewListWriter(w io.Writer, _ int) ListWriter {
+	return &jsonListWriter{w: w}
+}
+
+// jsonListWriter пишет JSON-массив по одному элементу.
+type jsonListWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (l *jsonListWriter) Write(m models.Metrics) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	sep := []byte{','}
+	if !l.started {
+		sep[0] = '['
+		l.started = true
+	}
+	if _, err := l.w.Write(sep); err != nil {
+		return err
+	}
+	_, err = l.w.Write(data)
+	return err
+}
+
+func (l *jsonListWriter) Close() error {
+	end := "]\n"
+	if !l.started {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(l.w, end)
+	return err
+}
@@ -0,0 +1,75 @@
+package codec
+
+import (
+	"fmt"
+	"io"
+	"monalert/internal/models"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MessagePack — компактный двоичный формат с той же структурой, что у JSON:
+// имена полей берутся из тегов json, время в истории — расширение timestamp.
+type MessagePack struct{}
+
+func (MessagePack) ContentType() string { return "application/msgpack" }
+
+func (MessagePack) Encode(w io.Writer, v any) error {
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	enc.SetOmitEmpty(true)
+	return enc.Encode(v)
+}
+
+func (MessagePack) Decode(r io.Reader, v any) error {
+	dec := msgpack.NewDecoder(r)
+	dec.SetCustomStructTag("json")
+	dec.DisallowUnknownFields(true)
+	return dec.Decode(v)
+}
+
+// NewListWriter пишет элементы массива по мере поступления: массив MessagePack начинается
+// с числа элементов, поэтому оно передаётся заранее.
+func (m MessagePack) NewListWriter(w io.Writer, n int) ListWriter {
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	enc.SetOmitEmpty(true)
+	return &msgpackListWriter{enc: enc, n: n}
+}
+
+type msgpackListWriter struct {
+	enc     *msgpack.Encoder
+	n       int
+	written int
+	started bool
+}
+
+// start пишет заголовок массива перед первым элементом или при закрытии пустого списка.
+func (l *msgpackListWriter) start() error {
+	if l.started {
+		return nil
+	}
+	l.started = true
+	return l.enc.EncodeArrayLen(l.n)
+}
+
+func (l *msgpackListWriter) Write(m models.Metrics) error {
+	if l.written == l.n {
+		return fmt.Errorf("%w: more than %d metrics", ErrListLength, l.n)
+	}
+	if err := l.start(); err != nil {
+		return err
+	}
+	l.written++
+	return l.enc.Encode(m)
+}
+
+func (l *msgpackListWriter) Close() error {
+	if err := l.start(); err != nil {
+		return err
+	}
+	if l.written != l.n {
+		return fmt.Errorf("%w: wrote %d of %d metrics", ErrListLength, l.written, l.n)
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"monalert/internal/models"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Protobuf — двоичный формат по схеме metrics.proto. Сообщения собираются вручную через
+// protowire, так что сгенерированный код не нужен. Одна метрика — сообщение Metric,
+// список и батч — MetricList, история — History. Неизвестные поля при чтении пропускаются,
+// как принято в protobuf.
+type Protobuf struct{}
+
+// номера полей из metrics.proto
+const (
+	pbMetricID         protowire.Number = 1
+	pbMetricType       protowire.Number = 2
+	pbMetricValue      protowire.Number = 3
+	pbMetricDelta      protowire.Number = 4
+	pbMetricCumulative protowire.Number = 5
+
+	pbListMetrics protowire.Number = 1
+	pbListTotal   protowire.Number = 2
+	pbListNext    protowire.Number = 3
+
+	pbSampleTime  protowire.Number = 1
+	pbSampleValue protowire.Number = 2
+
+	pbHistoryID      protowire.Number = 1
+	pbHistoryType    protowire.Number = 2
+	pbHistorySamples protowire.Number = 3
+)
+
+// maxProtobufBody ограничивает чтение тела: сообщение protobuf нельзя разобрать по частям.
+// Размер запросов сервер ограничивает раньше, это защита для остальных вызовов.
+const maxProtobufBody = 64 << 20
+
+var errProtobufTooLarge = errors.New("protobuf: message is too large")
+
+func (Protobuf) ContentType() string { return "application/x-protobuf" }
+
+func (Protobuf) Encode(w io.Writer, v any) error {
+	var b []byte
+	switch v := v.(type) {
+	case models.Metrics:
+		b = appendMetric(nil, v)
+	case *models.Metrics:
+		b = appendMetric(nil, *v)
+	case []models.Metrics:
+		b = appendMetricList(nil, models.MetricList{Metrics: v})
+	case models.MetricList:
+		b = appendMetricList(nil, v)
+	case models.History:
+		b = appendHistory(nil, v)
+	default:
+		return unsupported("protobuf", v)
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func (Protobuf) Decode(r io.Reader, v any) error {
+	data, err := io.ReadAll(io.LimitReader(r, maxProtobufBody+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxProtobufBody {
+		return errProtobufTooLarge
+	}
+	switch v := v.(type) {
+	case *models.Metrics:
+		return consumeMetric(data, v)
+	case *[]models.Metrics:
+		var list models.MetricList
+		if err := consumeMetricList(data, &list); err != nil {
+			return err
+		}
+		*v = list.Metrics
+		return nil
+	case *models.MetricList:
+		return consumeMetricList(data, v)
+	case *models.History:
+		return consumeHistory(data, v)
+	default:
+		return unsupported("protobuf", v)
+	}
+}
+
+// NewListWriter пишет элементы поля metrics сообщения MetricList по мере поступления:
+// повторяющееся поле protobuf не требует знать число элементов заранее.
+func (Protobuf) NewListWriter(w io.Writer, _ int) ListWriter {
+	return &protobufListWriter{w: w}
+}
+
+type protobufListWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (l *protobufListWriter) Write(m models.Metrics) error {
+	l.buf = protowire.AppendTag(l.buf[:0], pbListMetrics, protowire.BytesType)
+	l.buf = protowire.AppendBytes(l.buf, appendMetric(nil, m))
+	_, err := l.w.Write(l.buf)
+	return err
+}
+
+func (l *protobufListWriter) Close() error { return nil }
+
+func appendMetric(b []byte, m models.Metrics) []byte {
+	b = appendString(b, pbMetricID, m.ID)
+	b = appendString(b, pbMetricType, m.MType)
+	if m.Value != nil {
+		b = protowire.AppendTag(b, pbMetricValue, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(*m.Value))
+	}
+	if m.Delta != nil {
+		b = protowire.AppendTag(b, pbMetricDelta, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(*m.Delta))
+	}
+	if m.Cumulative {
+		b = protowire.AppendTag(b, pbMetricCumulative, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	return b
+}
+
+func appendMetricList(b []byte, list models.MetricList) []byte {
+	for _, m := range list.Metrics {
+		b = protowire.AppendTag(b, pbListMetrics, protowire.BytesType)
+		b = protowire.AppendBytes(b, appendMetric(nil, m))
+	}
+	if list.Total != 0 {
+		b = protowire.AppendTag(b, pbListTotal, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(list.Total))
+	}
+	return appendString(b, pbListNext, list.Next)
+}
+
+func appendHistory(b []byte, h models.History) []byte {
+	b = appendString(b, pbHistoryID, h.ID)
+	b = appendString(b, pbHistoryType, h.MType)
+	for _, s := range h.Samples {
+		var sample []byte
+		sample = protowire.AppendTag(sample, pbSampleTime, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.Time.UnixNano()))
+		sample = protowire.AppendTag(sample, pbSampleValue, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.Value))
+		b = protowire.AppendTag(b, pbHistorySamples, protowire.BytesType)
+		b = protowire.AppendBytes(b, sample)
+	}
+	return b
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// consumeFields обходит поля сообщения; field возвращает число прочитанных байт значения
+// или -1, если поле ему не знакомо и его нужно пропустить.
+func consumeFields(b []byte, field func(num protowire.Number, typ protowire.Type, b []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("protobuf: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		n, err := field(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return fmt.Errorf("protobuf: field %d: %w", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func consumeMetric(b []byte, m *models.Metrics) error {
+	*m = models.Metrics{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == pbMetricID && typ == protowire.BytesType:
+			s, n := protowire.ConsumeString(b)
+			m.ID = s
+			return n, nil
+		case num == pbMetricType && typ == protowire.BytesType:
+			s, n := protowire.ConsumeString(b)
+			m.MType = s
+			return n, nil
+		case num == pbMetricValue && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			value := math.Float64frombits(v)
+			m.Value = &value
+			return n, nil
+		case num == pbMetricDelta && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			delta := int64(v)
+			m.Delta = &delta
+			return n, nil
+		case num == pbMetricCumulative && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			m.Cumulative = v != 0
+			return n, nil
+		}
+		return -1, nil
+	})
+}
+
+func consumeMetricList(b []byte, list *models.MetricList) error {
+	*list = models.MetricList{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == pbListMetrics && typ == protowire.BytesType:
+			msg, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			var m models.Metrics
+			if err := consumeMetric(msg, &m); err != nil {
+				return 0, err
+			}
+			list.Metrics = append(list.Metrics, m)
+			return n, nil
+		case num == pbListTotal && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			list.Total = int(v)
+			return n, nil
+		case num == pbListNext && typ == protowire.BytesType:
+			s, n := protowire.ConsumeString(b)
+			list.Next = s
+			return n, nil
+		}
+		return -1, nil
+	})
+}
+
+func consumeHistory(b []byte, h *models.History) error {
+	*h = models.History{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == pbHistoryID && typ == protowire.BytesType:
+			s, n := protowire.ConsumeString(b)
+			h.ID = s
+			return n, nil
+		case num == pbHistoryType && typ == protowire.BytesType:
+			s, n := protowire.ConsumeString(b)
+			h.MType = s
+			return n, nil
+		case num == pbHistorySamples && typ == protowire.BytesType:
+			msg, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			var s models.Sample
+			err := consumeFields(msg, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+				switch {
+				case num == pbSampleTime && typ == protowire.VarintType:
+					v, n := protowire.ConsumeVarint(b)
+					s.Time = time.Unix(0, int64(v)).UTC()
+					return n, nil
+				case num == pbSampleValue && typ == protowire.Fixed64Type:
+					v, n := protowire.ConsumeFixed64(b)
+					s.Value = math.Float64frombits(v)
+					return n, nil
+				}
+				return -1, nil
+			})
+			if err != nil {
+				return 0, err
+			}
+			h.Samples = append(h.Samples, s)
+			return n, nil
+		}
+		return -1, nil
+	})
+}
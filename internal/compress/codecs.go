@@ -0,0 +1,94 @@
+package compress
+
+import (
+	"io"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Уровни сжатия подобраны для ответов API, которые сжимаются на каждый запрос: выигрыш
+// от более высоких уровней на JSON метрик невелик, а время растёт в разы.
+const (
+	gzipLevel    = gzip.DefaultCompression
+	deflateLevel = zlib.DefaultCompression
+	brotliLevel  = 5
+	// zstdWindow ограничивает окно zstd: меньше памяти на писатель в пуле, а клиенты
+	// обязаны принимать окна до 8 МиБ (RFC 9659)
+	zstdWindow    = 1 << 20
+	zstdMaxWindow = 8 << 20
+)
+
+// Gzip — gzip (RFC 1952), его понимают все клиенты.
+func Gzip() Codec {
+	return &pooledCodec{
+		name: "gzip",
+		newWriter: func(w io.Writer) (resetWriter, error) {
+			return gzip.NewWriterLevel(w, gzipLevel)
+		},
+		newReader: func(r io.Reader) (resetReader, error) {
+			return gzip.NewReader(r)
+		},
+	}
+}
+
+// Deflate — deflate в обёртке zlib (RFC 1950): именно так HTTP понимает Content-Encoding: deflate.
+func Deflate() Codec {
+	return &pooledCodec{
+		name: "deflate",
+		newWriter: func(w io.Writer) (resetWriter, error) {
+			return zlib.NewWriterLevel(w, deflateLevel)
+		},
+		newReader: func(r io.Reader) (resetReader, error) {
+			zr, err := zlib.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return zlibReader{zr}, nil
+		},
+	}
+}
+
+// zlibReader приводит Reset читателя zlib, который принимает ещё и словарь, к resetReader.
+type zlibReader struct {
+	io.ReadCloser
+}
+
+func (z zlibReader) Reset(r io.Reader) error {
+	return z.ReadCloser.(zlib.Resetter).Reset(r, nil)
+}
+
+// Zstd — Zstandard (RFC 8878): сжимает как gzip, но в несколько раз быстрее.
+func Zstd() Codec {
+	return &pooledCodec{
+		name: "zstd",
+		newWriter: func(w io.Writer) (resetWriter, error) {
+			// с одним потоком кодировщик не запускает горутин и не держит их в пуле
+			return zstd.NewWriter(w,
+				zstd.WithEncoderConcurrency(1),
+				zstd.WithEncoderLevel(zstd.SpeedDefault),
+				zstd.WithWindowSize(zstdWindow))
+		},
+		newReader: func(r io.Reader) (resetReader, error) {
+			return zstd.NewReader(r,
+				zstd.WithDecoderConcurrency(1),
+				zstd.WithDecoderLowmem(true),
+				zstd.WithDecoderMaxWindow(zstdMaxWindow))
+		},
+	}
+}
+
+// Brotli — brotli (RFC 7932), сжимает текст лучше gzip, его поддерживают все браузеры.
+func Brotli() Codec {
+	return &pooledCodec{
+		name: "br",
+		newWriter: func(w io.Writer) (resetWriter, error) {
+			return brotli.NewWriterLevel(w, brotliLevel), nil
+		},
+		newReader: func(r io.Reader) (resetReader, error) {
+			return brotli.NewReader(r), nil
+		},
+	}
+}
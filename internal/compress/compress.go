@@ -1,98 +1,255 @@
+// Package compress сжимает тела HTTP-запросов и ответов. Алгоритмы — gzip, deflate, zstd
+// и brotli — собраны в реестр, из которого сервер выбирает алгоритм по Accept-Encoding,
+// а клиент — по имени. Писатели и читатели переиспользуются через пулы: у zstd и brotli
+// создание кодировщика стоит заметно дороже самого сжатия небольшого ответа.
 package compress
 
 import (
 	"bytes"
-	"compress/gzip"
 	"fmt"
 	"io"
-	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 )
 
-func Compress(data []byte) ([]byte, error) {
+// Codec — алгоритм сжатия. Name совпадает с токеном Content-Encoding.
+type Codec interface {
+	Name() string
+	// NewWriter берёт писатель из пула. Close дописывает поток и возвращает писатель
+	// в пул, w при этом не закрывается. После Close писатель использовать нельзя.
+	NewWriter(w io.Writer) (Writer, error)
+	// NewReader берёт читатель из пула. Close возвращает его в пул, r не закрывается.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// Writer — сжимающий писатель. Flush досылает уже сжатые данные, не завершая поток.
+type Writer interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// Registry — набор алгоритмов в порядке предпочтения сервера: при равных весах
+// в Accept-Encoding выбирается тот, что зарегистрирован раньше.
+type Registry struct {
+	codecs []Codec
+	byName map[string]Codec
+}
+
+func NewRegistry(codecs ...Codec) *Registry {
+	r := &Registry{codecs: codecs, byName: make(map[string]Codec, len(codecs))}
+	for _, c := range codecs {
+		r.byName[c.Name()] = c
+	}
+	// x-gzip — старое имя gzip, получатели обязаны его понимать (RFC 9110, 8.4.1.3)
+	if c, ok := r.byName["gzip"]; ok {
+		r.byName["x-gzip"] = c
+	}
+	return r
+}
+
+var defaultRegistry = NewRegistry(Zstd(), Brotli(), Gzip(), Deflate())
+
+// Default — реестр со всеми алгоритмами: zstd, br, gzip, deflate. Реестр общий
+// для всего процесса, чтобы пулы писателей и читателей переиспользовались.
+func Default() *Registry {
+	return defaultRegistry
+}
+
+// Get находит алгоритм по имени из Content-Encoding без учёта регистра.
+func (r *Registry) Get(name string) (Codec, bool) {
+	c, ok := r.byName[strings.ToLower(strings.TrimSpace(name))]
+	return c, ok
+}
+
+// Names перечисляет имена алгоритмов в порядке предпочтения.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.codecs))
+	for _, c := range r.codecs {
+		names = append(names, c.Name())
+	}
+	return names
+}
+
+// Negotiate выбирает алгоритм по заголовку Accept-Encoding (RFC 9110, 12.5.3): побеждает
+// наибольший вес q, при равенстве — порядок реестра. Маска * задаёт вес всех не названных
+// алгоритмов. ok — false, если сжимать не нужно: заголовка нет, ни один алгоритм не
+// принимается или identity названа с весом больше, чем у лучшего алгоритма.
+func (r *Registry) Negotiate(acceptEncoding string) (Codec, bool) {
+	weights := parseAcceptEncoding(acceptEncoding)
+	if len(weights) == 0 {
+		return nil, false
+	}
+	weight := func(name string) (float64, bool) {
+		if q, ok := weights[name]; ok {
+			return q, true
+		}
+		q, ok := weights["*"]
+		return q, ok
+	}
+	var best Codec
+	bestQ := 0.0
+	for _, c := range r.codecs {
+		q, _ := weight(c.Name())
+		if c.Name() == "gzip" {
+			if xq, ok := weights["x-gzip"]; ok {
+				q = max(q, xq)
+			}
+		}
+		if q > bestQ {
+			best, bestQ = c, q
+		}
+	}
+	if best == nil {
+		return nil, false
+	}
+	if q, ok := weight("identity"); ok && q > bestQ {
+		return nil, false
+	}
+	return best, true
+}
+
+// parseAcceptEncoding разбирает Accept-Encoding в веса по именам. Элементы с неверным
+// весом пропускаются.
+func parseAcceptEncoding(header string) map[string]float64 {
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if params != "" {
+			key, value, _ := strings.Cut(strings.TrimSpace(params), "=")
+			if strings.EqualFold(strings.TrimSpace(key), "q") {
+				v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil || v < 0 || v > 1 {
+					continue
+				}
+				q = v
+			}
+		}
+		weights[name] = q
+	}
+	return weights
+}
+
+// Encode сжимает данные целиком.
+func Encode(c Codec, data []byte) ([]byte, error) {
 	var b bytes.Buffer
-	// создаём переменную w — в неё будут записываться входящие данные,
-	// которые будут сжиматься и сохраняться в bytes.Buffer
-	w, err := gzip.NewWriterLevel(&b, gzip.BestSpeed)
+	w, err := c.NewWriter(&b)
 	if err != nil {
 		return nil, fmt.Errorf("failed init compress writer: %w", err)
 	}
-	// запись данных
-	_, err = w.Write(data)
-	if err != nil {
+	if _, err := w.Write(data); err != nil {
+		w.Close()
 		return nil, fmt.Errorf("failed write data to compress temporary buffer: %w", err)
 	}
-	// обязательно нужно вызвать метод Close() — в противном случае часть данных
-	// может не записаться в буфер b; если нужно выгрузить все упакованные данные
-	// в какой-то момент сжатия, используйте метод Flush()
-	err = w.Close()
-	if err != nil {
+	// без Close последний блок останется в писателе
+	if err := w.Close(); err != nil {
 		return nil, fmt.Errorf("failed compress data: %w", err)
 	}
-	// переменная b содержит сжатые данные
 	return b.Bytes(), nil
 }
 
-// compressWriter реализует интерфейс http.ResponseWriter и позволяет прозрачно для сервера
-// сжимать передаваемые данные и выставлять правильные HTTP-заголовки.
-type compressWriter struct {
-	w  http.ResponseWriter
-	zw *gzip.Writer
-}
-
-func NewCompressWriter(w http.ResponseWriter) *compressWriter {
-	return &compressWriter{
-		w:  w,
-		zw: gzip.NewWriter(w),
+// Decode распаковывает данные целиком.
+func Decode(c Codec, data []byte) ([]byte, error) {
+	r, err := c.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
 	}
+	defer r.Close()
+	return io.ReadAll(r)
 }
 
-func (c *compressWriter) Header() http.Header {
-	return c.w.Header()
+// Compress сжимает данные gzip.
+func Compress(data []byte) ([]byte, error) {
+	c, _ := defaultRegistry.Get("gzip")
+	return Encode(c, data)
 }
 
-func (c *compressWriter) Write(p []byte) (int, error) {
-	return c.zw.Write(p)
+// resetWriter — сжимающий писатель, который можно направить в новый поток.
+type resetWriter interface {
+	Writer
+	Reset(w io.Writer)
 }
 
-func (c *compressWriter) WriteHeader(statusCode int) {
-	if statusCode < 300 {
-		c.w.Header().Set("Content-Encoding", "gzip")
-		c.w.Header().Del("Content-Length")
-	}
-	c.w.WriteHeader(statusCode)
+// resetReader — распаковывающий читатель, который можно направить на новый поток.
+type resetReader interface {
+	io.Reader
+	Reset(r io.Reader) error
 }
 
-// Close закрывает gzip.Writer и досылает все данные из буфера.
-func (c *compressWriter) Close() error {
-	return c.zw.Close()
+// pooledCodec — алгоритм, у которого писатели и читатели живут в пулах.
+type pooledCodec struct {
+	name      string
+	newWriter func(w io.Writer) (resetWriter, error)
+	newReader func(r io.Reader) (resetReader, error)
+	writers   sync.Pool
+	readers   sync.Pool
 }
 
-// compressReader реализует интерфейс io.ReadCloser и позволяет прозрачно для сервера
-// декомпрессировать получаемые от клиента данные.
-type compressReader struct {
-	r  io.ReadCloser
-	zr *gzip.Reader
+func (c *pooledCodec) Name() string { return c.name }
+
+func (c *pooledCodec) NewWriter(w io.Writer) (Writer, error) {
+	if pw, ok := c.writers.Get().(*pooledWriter); ok {
+		pw.resetWriter.Reset(w)
+		pw.closed = false
+		return pw, nil
+	}
+	zw, err := c.newWriter(w)
+	if err != nil {
+		return nil, err
+	}
+	return &pooledWriter{resetWriter: zw, codec: c}, nil
 }
 
-func NewCompressReader(r io.ReadCloser) (*compressReader, error) {
-	zr, err := gzip.NewReader(r)
+func (c *pooledCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	if pr, ok := c.readers.Get().(*pooledReader); ok {
+		if err := pr.resetReader.Reset(r); err != nil {
+			c.readers.Put(pr)
+			return nil, err
+		}
+		pr.closed = false
+		return pr, nil
+	}
+	zr, err := c.newReader(r)
 	if err != nil {
 		return nil, err
 	}
+	return &pooledReader{resetReader: zr, codec: c}, nil
+}
 
-	return &compressReader{
-		r:  r,
-		zr: zr,
-	}, nil
+type pooledWriter struct {
+	resetWriter
+	codec  *pooledCodec
+	closed bool
+}
+
+// Close завершает поток и возвращает писатель в пул. Повторный Close ничего не делает,
+// иначе один писатель попал бы в пул дважды.
+func (w *pooledWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	err := w.resetWriter.Close()
+	w.codec.writers.Put(w)
+	return err
 }
 
-func (c compressReader) Read(p []byte) (n int, err error) {
-	return c.zr.Read(p)
+type pooledReader struct {
+	resetReader
+	codec  *pooledCodec
+	closed bool
 }
 
-func (c *compressReader) Close() error {
-	if err := c.r.Close(); err != nil {
-		return err
+func (r *pooledReader) Close() error {
+	if r.closed {
+		return nil
 	}
-	return c.zr.Close()
+	r.closed = true
+	r.codec.readers.Put(r)
+	return nil
 }
@@ -0,0 +1,245 @@
+package compress
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"monalert/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	data := []byte(strings.Repeat(`{"id":"HeapAlloc","type":"gauge","value":123456}`, 100))
+	for _, name := range Default().Names() {
+		t.Run(name, func(t *testing.T) {
+			c, ok := Default().Get(name)
+			require.True(t, ok)
+			// второй проход берёт писатель и читатель из пула
+			for range 2 {
+				compressed, err := Encode(c, data)
+				require.NoError(t, err)
+				assert.Less(t, len(compressed), len(data)/4)
+				got, err := Decode(c, compressed)
+				require.NoError(t, err)
+				assert.Equal(t, data, got)
+			}
+		})
+	}
+}
+
+func TestDoubleClose(t *testing.T) {
+	c := Gzip()
+	w, err := c.NewWriter(io.Discard)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, w.Close())
+	// писатель попал в пул один раз: два следующих писателя — разные объекты
+	w1, err := c.NewWriter(io.Discard)
+	require.NoError(t, err)
+	w2, err := c.NewWriter(io.Discard)
+	require.NoError(t, err)
+	assert.NotSame(t, w1, w2)
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{acceptEncoding: "", want: ""},
+		{acceptEncoding: "gzip", want: "gzip"},
+		{acceptEncoding: "x-gzip", want: "gzip"},
+		{acceptEncoding: "gzip, deflate, br, zstd", want: "zstd"},
+		{acceptEncoding: "gzip, deflate, br", want: "br"},
+		{acceptEncoding: "br;q=0.5, gzip;q=0.8", want: "gzip"},
+		{acceptEncoding: "GZIP;Q=0.5", want: "gzip"},
+		{acceptEncoding: "*", want: "zstd"},
+		{acceptEncoding: "*, zstd;q=0", want: "br"},
+		{acceptEncoding: "gzip;q=0", want: ""},
+		{acceptEncoding: "identity", want: ""},
+		{acceptEncoding: "gzip;q=0.5, identity", want: ""},
+		{acceptEncoding: "gzip, identity;q=0.5", want: "gzip"},
+		{acceptEncoding: "gzip;q=2, deflate", want: "deflate"},
+		{acceptEncoding: "compress", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			c, ok := Default().Negotiate(tt.acceptEncoding)
+			if tt.want == "" {
+				assert.False(t, ok)
+				return
+			}
+			require.True(t, ok)
+			assert.Equal(t, tt.want, c.Name())
+		})
+	}
+}
+
+func TestResponseWriter(t *testing.T) {
+	c := Zstd()
+	serve := func(handler http.HandlerFunc) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		cw := NewResponseWriter(rec, c, 64)
+		handler(cw, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.NoError(t, cw.Close())
+		return rec
+	}
+	large := strings.Repeat("metric ", 100)
+
+	t.Run("below threshold", func(t *testing.T) {
+		rec := serve(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, "short")
+		})
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Empty(t, rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, "short", rec.Body.String())
+	})
+
+	t.Run("above threshold", func(t *testing.T) {
+		rec := serve(func(w http.ResponseWriter, r *http.Request) {
+			// запись по частям: порог считается по всему телу
+			for _, part := range strings.SplitAfter(large, " ") {
+				io.WriteString(w, part)
+			}
+		})
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "zstd", rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+		body, err := Decode(c, rec.Body.Bytes())
+		require.NoError(t, err)
+		assert.Equal(t, large, string(body))
+	})
+
+	t.Run("not modified", func(t *testing.T) {
+		rec := serve(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotModified)
+		})
+		assert.Equal(t, http.StatusNotModified, rec.Code)
+		assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	})
+
+	t.Run("already encoded", func(t *testing.T) {
+		rec := serve(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "br")
+			io.WriteString(w, large)
+		})
+		assert.Equal(t, "br", rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, large, rec.Body.String())
+	})
+
+	t.Run("flush", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		cw := NewResponseWriter(rec, c, 64)
+		io.WriteString(cw, "data: 1\n\n")
+		cw.Flush()
+		assert.True(t, rec.Flushed)
+		assert.Equal(t, "zstd", rec.Header().Get("Content-Encoding"))
+		require.NoError(t, cw.Close())
+		body, err := Decode(c, rec.Body.Bytes())
+		require.NoError(t, err)
+		assert.Equal(t, "data: 1\n\n", string(body))
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		rec := serve(func(w http.ResponseWriter, r *http.Request) {})
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Header().Get("Content-Encoding"))
+		assert.Zero(t, rec.Body.Len())
+	})
+}
+
+// agentBatch — батч, который агент отправляет за один отчёт: gauge из runtime.MemStats
+// со значениями реального порядка, RandomValue и PollCount. С hosts > 1 имена получают
+// префикс хоста, как у батча, собранного с нескольких целей scrape.
+func agentBatch(hosts int) []byte {
+	names := []string{"Alloc", "BuckHashSys", "Frees", "GCCPUFraction", "GCSys", "HeapAlloc", "HeapIdle",
+		"HeapInuse", "HeapObjects", "HeapReleased", "HeapSys", "LastGC", "Lookups", "MCacheInuse", "MCacheSys",
+		"MSpanInuse", "MSpanSys", "Mallocs", "NextGC", "NumForcedGC", "NumGC", "OtherSys", "PauseTotalNs",
+		"StackInuse", "StackSys", "Sys", "TotalAlloc", "RandomValue"}
+	rnd := rand.New(rand.NewSource(1))
+	var batch []models.Metrics
+	for h := range hosts {
+		prefix := ""
+		if hosts > 1 {
+			prefix = fmt.Sprintf("host%03d.", h)
+		}
+		for _, name := range names {
+			value := float64(rnd.Int63n(1 << 30))
+			if name == "GCCPUFraction" || name == "RandomValue" {
+				value = rnd.Float64()
+			}
+			batch = append(batch, models.Metrics{ID: prefix + name, MType: "gauge", Value: &value})
+		}
+		delta := int64(1)
+		batch = append(batch, models.Metrics{ID: prefix + "PollCount", MType: "counter", Delta: &delta})
+	}
+	data, err := json.Marshal(batch)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// BenchmarkEncode сравнивает алгоритмы на батче одного агента и на батче со ста хостов.
+// Метрика ratio — доля сжатого размера от исходного.
+func BenchmarkEncode(b *testing.B) {
+	for _, hosts := range []int{1, 100} {
+		data := agentBatch(hosts)
+		for _, name := range Default().Names() {
+			c, _ := Default().Get(name)
+			b.Run(fmt.Sprintf("%s/hosts=%d", name, hosts), func(b *testing.B) {
+				var buf bytes.Buffer
+				b.SetBytes(int64(len(data)))
+				b.ReportAllocs()
+				for range b.N {
+					buf.Reset()
+					w, err := c.NewWriter(&buf)
+					if err != nil {
+						b.Fatal(err)
+					}
+					w.Write(data)
+					if err := w.Close(); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(buf.Len())/float64(len(data)), "ratio")
+			})
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	for _, hosts := range []int{1, 100} {
+		data := agentBatch(hosts)
+		for _, name := range Default().Names() {
+			c, _ := Default().Get(name)
+			compressed, err := Encode(c, data)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Run(fmt.Sprintf("%s/hosts=%d", name, hosts), func(b *testing.B) {
+				b.SetBytes(int64(len(data)))
+				b.ReportAllocs()
+				for range b.N {
+					r, err := c.NewReader(bytes.NewReader(compressed))
+					if err != nil {
+						b.Fatal(err)
+					}
+					if _, err := io.Copy(io.Discard, r); err != nil {
+						b.Fatal(err)
+					}
+					r.Close()
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package compress
+
+import (
+	"net/http"
+)
+
+// ResponseWriter сжимает ответ алгоритмом codec. Ответ короче minSize байт уходит
+// несжатым: на маленьком теле заголовок и служебные кадры сжатого потока съедают
+// весь выигрыш. Пока решение не принято, тело копится в буфере, поэтому код ответа
+// и заголовки уходят клиенту при первой записи сверх порога, при Flush или при Close.
+// Ответы без тела (204, 304, 1xx) не получают Content-Encoding.
+type ResponseWriter struct {
+	w       http.ResponseWriter
+	codec   Codec
+	minSize int
+	status  int
+	buf     []byte
+	// started — код ответа отправлен, дальше данные идут в zw или напрямую в w
+	started bool
+	zw      Writer // nil, если ответ не сжимается
+}
+
+func NewResponseWriter(w http.ResponseWriter, codec Codec, minSize int) *ResponseWriter {
+	return &ResponseWriter{w: w, codec: codec, minSize: minSize}
+}
+
+func (c *ResponseWriter) Header() http.Header {
+	return c.w.Header()
+}
+
+func (c *ResponseWriter) WriteHeader(statusCode int) {
+	if c.started || c.status != 0 {
+		return
+	}
+	// промежуточные ответы вроде 103 Early Hints уходят сразу, их может быть несколько
+	if statusCode >= 100 && statusCode < 200 && statusCode != http.StatusSwitchingProtocols {
+		c.w.WriteHeader(statusCode)
+		return
+	}
+	c.status = statusCode
+	if !bodyAllowed(statusCode) {
+		c.start(false)
+	}
+}
+
+func (c *ResponseWriter) Write(p []byte) (int, error) {
+	if !c.started {
+		if len(c.buf)+len(p) < c.minSize {
+			c.buf = append(c.buf, p...)
+			return len(p), nil
+		}
+		if err := c.start(true); err != nil {
+			return 0, err
+		}
+	}
+	if c.zw != nil {
+		return c.zw.Write(p)
+	}
+	return c.w.Write(p)
+}
+
+// Flush досылает клиенту уже записанные данные. Потоковый ответ сжимается, даже если
+// к первому Flush он короче порога: его итоговый размер заранее неизвестен.
+func (c *ResponseWriter) Flush() {
+	if !c.started {
+		if err := c.start(true); err != nil {
+			return
+		}
+	}
+	if c.zw != nil {
+		if err := c.zw.Flush(); err != nil {
+			return
+		}
+	}
+	_ = http.NewResponseController(c.w).Flush()
+}
+
+func (c *ResponseWriter) Unwrap() http.ResponseWriter {
+	return c.w
+}
+
+// Close отправляет ответ, оставшийся короче порога, или завершает сжатый поток
+// и возвращает писатель в пул.
+func (c *ResponseWriter) Close() error {
+	if !c.started {
+		// обработчик ничего не написал: код ответа по умолчанию выставит сервер
+		if c.status == 0 && len(c.buf) == 0 {
+			return nil
+		}
+		if err := c.start(false); err != nil {
+			return err
+		}
+	}
+	if c.zw != nil {
+		return c.zw.Close()
+	}
+	return nil
+}
+
+// start отправляет код ответа и накопленный буфер, включив сжатие, если compress
+// и ответ может его получить.
+func (c *ResponseWriter) start(compress bool) error {
+	c.started = true
+	status := c.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	header := c.w.Header()
+	// тип определяется по несжатым данным, иначе сервер принял бы сжатое тело за двоичное
+	if len(c.buf) > 0 && header.Get("Content-Type") == "" {
+		header.Set("Content-Type", http.DetectContentType(c.buf))
+	}
+	// тело, которое обработчик сжал сам, второй раз не сжимается
+	if compress && bodyAllowed(status) && header.Get("Content-Encoding") == "" {
+		zw, err := c.codec.NewWriter(c.w)
+		if err == nil {
+			c.zw = zw
+			header.Set("Content-Encoding", c.codec.Name())
+			header.Del("Content-Length")
+		}
+	}
+	c.w.WriteHeader(status)
+	if len(c.buf) == 0 {
+		return nil
+	}
+	buf := c.buf
+	c.buf = nil
+	var err error
+	if c.zw != nil {
+		_, err = c.zw.Write(buf)
+	} else {
+		_, err = c.w.Write(buf)
+	}
+	return err
+}
+
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
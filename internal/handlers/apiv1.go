@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"monalert/internal/logger"
+	"monalert/internal/models"
+	"monalert/internal/repository"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// Маршруты /api/v1 построены вокруг ресурса «метрика»: /metrics — коллекция,
+// /metrics/{type}/{name} — отдельная серия. Старые маршруты /update/ и /value/
+// остаются для совместимости и работают через тот же сервис.
+
+const (
+	apiV1Prefix       = "/api/v1"
+	apiV1DefaultLimit = 100
+	apiV1MaxLimit     = 1000
+)
+
+// handleListMetrics отдаёт страницу метрик: GET /api/v1/metrics?type=gauge&prefix=Heap&sort=-id&limit=50.
+// Следующая страница запрашивается по ссылке из поля next. Она же и общее число метрик
+// продублированы в заголовках Link и X-Total-Count для форматов, где этих полей нет, — CSV.
+func (h *handlers) handleListMetrics(w http.ResponseWriter, r *http.Request) {
+	if rejectSelector(w, r) {
+		return
+	}
+	opts, err := listOptions(r.URL.Query(), apiV1DefaultLimit, apiV1MaxLimit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	page, err := h.monalert.ListMetrics(r.Context(), opts)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	resp := models.MetricList{Metrics: page.Metrics, Total: page.Total}
+	if page.Next != "" {
+		resp.Next = apiV1Prefix + "/metrics?" + nextPageQuery(r, page.Next)
+		w.Header().Set("Link", "<"+resp.Next+`>; rel="next"`)
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	writeWithETag(w, r, h.responseCodec(r), resp)
+}
+
+func (h *handlers) handleGetMetricV1(w http.ResponseWriter, r *http.Request) {
+	m, err := h.monalert.GetMetric(r.Context(), &models.Metrics{
+		MType: chi.URLParam(r, "metricType"),
+		ID:    chi.URLParam(r, "metricName"),
+	})
+	if err != nil {
+		writeLookupError(w, err)
+		return
+	}
+	writeWithETag(w, r, h.responseCodec(r), m)
+}
+
+// handlePutMetric записывает значение серии, адрес которой задан путём. Тело — метрика
+// без id и type либо с теми же id и type, что в пути. Новая серия — 201 с Location,
+// обновление существующей — 200. Значение счётчика заменяет сохранённое, поэтому повтор
+// того же PUT ничего не меняет; приращения и накопленные значения передаются через POST.
+func (h *handlers) handlePutMetric(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		writeUnsupportedMediaType(w)
+		return
+	}
+	var req models.Metrics
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	mType, id := chi.URLParam(r, "metricType"), chi.URLParam(r, "metricName")
+	if req.ID != "" && req.ID != id {
+		writeErrorResponse(w, http.StatusBadRequest, codeInvalidRequest, "id in the body does not match the path", "id")
+		return
+	}
+	if req.MType != "" && req.MType != mType {
+		writeErrorResponse(w, http.StatusBadRequest, codeInvalidRequest, "type in the body does not match the path", "type")
+		return
+	}
+	if req.Cumulative {
+		writeErrorResponse(w, http.StatusBadRequest, codeInvalidRequest, "cumulative is not supported by PUT: the value replaces the counter", "cumulative")
+		return
+	}
+	// от проверки существования зависит только код ответа, поэтому гонка с параллельной
+	// записью той же серии допустима: в худшем случае оба клиента получат 201
+	_, err := h.monalert.GetMetric(r.Context(), &models.Metrics{MType: mType, ID: id})
+	created := errors.Is(err, repository.ErrNotFound)
+	updated, err := h.monalert.MetricUpdate(r.Context(), &models.Metrics{
+		MType:   mType,
+		ID:      id,
+		Value:   req.Value,
+		Delta:   req.Delta,
+		Replace: true,
+		Source:  clientKey(r),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if created {
+		w.Header().Set("Location", apiV1MetricPath(mType, id))
+		w.WriteHeader(http.StatusCreated)
+	}
+	if err := json.NewEncoder(w).Encode(updated); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+	}
+}
+
+// handleBatchV1 — POST /api/v1/metrics:batch. Батч проверяется и записывается так же,
+// как в /updates/, но тело ответа не нужно: успех — 204.
+func (h *handlers) handleBatchV1(w http.ResponseWriter, r *http.Request) {
+	in, ok := h.requestCodec(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := h.applyBatch(w, r, in); ok {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// apiV1MetricPath — адрес серии в /api/v1 для заголовка Location.
+func apiV1MetricPath(mType, id string) string {
+	return fmt.Sprintf("%s/metrics/%s/%s", apiV1Prefix, url.PathEscape(mType), url.PathEscape(id))
+}
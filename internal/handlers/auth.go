@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"monalert/internal/auth"
+	"net/http"
+)
+
+// Authenticator определяет, какой токен предъявлен в запросе.
+type Authenticator interface {
+	Authenticate(r *http.Request) (auth.Token, error)
+}
+
+type requestInfoKey struct{}
+
+// requestInfo заполняется мидлварями по ходу запроса и пишется в лог в MyLogger.
+type requestInfo struct {
+	tokenName string
+}
+
+func withRequestInfo(ctx context.Context, info *requestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// authenticate проверяет токен запроса. Без настроенных токенов пропускает всех.
+// Если токена нет или он неверный, отвечает 401 и возвращает false.
+func (h *handlers) authenticate(w http.ResponseWriter, r *http.Request) (auth.Token, bool) {
+	if h.auth == nil {
+		return auth.Token{Scopes: []string{auth.ScopeAdmin}}, true
+	}
+	token, err := h.auth.Authenticate(r)
+	if err != nil {
+		challenge := `Bearer realm="monalert"`
+		if errors.Is(err, auth.ErrInvalidToken) {
+			challenge += `, error="invalid_token"`
+		}
+		w.Header().Set("WWW-Authenticate", challenge)
+		writeErrorResponse(w, http.StatusUnauthorized, codeUnauthorized, "missing or invalid API token", "")
+		return auth.Token{}, false
+	}
+	if info := requestInfoFrom(r.Context()); info != nil {
+		info.tokenName = token.Name
+	}
+	return token, true
+}
+
+// requireScope пропускает только запросы с токеном, у которого есть scope.
+func (h *handlers) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := h.authenticate(w, r)
+			if !ok {
+				return
+			}
+			if !token.HasScope(scope) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="monalert", error="insufficient_scope", scope="`+scope+`"`)
+				writeErrorResponse(w, http.StatusForbidden, codeForbidden, "token has no "+scope+" scope", "")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
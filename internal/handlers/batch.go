@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"fmt"
+	"monalert/internal/codec"
+	"monalert/internal/models"
+	"net/http"
+)
+
+// handleBatchUpdate принимает массив метрик и отвечает их значениями после обновления.
+// Ответ приходит в формате запроса, если в Accept явно не назван другой.
+func (h *handlers) handleBatchUpdate(w http.ResponseWriter, r *http.Request) {
+	in, ok := h.requestCodec(w, r)
+	if !ok {
+		return
+	}
+	resp, ok := h.applyBatch(w, r, in)
+	if !ok {
+		return
+	}
+	out := in
+	if c, ok := h.explicitCodec(r); ok {
+		out = c
+	}
+	writeEncoded(w, out, resp)
+}
+
+// applyBatch разбирает батч из тела запроса в формате in и записывает его. Батч записывается
+// целиком или не записывается вовсе: если хоть одна метрика некорректна или не помещается
+// в лимит серий, ничего не записывается, а поле ошибки указывает на метрику, например [3].value.
+// При ошибке ответ клиенту уже отправлен и возвращается false.
+func (h *handlers) applyBatch(w http.ResponseWriter, r *http.Request, in codec.Codec) ([]models.Metrics, bool) {
+	var batch []models.Metrics
+	if err := in.Decode(r.Body, &batch); err != nil {
+		writeDecodeError(w, err)
+		return nil, false
+	}
+	if len(batch) > h.limits.MaxBatch {
+		writeErrorResponse(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge,
+			fmt.Sprintf("batch is too large, max %d metrics", h.limits.MaxBatch), "")
+		return nil, false
+	}
+	source := clientKey(r)
+	for i := range batch {
+		batch[i].Source = source
+	}
+	resp, err := h.monalert.MetricUpdates(r.Context(), batch)
+	if err != nil {
+		writeError(w, err)
+		return nil, false
+	}
+	return resp, true
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"encoding/json"
+	"monalert/internal/logger"
+	"net/http"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+// handleCardinality показывает, какие префиксы имён и агенты создают больше всего серий.
+// Параметры: depth — сколько сегментов имени через '.' считать префиксом (по умолчанию 1),
+// top — сколько групп вернуть (по умолчанию 20).
+func (h *handlers) handleCardinality(w http.ResponseWriter, r *http.Request) {
+	depth, err := intQuery(r, "depth", 1)
+	if err != nil || depth < 1 {
+		writeErrorResponse(w, http.StatusBadRequest, codeInvalidRequest, "depth must be a positive integer", "depth")
+		return
+	}
+	top, err := intQuery(r, "top", 20)
+	if err != nil || top < 1 {
+		writeErrorResponse(w, http.StatusBadRequest, codeInvalidRequest, "top must be a positive integer", "top")
+		return
+	}
+	report := h.monalert.Cardinality(depth, top)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logger.Log.Error("error encoding cardinality report", zap.Error(err))
+	}
+}
+
+func intQuery(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"embed"
+	"html/template"
+	"monalert/internal/logger"
+	"monalert/internal/models"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+//go:embed templates/*.html
+var templatesFS embed.FS
+
+var dashboardTemplates = template.Must(template.ParseFS(templatesFS, "templates/*.html"))
+
+const (
+	dashboardRefresh = 5 * time.Second
+	sparklineWidth   = 600
+	sparklineHeight  = 120
+)
+
+type dashboardRow struct {
+	ID      string
+	Value   string
+	Raw     string
+	Link    string
+	Help    string
+	Unit    string
+	Display string
+}
+
+type dashboardGroup struct {
+	Type    string
+	Metrics []dashboardRow
+}
+
+type dashboardPage struct {
+	Title         string
+	Updated       string
+	Groups        []dashboardGroup
+	RefreshMillis int64
+}
+
+type metricPage struct {
+	Title          string
+	ID             string
+	Type           string
+	Help           string
+	Value          string
+	Points         string
+	Count          int
+	Min            string
+	Max            string
+	Width          int
+	Height         int
+	RefreshSeconds int
+}
+
+func metricLink(mType, id string) string {
+	return "/metric/" + url.PathEscape(mType) + "/" + url.PathEscape(id)
+}
+
+// formatMetricValue форматирует значение метрики без учёта метаданных.
+func formatMetricValue(m models.Metrics) string {
+	switch {
+	case m.Value != nil:
+		return strconv.FormatFloat(*m.Value, 'f', -1, 64)
+	case m.Delta != nil:
+		return strconv.FormatInt(*m.Delta, 10)
+	default:
+		return ""
+	}
+}
+
+func (h *handlers) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	metrics, err := h.monalert.GetAllMetrics(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	metadata, err := h.monalert.AllMetadata(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	byType := make(map[string][]dashboardRow)
+	for _, m := range metrics {
+		raw := formatMetricValue(m)
+		md := metadata[m.ID]
+		value := raw
+		if md.Unit != "" || md.Display != "" {
+			v, _ := strconv.ParseFloat(raw, 64)
+			value = formatWithMetadata(v, md)
+		}
+		byType[m.MType] = append(byType[m.MType], dashboardRow{
+			ID:      m.ID,
+			Value:   value,
+			Raw:     raw,
+			Link:    metricLink(m.MType, m.ID),
+			Help:    md.Help,
+			Unit:    md.Unit,
+			Display: md.Display,
+		})
+	}
+	page := dashboardPage{
+		Title:         "Метрики",
+		Updated:       time.Now().Format(time.TimeOnly),
+		RefreshMillis: dashboardRefresh.Milliseconds(),
+	}
+	for _, mType := range []string{"gauge", "counter"} {
+		rows := byType[mType]
+		sort.Slice(rows, func(i, j int) bool { return rows[i].ID < rows[j].ID })
+		page.Groups = append(page.Groups, dashboardGroup{Type: mType, Metrics: rows})
+	}
+	h.renderTemplate(w, "index", page)
+}
+
+func (h *handlers) handleMetricPage(w http.ResponseWriter, r *http.Request) {
+	req := &models.Metrics{
+		MType: chi.URLParam(r, "metricType"),
+		ID:    chi.URLParam(r, "metricName"),
+	}
+	samples, err := h.monalert.GetHistory(r.Context(), req)
+	if err != nil {
+		writeLookupError(w, err)
+		return
+	}
+	if c, ok := h.explicitCodec(r); ok {
+		writeEncoded(w, c, models.History{ID: req.ID, MType: req.MType, Samples: samples})
+		return
+	}
+
+	var md models.Metadata
+	if found, err := h.monalert.GetMetadata(r.Context(), req.ID); err == nil {
+		md = *found
+	}
+	page := metricPage{
+		Title:          req.ID,
+		ID:             req.ID,
+		Type:           req.MType,
+		Help:           md.Help,
+		Count:          len(samples),
+		Width:          sparklineWidth,
+		Height:         sparklineHeight,
+		RefreshSeconds: int(dashboardRefresh.Seconds()),
+	}
+	if len(samples) > 0 {
+		minValue, maxValue := samples[0].Value, samples[0].Value
+		for _, s := range samples {
+			minValue = min(minValue, s.Value)
+			maxValue = max(maxValue, s.Value)
+		}
+		page.Value = formatWithMetadata(samples[len(samples)-1].Value, md)
+		page.Min = formatWithMetadata(minValue, md)
+		page.Max = formatWithMetadata(maxValue, md)
+		page.Points = sparkline(samples, minValue, maxValue, sparklineWidth, sparklineHeight)
+	}
+	h.renderTemplate(w, "metric", page)
+}
+
+// sparkline строит координаты для SVG polyline по истории значений.
+func sparkline(samples []models.Sample, minValue, maxValue float64, width, height int) string {
+	var b strings.Builder
+	step := 0.0
+	if len(samples) > 1 {
+		step = float64(width) / float64(len(samples)-1)
+	}
+	for i, s := range samples {
+		y := float64(height) / 2
+		if maxValue > minValue {
+			// оставляем по пикселю сверху и снизу, чтобы линия не обрезалась рамкой
+			y = 1 + (maxValue-s.Value)/(maxValue-minValue)*float64(height-2)
+		}
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(strconv.FormatFloat(float64(i)*step, 'f', 1, 64))
+		b.WriteByte(',')
+		b.WriteString(strconv.FormatFloat(y, 'f', 1, 64))
+	}
+	return b.String()
+}
+
+func (h *handlers) renderTemplate(w http.ResponseWriter, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplates.ExecuteTemplate(w, name, data); err != nil {
+		logger.Log.Error("cannot render template", zap.String("template", name), zap.Error(err))
+	}
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"encoding/json"
+	"monalert/internal/logger"
+	"monalert/internal/models"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+func (h *handlers) handleDeleteMetric(w http.ResponseWriter, r *http.Request) {
+	err := h.monalert.DeleteMetric(r.Context(), &models.Metrics{
+		MType: chi.URLParam(r, "metricType"),
+		ID:    chi.URLParam(r, "metricName"),
+	})
+	if err != nil {
+		writeLookupError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleDeleteMetrics удаляет серии по префиксу имени: DELETE /value/?prefix=node.&type=gauge.
+// Пустой префикс не принимается, чтобы случайно не стереть всё хранилище.
+func (h *handlers) handleDeleteMetrics(w http.ResponseWriter, r *http.Request) {
+	if rejectSelector(w, r) {
+		return
+	}
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	if prefix == "" {
+		writeErrorResponse(w, http.StatusBadRequest, codeInvalidRequest, "prefix is required", "prefix")
+		return
+	}
+	deleted, err := h.monalert.DeleteByPrefix(r.Context(), query.Get("type"), prefix)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		Deleted int `json:"deleted"`
+	}{Deleted: deleted}); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+	}
+}
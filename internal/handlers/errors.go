@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"monalert/internal/logger"
+	"monalert/internal/repository"
+	"monalert/internal/service"
+	"net/http"
+	"regexp"
+
+	"go.uber.org/zap"
+)
+
+// Коды ошибок в теле ответа. В отличие от текста сообщения, они не меняются,
+// и клиенты могут на них полагаться.
+const (
+	codeInvalidRequest       = "invalid_request"
+	codeInvalidValue         = "invalid_value"
+	codeUnsupportedType      = "unsupported_type"
+	codeNotFound             = "not_found"
+	codeReservedName         = "reserved_name"
+	codeLabelsUnsupported    = "labels_unsupported"
+	codeCardinalityLimit     = "cardinality_limit"
+	codeRateLimited          = "rate_limited"
+	codePayloadTooLarge      = "payload_too_large"
+	codeUnsupportedMediaType = "unsupported_media_type"
+	codeUnauthorized         = "unauthorized"
+	codeForbidden            = "forbidden"
+	codeInvalidSignature     = "invalid_signature"
+	codeTimeout              = "timeout"
+	codeInternal             = "internal"
+)
+
+// errLabelFilter — сообщение с кодом codeLabelsUnsupported на фильтр или селектор по меткам.
+// У метрик нет меток: агент сворачивает их в имя сегментами .key_value, поэтому серии
+// выбираются по префиксу имени.
+const errLabelFilter = "label filters are not supported: metrics have no labels, filter by name prefix instead"
+
+// errorResponse — тело любого ответа с ошибкой. Field — поле запроса, к которому относится ошибка.
+type errorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+}
+
+// writeErrorResponse отвечает ошибкой в JSON.
+func writeErrorResponse(w http.ResponseWriter, status int, code, message, field string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(errorResponse{Code: code, Message: message, Field: field}); err != nil {
+		logger.Log.Debug("error encoding error response", zap.Error(err))
+	}
+}
+
+// writeError отвечает на ошибку сервиса. Здесь собрано соответствие ошибок кодам ответа:
+//
+//	ErrNotFound                 — 404 not_found
+//	ErrUnsupportedType          — 400 unsupported_type
+//	ErrInvalidValue             — 400 invalid_value
+//	ErrReservedName             — 400 reserved_name
+//	ErrCardinalityLimit         — 422 cardinality_limit, не 429: повтор не поможет, пока серии не удалят
+//	тело больше лимита          — 413 payload_too_large
+//	таймаут запроса             — 503 timeout
+//	остальное                   — 500 internal
+//
+// Если клиент отключился, ответ не пишется: читать его некому.
+func writeError(w http.ResponseWriter, err error) {
+	writeMappedError(w, err, false)
+}
+
+// writeLookupError — writeError для запросов к метрике по пути /{type}/{name}: метрики
+// неизвестного типа не существует, поэтому ответ 404, но с кодом unsupported_type.
+func writeLookupError(w http.ResponseWriter, err error) {
+	writeMappedError(w, err, true)
+}
+
+func writeMappedError(w http.ResponseWriter, err error, lookup bool) {
+	if errors.Is(err, context.Canceled) {
+		logger.Log.Debug("request cancelled by client", zap.Error(err))
+		return
+	}
+	status, code := errorStatus(err)
+	if lookup && code == codeUnsupportedType {
+		status = http.StatusNotFound
+	}
+	message := err.Error()
+	switch status {
+	case http.StatusInternalServerError:
+		logger.Log.Error("handler: error from service", zap.Error(err))
+		// внутренние подробности клиенту не отдаём
+		message = "internal server error"
+	case http.StatusServiceUnavailable:
+		logger.Log.Warn("request timed out", zap.Error(err))
+		message = "request timed out"
+	default:
+		logger.Log.Debug("handler: error from service", zap.Error(err))
+	}
+	var field string
+	var fieldErr *repository.FieldError
+	if errors.As(err, &fieldErr) {
+		field = fieldErr.Field
+		message = fieldErr.Message
+	}
+	writeErrorResponse(w, status, code, message, field)
+}
+
+func errorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable, codeTimeout
+	case errors.Is(err, repository.ErrNotFound):
+		return http.StatusNotFound, codeNotFound
+	case errors.Is(err, repository.ErrUnsupportedType):
+		return http.StatusBadRequest, codeUnsupportedType
+	case errors.Is(err, repository.ErrInvalidValue):
+		return http.StatusBadRequest, codeInvalidValue
+	case errors.Is(err, service.ErrReservedName):
+		return http.StatusBadRequest, codeReservedName
+	case errors.Is(err, repository.ErrCardinalityLimit):
+		return http.StatusUnprocessableEntity, codeCardinalityLimit
+	case isBodyTooLarge(err):
+		return http.StatusRequestEntityTooLarge, codePayloadTooLarge
+	default:
+		return http.StatusInternalServerError, codeInternal
+	}
+}
+
+var unknownFieldRe = regexp.MustCompile(`^(?:json|msgpack): unknown field "(.*)"$|^csv: unknown column "(.*)"$`)
+
+// writeDecodeError отвечает на ошибку разбора тела запроса в любом формате: слишком большое тело — 413,
+// остальное — 400 с полем, если его удалось определить.
+func writeDecodeError(w http.ResponseWriter, err error) {
+	logger.Log.Debug("cannot decode request body", zap.Error(err))
+	if isBodyTooLarge(err) {
+		writeErrorResponse(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge, "request body is too large", "")
+		return
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		writeErrorResponse(w, http.StatusBadRequest, codeInvalidRequest,
+			fmt.Sprintf("field %s must be %s", typeErr.Field, typeErr.Type), typeErr.Field)
+		return
+	}
+	if m := unknownFieldRe.FindStringSubmatch(err.Error()); m != nil {
+		field := m[1] + m[2]
+		writeErrorResponse(w, http.StatusBadRequest, codeInvalidRequest, "unknown field "+field, field)
+		return
+	}
+	writeErrorResponse(w, http.StatusBadRequest, codeInvalidRequest, "invalid request body: "+err.Error(), "")
+}
+
+// writeUnsupportedMediaType — ответ на тело не в JSON.
+func writeUnsupportedMediaType(w http.ResponseWriter) {
+	writeErrorResponse(w, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, "Content-Type must be application/json", "")
+}
@@ -1,15 +1,24 @@
 package handlers
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"math"
+	"monalert/internal/auth"
+	"monalert/internal/codec"
 	"monalert/internal/compress"
+	"monalert/internal/hub"
 	"monalert/internal/logger"
 	"monalert/internal/models"
+	"monalert/internal/repository"
 	"monalert/internal/service"
+	"monalert/internal/tlsutil"
+	"net"
 	"net/http"
+	"net/netip"
 	"strconv"
 	"strings"
 	"time"
@@ -18,31 +27,126 @@ import (
 	"go.uber.org/zap"
 )
 
-func Serve(flagServerAddr string, monalert *service.Monalert) error {
+// Config — настройки HTTP-сервера.
+type Config struct {
+	Addr   string       // адрес, на котором слушает сервер
+	Tokens *auth.Tokens // API-токены, nil — доступ без аутентификации
+	// TrustedSubnets — подсети, из которых принимаются обновления, пустой список — отовсюду
+	TrustedSubnets []netip.Prefix
+	// TLSCertFile и TLSKeyFile включают HTTPS, TLSClientCAFile — проверку сертификатов клиентов
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+	Limits          Limits
+	// Key — ключ подписи обновлений HMAC-SHA256, пустой — подпись не проверяется
+	Key string
+	// RequestTimeout ограничивает обработку запроса, кроме /stream и /ws. 0 — без ограничения
+	RequestTimeout time.Duration
+	// CompressMinSize — ответы короче этого числа байт отдаются несжатыми
+	CompressMinSize int
+}
+
+func Serve(cfg Config, monalert *service.Monalert) error {
 	h := newHandlers(monalert)
+	if cfg.Tokens != nil {
+		h.auth = cfg.Tokens
+	}
+	h.trustedSubnets = cfg.TrustedSubnets
+	h.key = cfg.Key
+	h.timeout = cfg.RequestTimeout
+	h.compressMinSize = cfg.CompressMinSize
+	h.requests = monalert
+	h.limits = cfg.Limits.withDefaults()
+	if h.limits.Rate > 0 {
+		h.limiter = newRateLimiter(h.limits.Rate, h.limits.Burst)
+	}
 	router := newRouter(h)
 	srv := &http.Server{
-		Addr:    flagServerAddr,
+		Addr:    cfg.Addr,
 		Handler: router,
 	}
-	if err := srv.ListenAndServe(); err != nil {
-		return fmt.Errorf("failed to start server on %s: %w", flagServerAddr, err)
+	if cfg.TLSCertFile == "" {
+		if err := srv.ListenAndServe(); err != nil {
+			return fmt.Errorf("failed to start server on %s: %w", cfg.Addr, err)
+		}
+		return nil
+	}
+	certs, err := tlsutil.NewServerCerts(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSClientCAFile)
+	if err != nil {
+		return err
+	}
+	certs.ReloadOnSIGHUP()
+	srv.TLSConfig = certs.TLSConfig()
+	// сертификаты отдаёт TLSConfig, поэтому пути к файлам здесь не нужны
+	if err := srv.ListenAndServeTLS("", ""); err != nil {
+		return fmt.Errorf("failed to start TLS server on %s: %w", cfg.Addr, err)
 	}
 	return nil
 }
 
 func newRouter(h *handlers) chi.Router {
 	r := chi.NewRouter()
-	r.Use(MyLogger())
-	r.Use(gzipMiddleware())
-	r.Get("/", h.handleMain)
-	r.Route("/update", func(r chi.Router) {
-		r.Post("/", h.handleMetricUpdate)
-		r.Post("/{metricType}/{metricName}/{metricValue}", h.handleMetricUpdate)
-		r.Post("/{metricType}/{metricName}", h.handleIncompleteURL)
+	r.Use(MyLogger(h.requests))
+	r.Use(limitBody(h.limits.MaxBodyBytes))
+	r.Use(compressMiddleware(h.compression, h.compressMinSize, h.limits.MaxDecompressedBytes))
+	// описание API открыто всем: в нём нет данных, а клиенту оно нужно до получения токена
+	r.Get("/openapi.json", h.handleOpenAPI)
+	r.Get("/docs", h.handleDocs)
+	r.Group(func(r chi.Router) {
+		r.Use(h.requireScope(auth.ScopeRead))
+		// поток живёт, пока клиент подключён, таймаут запроса к нему не применяется
+		r.Get("/stream", h.handleStream)
+		r.Group(func(r chi.Router) {
+			r.Use(requestTimeout(h.timeout))
+			r.Get("/", h.handleMain)
+			r.Get("/value/{metricType}/{metricName}", h.handleGetMetric)
+			r.Post("/value/", h.handleGetMetricJSON)
+			r.Get("/metric/{metricType}/{metricName}", h.handleMetricPage)
+			r.Get("/meta/", h.handleListMetadata)
+			r.Get("/meta/{metricName}", h.handleGetMetadata)
+			r.Get("/metrics", h.handlePrometheus)
+		})
+	})
+	// запись через старые маршруты и через /api/v1 проходит одни и те же проверки
+	write := chi.Middlewares{
+		trustedSubnet(h.trustedSubnets),
+		h.requireScope(auth.ScopeWrite),
+		h.rateLimit,
+		requestTimeout(h.timeout),
+		verifySignature(h.key),
+	}
+	r.Group(func(r chi.Router) {
+		r.Use(write...)
+		r.Post("/updates/", h.handleBatchUpdate)
+		r.Route("/update", func(r chi.Router) {
+			r.Post("/", h.handleMetricUpdate)
+			r.Post("/{metricType}/{metricName}/{metricValue}", h.handleMetricUpdate)
+			r.Post("/{metricType}/{metricName}", h.handleIncompleteURL)
+		})
+		r.Put("/meta/{metricName}", h.handlePutMetadata)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(h.requireScope(auth.ScopeAdmin))
+		r.Use(requestTimeout(h.timeout))
+		r.Get("/admin/cardinality", h.handleCardinality)
+		r.Delete("/value/{metricType}/{metricName}", h.handleDeleteMetric)
+		r.Delete("/value/", h.handleDeleteMetrics)
+	})
+	r.Route(apiV1Prefix, func(r chi.Router) {
+		r.Group(func(r chi.Router) {
+			r.Use(h.requireScope(auth.ScopeRead))
+			r.Use(requestTimeout(h.timeout))
+			r.Get("/metrics", h.handleListMetrics)
+			r.Get("/metrics/{metricType}/{metricName}", h.handleGetMetricV1)
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(write...)
+			r.Put("/metrics/{metricType}/{metricName}", h.handlePutMetric)
+			r.Post("/metrics:batch", h.handleBatchV1)
+		})
 	})
-	r.Get("/value/{metricType}/{metricName}", h.handleGetMetric)
-	r.Post("/value/", h.handleGetMetricJSON)
+	// через /ws тоже принимаются обновления, поэтому он закрыт той же проверкой подсети
+	r.With(trustedSubnet(h.trustedSubnets)).Get("/ws", h.handleWebSocket)
 	return r
 }
 
@@ -73,7 +177,27 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.status = statusCode // захватываем код статуса
 }
 
-func MyLogger() func(http.Handler) http.Handler {
+// Hijack нужен для перехода соединения на протокол WebSocket.
+func (r *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err == nil {
+		r.responseData.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap даёт http.ResponseController добраться до исходного writer, например для Flush.
+func (r *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// RequestObserver получает каждый обработанный запрос: маршрут из RouteLabel, код ответа и длительность.
+type RequestObserver interface {
+	ObserveRequest(route string, status int, duration time.Duration)
+}
+
+// MyLogger пишет запросы в лог и передаёт их observer, если он задан.
+func MyLogger(observer RequestObserver) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
@@ -87,10 +211,11 @@ func MyLogger() func(http.Handler) http.Handler {
 				responseData:   responseData,
 			}
 
+			info := &requestInfo{}
 			method := r.Method
 			uri := r.URL.Path
 			host := r.Host
-			next.ServeHTTP(&lw, r)
+			next.ServeHTTP(&lw, r.WithContext(withRequestInfo(r.Context(), info)))
 
 			status := responseData.status
 			if status == 0 {
@@ -98,72 +223,116 @@ func MyLogger() func(http.Handler) http.Handler {
 			}
 
 			duration := time.Since(start)
-			logger.Log.Info("got incoming HTTP request",
+			fields := []zap.Field{
 				zap.String("method", method),
 				zap.String("host", host),
 				zap.String("path", uri),
 				zap.String("duration", duration.String()),
 				zap.String("status", strconv.Itoa(status)),
 				zap.String("size", strconv.Itoa(responseData.size)),
-			)
+			}
+			if info.tokenName != "" {
+				fields = append(fields, zap.String("token", info.tokenName))
+			}
+			logger.Log.Info("got incoming HTTP request", fields...)
+			if observer != nil {
+				// шаблон маршрута chi заполняет по ходу роутинга, после обработки он уже известен
+				var pattern string
+				if rctx := chi.RouteContext(r.Context()); rctx != nil {
+					pattern = rctx.RoutePattern()
+				}
+				observer.ObserveRequest(service.RouteLabel(method, pattern), status, duration)
+			}
 		})
 	}
 }
 
+// Service — бизнес-логика сервера. Хендлеры передают в неё контекст запроса,
+// поэтому отключение клиента и таймаут прерывают и работу с хранилищем.
 type Service interface {
-	MetricUpdate(req *models.Metrics) (*models.Metrics, error)
-	GetMetric(req *models.Metrics) (*models.Metrics, error)
-	GetAllMetrics() []models.Metrics
+	MetricUpdate(ctx context.Context, req *models.Metrics) (*models.Metrics, error)
+	MetricUpdates(ctx context.Context, reqs []models.Metrics) ([]models.Metrics, error)
+	GetMetric(ctx context.Context, req *models.Metrics) (*models.Metrics, error)
+	GetAllMetrics(ctx context.Context) ([]models.Metrics, error)
+	ListMetrics(ctx context.Context, opts repository.ListOptions) (repository.ListPage, error)
+	WalkMetrics(ctx context.Context, opts repository.ListOptions, start func(n int) error, fn func(models.Metrics) error) error
+	GetHistory(ctx context.Context, req *models.Metrics) ([]models.Sample, error)
+	Subscribe(filter hub.Filter, lastEventID uint64) *hub.Subscription
+	Cardinality(depth, top int) repository.CardinalityReport
+	DeleteMetric(ctx context.Context, req *models.Metrics) error
+	DeleteByPrefix(ctx context.Context, mType, prefix string) (int, error)
+	SetMetadata(ctx context.Context, name string, md models.Metadata) error
+	GetMetadata(ctx context.Context, name string) (*models.Metadata, error)
+	AllMetadata(ctx context.Context) (map[string]models.Metadata, error)
+	SelfMetricFamilies() []service.Family
 }
 
 type handlers struct {
-	monalert Service
+	monalert       Service
+	auth           Authenticator
+	trustedSubnets []netip.Prefix
+	limits         Limits
+	limiter        *rateLimiter // nil — частота запросов не ограничена
+	key            string       // ключ подписи обновлений, пустой — подпись не проверяется
+	timeout        time.Duration
+	codecs         *codec.Registry // форматы тел запросов и ответов
+	compression    *compress.Registry
+	// compressMinSize — ответы короче этого числа байт не сжимаются
+	compressMinSize int
+	requests        RequestObserver // nil — запросы не учитываются в метриках сервера
 }
 
 func newHandlers(monalert Service) *handlers {
 	return &handlers{
-		monalert: monalert,
+		monalert:    monalert,
+		limits:      Limits{}.withDefaults(),
+		codecs:      codec.Default(),
+		compression: compress.Default(),
 	}
 }
 
-func gzipMiddleware() func(http.Handler) http.Handler {
+// compressMiddleware сжимает ответы алгоритмом, выбранным по Accept-Encoding, и распаковывает
+// запросы по Content-Encoding. Ответы короче minSize не сжимаются. Распакованное тело
+// ограничено maxDecompressed байтами, чтобы маленькое сжатое тело не раздулось в памяти.
+func compressMiddleware(codecs *compress.Registry, minSize int, maxDecompressed int64) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// по умолчанию устанавливаем оригинальный http.ResponseWriter как тот,
-			// который будем передавать следующей функции
-			ow := w
-
-			// проверяем, что клиент умеет получать от сервера сжатые данные в формате gzip
-			acceptEncoding := r.Header.Get("Accept-Encoding")
-			supportsGzip := strings.Contains(acceptEncoding, "gzip")
-			if supportsGzip {
-				// оборачиваем оригинальный http.ResponseWriter новым с поддержкой сжатия
-				cw := compress.NewCompressWriter(w)
-				// меняем оригинальный http.ResponseWriter на новый
-				ow = cw
-				// не забываем отправить клиенту все сжатые данные после завершения middleware
-				ow.Header().Set("Content-Encoding", "gzip")
-				ow.Header().Del("Content-Length")
-				defer cw.Close()
+			// соединение WebSocket забирается у HTTP-сервера, сжимать в нём нечего
+			if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+				next.ServeHTTP(w, r)
+				return
 			}
 
-			// проверяем, что клиент отправил серверу сжатые данные в формате gzip
-			contentEncoding := r.Header.Get("Content-Encoding")
-			sendsGzip := strings.Contains(contentEncoding, "gzip")
-			if sendsGzip {
-				// оборачиваем тело запроса в io.Reader с поддержкой декомпрессии
-				cr, err := compress.NewCompressReader(r.Body)
+			if contentEncoding := r.Header.Get("Content-Encoding"); contentEncoding != "" && !strings.EqualFold(contentEncoding, "identity") {
+				c, ok := codecs.Get(contentEncoding)
+				if !ok {
+					// RFC 9110, 15.5.16: в ответе на неизвестное сжатие перечисляются поддерживаемые
+					w.Header().Set("Accept-Encoding", strings.Join(codecs.Names(), ", "))
+					writeErrorResponse(w, http.StatusUnsupportedMediaType, codeUnsupportedMediaType,
+						"Content-Encoding must be one of "+strings.Join(codecs.Names(), ", "), "")
+					return
+				}
+				cr, err := c.NewReader(r.Body)
 				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
+					if isBodyTooLarge(err) {
+						writeErrorResponse(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge, "request body is too large", "")
+						return
+					}
+					writeErrorResponse(w, http.StatusBadRequest, codeInvalidRequest, "invalid "+c.Name()+" body", "")
 					return
 				}
-				// меняем тело запроса на новое
-				r.Body = cr
 				defer cr.Close()
+				r.Body = http.MaxBytesReader(w, cr, maxDecompressed)
 			}
 
-			// передаём управление хендлеру
-			next.ServeHTTP(ow, r)
+			// ответ зависит от Accept-Encoding, даже если сейчас он не сжат
+			w.Header().Add("Vary", "Accept-Encoding")
+			if c, ok := codecs.Negotiate(r.Header.Get("Accept-Encoding")); ok {
+				cw := compress.NewResponseWriter(w, c, minSize)
+				defer cw.Close()
+				w = cw
+			}
+			next.ServeHTTP(w, r)
 		})
 	}
 }
@@ -181,16 +350,17 @@ func (h *handlers) handleMetricUpdate(w http.ResponseWriter, r *http.Request) {
 			val, err := strconv.ParseFloat(metricValue, 64)
 			if err != nil || math.IsNaN(val) || math.IsInf(val, 0) {
 				log.Print("error in converting metric value to float64")
-				w.WriteHeader(http.StatusBadRequest)
+				writeErrorResponse(w, http.StatusBadRequest, codeInvalidValue, "gauge value must be a finite number", "value")
 				return
 			}
-			_, err = h.monalert.MetricUpdate(&models.Metrics{
-				MType: metricType,
-				ID:    metricName,
-				Value: &val,
+			_, err = h.monalert.MetricUpdate(r.Context(), &models.Metrics{
+				MType:  metricType,
+				ID:     metricName,
+				Value:  &val,
+				Source: clientKey(r),
 			})
 			if err != nil {
-				w.WriteHeader(http.StatusBadRequest)
+				writeError(w, err)
 				return
 			}
 			w.WriteHeader(http.StatusOK)
@@ -199,29 +369,31 @@ func (h *handlers) handleMetricUpdate(w http.ResponseWriter, r *http.Request) {
 			val, err := strconv.ParseInt(metricValue, 10, 64)
 			if err != nil {
 				log.Print("error in converting metric value to int64", err, val)
-				w.WriteHeader(http.StatusBadRequest)
+				writeErrorResponse(w, http.StatusBadRequest, codeInvalidValue, "counter value must be an integer", "delta")
 				return
 			}
-			_, err = h.monalert.MetricUpdate(&models.Metrics{
-				MType: metricType,
-				ID:    metricName,
-				Delta: &val,
+			_, err = h.monalert.MetricUpdate(r.Context(), &models.Metrics{
+				MType:      metricType,
+				ID:         metricName,
+				Delta:      &val,
+				Cumulative: r.URL.Query().Get("cumulative") == "true",
+				Source:     clientKey(r),
 			})
 			if err != nil {
-				w.WriteHeader(http.StatusBadRequest)
+				writeError(w, err)
 				return
 			}
 			w.WriteHeader(http.StatusOK)
 			return
 		default:
-			http.Error(w, "unsupported metric type", http.StatusBadRequest)
+			writeErrorResponse(w, http.StatusBadRequest, codeUnsupportedType, "unsupported metric type: "+metricType, "type")
 			return
 		}
 	}
 
 	contentType := r.Header.Get("Content-Type")
 	if contentType != "application/json" {
-		http.Error(w, "Unsupported content type", http.StatusUnsupportedMediaType)
+		writeUnsupportedMediaType(w)
 		return
 	}
 
@@ -231,26 +403,22 @@ func (h *handlers) handleMetricUpdate(w http.ResponseWriter, r *http.Request) {
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
 	if err := dec.Decode(&req); err != nil {
-		logger.Log.Debug("cannot decode request JSON body", zap.Error(err), zap.Any("decoded json:", &req))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	if req.ID == "" || req.MType == "" || (req.MType == "counter" && req.Delta == nil) || (req.MType == "gauge" && req.Value == nil) {
-		logger.Log.Debug("empty fields in JSON body")
-		w.WriteHeader(http.StatusBadRequest)
+		writeDecodeError(w, err)
 		return
 	}
 
-	resp, err := h.monalert.MetricUpdate(&models.Metrics{
-		MType: req.MType,
-		ID:    req.ID,
-		Value: req.Value,
-		Delta: req.Delta,
+	// метрику проверяет сервис, ошибка с полем вернётся клиенту как есть
+	resp, err := h.monalert.MetricUpdate(r.Context(), &models.Metrics{
+		MType:      req.MType,
+		ID:         req.ID,
+		Value:      req.Value,
+		Delta:      req.Delta,
+		Cumulative: req.Cumulative,
+		Source:     clientKey(r),
 	})
 
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, err)
 		return
 	}
 
@@ -266,49 +434,45 @@ func (h *handlers) handleMetricUpdate(w http.ResponseWriter, r *http.Request) {
 	logger.Log.Debug("sending HTTP 200 response")
 }
 
+// handleGetMetricJSON отдаёт метрику по id и type из тела запроса в формате из Accept.
 func (h *handlers) handleGetMetricJSON(w http.ResponseWriter, r *http.Request) {
 	logger.Log.Debug("handleGetMetric: incoming request", zap.String("method", r.Method), zap.String("path", r.URL.Path))
-	var req *models.Metrics
+	var req models.Metrics
 	logger.Log.Debug("decoding request")
 	dec := json.NewDecoder(r.Body)
 	if err := dec.Decode(&req); err != nil {
-		logger.Log.Error("cannot decode request JSON body", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
+		writeDecodeError(w, err)
 		return
 	}
-	resp, err := h.monalert.GetMetric(&models.Metrics{
+	resp, err := h.monalert.GetMetric(r.Context(), &models.Metrics{
 		MType: req.MType,
 		ID:    req.ID,
 	})
 	if err != nil {
-		logger.Log.Error("handler: error from service", zap.Error(err))
-		w.WriteHeader(http.StatusNotFound)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-
-	// сериализуем ответ сервера
-	enc := json.NewEncoder(w)
-	if err := enc.Encode(resp); err != nil {
-		logger.Log.Error("error encoding response", zap.Error(err))
+		writeLookupError(w, err)
 		return
 	}
+	writeEncoded(w, h.responseCodec(r), resp)
 	logger.Log.Debug("sending HTTP 200 response")
 }
 
 func (h *handlers) handleGetMetric(rw http.ResponseWriter, r *http.Request) {
 	metricType := chi.URLParam(r, "metricType")
 	metricName := chi.URLParam(r, "metricName")
-	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	val, err := h.monalert.GetMetric(&models.Metrics{
+	val, err := h.monalert.GetMetric(r.Context(), &models.Metrics{
 		MType: metricType,
 		ID:    metricName,
 	})
 	if err != nil {
-		logger.Log.Debug("handler: error from service", zap.Error(err))
-		rw.WriteHeader(http.StatusNotFound)
+		writeLookupError(rw, err)
 		return
 	}
+	// по умолчанию значение отдаётся текстом, другой формат нужно назвать в Accept явно
+	if c, ok := h.explicitCodec(r); ok {
+		writeEncoded(rw, c, val)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	switch metricType {
 	case "gauge":
 		// TODO add check for *val.Value nil
@@ -321,19 +485,26 @@ func (h *handlers) handleGetMetric(rw http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleMain отдаёт дашборд или, если клиент явно назвал в Accept формат данных, все метрики.
+// Параметры выборки те же, что у GET /api/v1/metrics, но без лимита по умолчанию:
+// список отдаётся потоком.
 func (h *handlers) handleMain(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("content-type", "text/html")
-	data, err := json.MarshalIndent(h.monalert.GetAllMetrics(), "", " ")
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	c, ok := h.explicitCodec(r)
+	if !ok {
+		h.handleDashboard(w, r)
 		return
 	}
-	_, err = w.Write(data)
+	if rejectSelector(w, r) {
+		return
+	}
+	opts, err := listOptions(r.URL.Query(), 0, 0)
 	if err != nil {
-		logger.Log.Error("handleMain: write error", zap.Error(err))
+		writeError(w, err)
+		return
 	}
+	h.streamMetrics(w, r, c, opts)
 }
 
 func (h *handlers) handleIncompleteURL(rw http.ResponseWriter, r *http.Request) {
-	http.Error(rw, "unsupported URL", http.StatusBadRequest)
+	writeErrorResponse(rw, http.StatusBadRequest, codeInvalidRequest, "metric value is missing: use /update/{type}/{name}/{value}", "value")
 }
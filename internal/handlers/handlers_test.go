@@ -1,38 +1,94 @@
 package handlers
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"monalert/client"
+	"monalert/internal/auth"
+	"monalert/internal/codec"
+	"monalert/internal/compress"
+	"monalert/internal/hub"
 	"monalert/internal/models"
+	"monalert/internal/repository"
+	"monalert/internal/service"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 type mockMonalert struct {
+	hub *hub.Hub
 }
 
-func (m *mockMonalert) MetricUpdate(req *models.Metrics) (*models.Metrics, error) {
+func (m *mockMonalert) MetricUpdate(ctx context.Context, req *models.Metrics) (*models.Metrics, error) {
+	if err := models.ValidateMetric(req); err != nil {
+		return nil, fmt.Errorf("service: failed to update metric value: %w", err)
+	}
+	if strings.HasPrefix(req.ID, "slow") {
+		// медленное хранилище: ждёт, пока запрос не отменят
+		<-ctx.Done()
+		return nil, fmt.Errorf("service: failed to update metric value: %w", ctx.Err())
+	}
+	if strings.HasPrefix(req.ID, "overflow") {
+		return nil, fmt.Errorf("service: failed to update metric value: %w", repository.ErrSeriesLimit)
+	}
 	return req, nil
 }
 
-func (m *mockMonalert) GetMetric(req *models.Metrics) (*models.Metrics, error) {
+func (m *mockMonalert) MetricUpdates(ctx context.Context, reqs []models.Metrics) ([]models.Metrics, error) {
+	for i := range reqs {
+		if err := models.ValidateMetric(&reqs[i]); err != nil {
+			return nil, fmt.Errorf("service: failed to update metrics: %w", repository.BatchFieldError(i, err))
+		}
+	}
+	resp := make([]models.Metrics, 0, len(reqs))
+	for i := range reqs {
+		updated, err := m.MetricUpdate(ctx, &reqs[i])
+		if errors.Is(err, repository.ErrCardinalityLimit) {
+			return nil, fmt.Errorf("service: failed to update metrics: %w", repository.BatchFieldError(i, err))
+		}
+		if err != nil {
+			return nil, err
+		}
+		resp = append(resp, *updated)
+	}
+	return resp, nil
+}
+
+func (m *mockMonalert) GetMetric(ctx context.Context, req *models.Metrics) (*models.Metrics, error) {
+	if strings.HasPrefix(req.ID, "missing") && (req.MType == "gauge" || req.MType == "counter") {
+		return nil, fmt.Errorf("service: failed to get metric value: %w", repository.ErrNotFound)
+	}
 	switch req.MType {
 	case "gauge":
 		val := 42.5
-		return &models.Metrics{Value: &val}, nil
+		return &models.Metrics{ID: req.ID, MType: req.MType, Value: &val}, nil
 	case "counter":
 		var val int64 = 100
-		return &models.Metrics{Delta: &val}, nil
+		return &models.Metrics{ID: req.ID, MType: req.MType, Delta: &val}, nil
 	default:
-		return nil, errors.New("service: failed to get metric value")
+		return nil, fmt.Errorf("service: failed to get metric value: %w",
+			repository.NewFieldError("type", repository.ErrUnsupportedType, "unsupported metric type: %s", req.MType))
 	}
 }
 
-func (m *mockMonalert) GetAllMetrics() []models.Metrics {
+func (m *mockMonalert) GetAllMetrics(ctx context.Context) ([]models.Metrics, error) {
 	value := 1.2
 	var delta int64 = 1
 	return []models.Metrics{
@@ -46,7 +102,102 @@ func (m *mockMonalert) GetAllMetrics() []models.Metrics {
 			MType: "counter",
 			Delta: &delta,
 		},
+	}, nil
+}
+
+// ListMetrics повторяет выборку хранилища на данных GetAllMetrics.
+func (m *mockMonalert) ListMetrics(ctx context.Context, opts repository.ListOptions) (repository.ListPage, error) {
+	if err := opts.Validate(); err != nil {
+		return repository.ListPage{}, fmt.Errorf("service: failed to list metrics: %w", err)
 	}
+	all, _ := m.GetAllMetrics(ctx)
+	page := repository.ListPage{Metrics: []models.Metrics{}}
+	for _, metric := range all {
+		if opts.Matches(metric) {
+			page.Total++
+			if opts.After(metric) {
+				page.Metrics = append(page.Metrics, metric)
+			}
+		}
+	}
+	sort.Slice(page.Metrics, func(i, j int) bool { return opts.Less(page.Metrics[i], page.Metrics[j]) })
+	if opts.Limit > 0 && len(page.Metrics) > opts.Limit {
+		page.Metrics = page.Metrics[:opts.Limit]
+		page.Next = opts.NextCursor(page.Metrics[opts.Limit-1])
+	}
+	return page, nil
+}
+
+func (m *mockMonalert) WalkMetrics(ctx context.Context, opts repository.ListOptions, start func(n int) error, fn func(models.Metrics) error) error {
+	opts.Limit = 0
+	page, err := m.ListMetrics(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if err := start(len(page.Metrics)); err != nil {
+		return err
+	}
+	for _, metric := range page.Metrics {
+		if err := fn(metric); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *mockMonalert) SelfMetricFamilies() []service.Family {
+	return nil
+}
+
+func (m *mockMonalert) GetHistory(ctx context.Context, req *models.Metrics) ([]models.Sample, error) {
+	if req.MType != "gauge" && req.MType != "counter" {
+		return nil, fmt.Errorf("service: failed to get metric history: %w", repository.ErrUnsupportedType)
+	}
+	return []models.Sample{
+		{Time: time.Now(), Value: 1},
+		{Time: time.Now(), Value: 2},
+	}, nil
+}
+
+func (m *mockMonalert) Subscribe(filter hub.Filter, lastEventID uint64) *hub.Subscription {
+	return m.hub.Subscribe(filter, lastEventID)
+}
+
+func (m *mockMonalert) Cardinality(depth, top int) repository.CardinalityReport {
+	return repository.CardinalityReport{
+		Series:      3,
+		TopPrefixes: []repository.SeriesCount{{Name: "node", Series: 2}, {Name: "Alloc", Series: 1}},
+	}
+}
+
+func (m *mockMonalert) DeleteMetric(ctx context.Context, req *models.Metrics) error {
+	if req.ID != "metric1" {
+		return fmt.Errorf("service: failed to delete metric: %w", repository.ErrNotFound)
+	}
+	return nil
+}
+
+func (m *mockMonalert) DeleteByPrefix(ctx context.Context, mType, prefix string) (int, error) {
+	return 2, nil
+}
+
+func (m *mockMonalert) SetMetadata(ctx context.Context, name string, md models.Metadata) error {
+	return service.ValidateMetadata(&md)
+}
+
+func (m *mockMonalert) GetMetadata(ctx context.Context, name string) (*models.Metadata, error) {
+	all, _ := m.AllMetadata(ctx)
+	md, ok := all[name]
+	if !ok {
+		return nil, fmt.Errorf("service: failed to get metadata: %w", repository.ErrNotFound)
+	}
+	return &md, nil
+}
+
+func (m *mockMonalert) AllMetadata(ctx context.Context) (map[string]models.Metadata, error) {
+	return map[string]models.Metadata{
+		"metric1": {Unit: "bytes", Help: "Allocated heap.\nIn bytes.", Type: "gauge"},
+	}, nil
 }
 
 func testRequest(t *testing.T, ts *httptest.Server, method, path string) (*http.Response, string) {
@@ -118,6 +269,66 @@ func TestRouter(t *testing.T) {
 			url:          "/update/gauge/temperature/nat",
 			expectedCode: http.StatusBadRequest,
 		},
+		{
+			name:         "new series over cardinality limit",
+			method:       http.MethodPost,
+			url:          "/update/gauge/overflow1/1",
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "cardinality report",
+			method:       http.MethodGet,
+			url:          "/admin/cardinality?depth=2&top=5",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "cardinality report with invalid depth",
+			method:       http.MethodGet,
+			url:          "/admin/cardinality?depth=0",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "delete metric",
+			method:       http.MethodDelete,
+			url:          "/value/gauge/metric1",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "delete unknown metric",
+			method:       http.MethodDelete,
+			url:          "/value/gauge/unknown",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "delete by prefix",
+			method:       http.MethodDelete,
+			url:          "/value/?prefix=node.",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "delete by empty prefix",
+			method:       http.MethodDelete,
+			url:          "/value/",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "GET metadata",
+			method:       http.MethodGet,
+			url:          "/meta/metric1",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "GET unknown metadata",
+			method:       http.MethodGet,
+			url:          "/meta/unknown",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "list metadata",
+			method:       http.MethodGet,
+			url:          "/meta/",
+			expectedCode: http.StatusOK,
+		},
 		{
 			name:         "POST root",
 			method:       http.MethodPost,
@@ -130,6 +341,18 @@ func TestRouter(t *testing.T) {
 			url:          "/",
 			expectedCode: http.StatusOK,
 		},
+		{
+			name:         "GET metric page",
+			method:       http.MethodGet,
+			url:          "/metric/gauge/metric1",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "GET metric page with unknown type",
+			method:       http.MethodGet,
+			url:          "/metric/foo/metric1",
+			expectedCode: http.StatusNotFound,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -239,3 +462,1043 @@ func TestRouter(t *testing.T) {
 		}
 	}
 */
+
+func testTokens(t *testing.T) *auth.Tokens {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	data := `[
+		{"name": "reader", "hash": "` + auth.Hash("reader-secret") + `", "scopes": ["metrics:read"]},
+		{"name": "writer", "hash": "` + auth.Hash("writer-secret") + `", "scopes": ["metrics:write"]},
+		{"name": "root", "hash": "` + auth.Hash("admin-secret") + `", "scopes": ["admin"]}
+	]`
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+	tokens, err := auth.Load(path)
+	require.NoError(t, err)
+	return tokens
+}
+
+func TestAuth(t *testing.T) {
+	h := newHandlers(&mockMonalert{})
+	h.auth = testTokens(t)
+	ts := httptest.NewServer(newRouter(h))
+	defer ts.Close()
+	tests := []struct {
+		name         string
+		method       string
+		url          string
+		token        string
+		expectedCode int
+	}{
+		{name: "no token", method: http.MethodGet, url: "/value/gauge/temperature", expectedCode: http.StatusUnauthorized},
+		{name: "invalid token", method: http.MethodGet, url: "/value/gauge/temperature", token: "nope", expectedCode: http.StatusUnauthorized},
+		{name: "reader reads", method: http.MethodGet, url: "/value/gauge/temperature", token: "reader-secret", expectedCode: http.StatusOK},
+		{name: "reader writes", method: http.MethodPost, url: "/update/gauge/temperature/1", token: "reader-secret", expectedCode: http.StatusForbidden},
+		{name: "writer writes", method: http.MethodPost, url: "/update/gauge/temperature/1", token: "writer-secret", expectedCode: http.StatusOK},
+		{name: "writer reads", method: http.MethodGet, url: "/", token: "writer-secret", expectedCode: http.StatusForbidden},
+		{name: "admin reads", method: http.MethodGet, url: "/", token: "admin-secret", expectedCode: http.StatusOK},
+		{name: "admin writes", method: http.MethodPost, url: "/update/counter/test/1", token: "admin-secret", expectedCode: http.StatusOK},
+		{name: "writer reads cardinality", method: http.MethodGet, url: "/admin/cardinality", token: "writer-secret", expectedCode: http.StatusForbidden},
+		{name: "writer deletes", method: http.MethodDelete, url: "/value/gauge/metric1", token: "writer-secret", expectedCode: http.StatusForbidden},
+		{name: "admin deletes", method: http.MethodDelete, url: "/value/gauge/metric1", token: "admin-secret", expectedCode: http.StatusOK},
+		{name: "admin reads cardinality", method: http.MethodGet, url: "/admin/cardinality", token: "admin-secret", expectedCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.url, http.NoBody)
+			require.NoError(t, err)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+			if tt.expectedCode == http.StatusUnauthorized {
+				assert.Contains(t, resp.Header.Get("WWW-Authenticate"), "Bearer")
+			}
+		})
+	}
+}
+
+func TestStream(t *testing.T) {
+	updates := hub.New(16, 16)
+	h := newHandlers(&mockMonalert{hub: updates})
+	ts := httptest.NewServer(newRouter(h))
+	defer ts.Close()
+
+	value := 1.5
+	for _, id := range []string{"HeapAlloc", "Sys", "HeapInuse"} {
+		updates.Publish(models.Metrics{ID: id, MType: "gauge", Value: &value})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/stream?prefix=Heap", http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := ts.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	assert.Equal(t, []string{
+		"id: 3",
+		"event: update",
+		`data: {"id":"HeapInuse","type":"gauge","value":1.5}`,
+	}, lines)
+
+	labelsResp, err := ts.Client().Get(ts.URL + "/stream?labels=code%3D200")
+	require.NoError(t, err)
+	defer labelsResp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, labelsResp.StatusCode)
+	var errResp errorResponse
+	require.NoError(t, json.NewDecoder(labelsResp.Body).Decode(&errResp))
+	assert.Equal(t, errorResponse{Code: codeLabelsUnsupported, Message: errLabelFilter, Field: "labels"}, errResp)
+}
+
+func TestWebSocket(t *testing.T) {
+	h := newHandlers(&mockMonalert{hub: hub.New(16, 16)})
+	h.auth = testTokens(t)
+	ts := httptest.NewServer(newRouter(h))
+	defer ts.Close()
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.Error(t, err)
+	require.NotNil(t, resp)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": []string{"Bearer writer-secret"}})
+	require.NoError(t, err)
+	resp.Body.Close()
+	defer conn.Close()
+
+	value := 1.5
+	var delta int64 = 2
+	require.NoError(t, conn.WriteJSON(wsMessage{
+		Type: "metrics",
+		Seq:  7,
+		Metrics: []models.Metrics{
+			{ID: "Alloc", MType: "gauge", Value: &value},
+			{ID: "PollCount", MType: "counter", Delta: &delta},
+			{ID: "Broken", MType: "gauge"},
+		},
+	}))
+	var ack wsMessage
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	require.NoError(t, conn.ReadJSON(&ack))
+	assert.Equal(t, "ack", ack.Type)
+	assert.Equal(t, int64(7), ack.Seq)
+	assert.Equal(t, 2, ack.Accepted)
+	require.Len(t, ack.Errors, 1)
+	assert.Equal(t, 2, ack.Errors[0].Index)
+
+	reader, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": []string{"Bearer reader-secret"}})
+	require.NoError(t, err)
+	resp.Body.Close()
+	defer reader.Close()
+	require.NoError(t, reader.WriteJSON(wsMessage{Type: "subscribe", Seq: 8, Filter: &wsFilter{Labels: map[string]string{"code": "200"}}}))
+	var reply wsMessage
+	require.NoError(t, reader.SetReadDeadline(time.Now().Add(5*time.Second)))
+	require.NoError(t, reader.ReadJSON(&reply))
+	assert.Equal(t, wsMessage{Type: "error", Seq: 8, Message: errLabelFilter}, reply)
+}
+
+func TestWebSocketSignature(t *testing.T) {
+	h := newHandlers(&mockMonalert{hub: hub.New(16, 16)})
+	h.key = "secret"
+	ts := httptest.NewServer(newRouter(h))
+	defer ts.Close()
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	defer conn.Close()
+
+	metrics := []byte(`[{"id":"Alloc","type":"gauge","value":1.5}]`)
+	signature := hex.EncodeToString(sign("secret", metrics))
+	tests := []struct {
+		name  string
+		frame string
+		want  wsMessage
+	}{
+		{name: "signed", frame: `{"type":"metrics","seq":1,"metrics":` + string(metrics) + `,"signature":"` + signature + `"}`, want: wsMessage{Type: "ack", Seq: 1, Accepted: 1}},
+		{name: "unsigned", frame: `{"type":"metrics","seq":2,"metrics":` + string(metrics) + `}`, want: wsMessage{Type: "error", Seq: 2, Message: "missing or invalid signature"}},
+		{
+			name:  "tampered metrics",
+			frame: `{"type":"metrics","seq":3,"metrics":[{"id":"Alloc","type":"gauge","value":2.5}],"signature":"` + signature + `"}`,
+			want:  wsMessage{Type: "error", Seq: 3, Message: "missing or invalid signature"},
+		},
+		{
+			name:  "wrong key",
+			frame: `{"type":"metrics","seq":4,"metrics":` + string(metrics) + `,"signature":"` + hex.EncodeToString(sign("other", metrics)) + `"}`,
+			want:  wsMessage{Type: "error", Seq: 4, Message: "missing or invalid signature"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(tt.frame)))
+			var reply wsMessage
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+			require.NoError(t, conn.ReadJSON(&reply))
+			assert.Equal(t, tt.want, reply)
+		})
+	}
+}
+
+func TestTrustedSubnet(t *testing.T) {
+	subnets, err := ParseTrustedSubnets("192.168.1.0/24, fd00::/8")
+	require.NoError(t, err)
+	h := newHandlers(&mockMonalert{})
+	h.trustedSubnets = subnets
+	ts := httptest.NewServer(newRouter(h))
+	defer ts.Close()
+	tests := []struct {
+		name         string
+		realIP       string
+		url          string
+		expectedCode int
+	}{
+		{name: "IPv4 inside", realIP: "192.168.1.17", url: "/update/gauge/a/1", expectedCode: http.StatusOK},
+		{name: "IPv4 outside", realIP: "10.0.0.1", url: "/update/gauge/a/1", expectedCode: http.StatusForbidden},
+		{name: "IPv6 inside", realIP: "fd12::1", url: "/update/gauge/a/1", expectedCode: http.StatusOK},
+		{name: "IPv6 outside", realIP: "2001:db8::1", url: "/update/gauge/a/1", expectedCode: http.StatusForbidden},
+		{name: "IPv4-mapped IPv6 inside", realIP: "::ffff:192.168.1.5", url: "/update/gauge/a/1", expectedCode: http.StatusOK},
+		{name: "no header", url: "/update/gauge/a/1", expectedCode: http.StatusForbidden},
+		{name: "garbage header", realIP: "localhost", url: "/update/gauge/a/1", expectedCode: http.StatusForbidden},
+		{name: "reads are not restricted", url: "/value/gauge/a", expectedCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := http.MethodPost
+			if strings.HasPrefix(tt.url, "/value") {
+				method = http.MethodGet
+			}
+			req, err := http.NewRequest(method, ts.URL+tt.url, http.NoBody)
+			require.NoError(t, err)
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	h := newHandlers(&mockMonalert{})
+	h.limiter = newRateLimiter(1, 2)
+	now := time.Now()
+	h.limiter.now = func() time.Time { return now }
+	ts := httptest.NewServer(newRouter(h))
+	defer ts.Close()
+
+	post := func() *http.Response {
+		resp, _ := testRequest(t, ts, http.MethodPost, "/update/gauge/a/1")
+		return resp
+	}
+	assert.Equal(t, http.StatusOK, post().StatusCode)
+	assert.Equal(t, http.StatusOK, post().StatusCode)
+	resp := post()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+	// чтение не ограничивается
+	resp, _ = testRequest(t, ts, http.MethodGet, "/value/gauge/a")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	now = now.Add(time.Second)
+	assert.Equal(t, http.StatusOK, post().StatusCode)
+	assert.Equal(t, http.StatusTooManyRequests, post().StatusCode)
+}
+
+func TestBodyLimits(t *testing.T) {
+	h := newHandlers(&mockMonalert{})
+	h.limits.MaxBodyBytes = 1024
+	h.limits.MaxDecompressedBytes = 4096
+	ts := httptest.NewServer(newRouter(h))
+	defer ts.Close()
+
+	metric := `{"id":"a","type":"gauge","value":1}`
+	// пробелы между токенами JSON допустимы и отлично сжимаются
+	bomb, err := compress.Compress([]byte(metric[:len(metric)-1] + strings.Repeat(" ", 256<<10) + "}"))
+	require.NoError(t, err)
+	small, err := compress.Compress([]byte(metric))
+	require.NoError(t, err)
+	tests := []struct {
+		name         string
+		body         []byte
+		gzip         bool
+		expectedCode int
+	}{
+		{name: "plain body", body: []byte(metric), expectedCode: http.StatusOK},
+		{name: "gzip body", body: small, gzip: true, expectedCode: http.StatusOK},
+		{name: "plain body too large", body: []byte(metric[:len(metric)-1] + strings.Repeat(" ", 2048) + "}"), expectedCode: http.StatusRequestEntityTooLarge},
+		{name: "gzip bomb", body: bomb, gzip: true, expectedCode: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.gzip {
+				require.Less(t, len(tt.body), 1024)
+			}
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/update/", bytes.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			if tt.gzip {
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+		})
+	}
+}
+
+func TestCompression(t *testing.T) {
+	h := newHandlers(&mockMonalert{})
+	h.compressMinSize = 64
+	ts := httptest.NewServer(newRouter(h))
+	defer ts.Close()
+
+	do := func(t *testing.T, method, path string, header map[string]string, body []byte) (*http.Response, []byte) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, bytes.NewReader(body))
+		require.NoError(t, err)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		// заданный вручную Accept-Encoding отключает прозрачную распаковку в транспорте
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, data
+	}
+
+	batch := `[{"id":"a","type":"gauge","value":1.5},{"id":"b","type":"counter","delta":2}]`
+	for _, name := range compress.Default().Names() {
+		t.Run(name, func(t *testing.T) {
+			c, _ := compress.Default().Get(name)
+			compressed, err := compress.Encode(c, []byte(batch))
+			require.NoError(t, err)
+			resp, body := do(t, http.MethodPost, "/updates/", map[string]string{
+				"Content-Type":     "application/json",
+				"Content-Encoding": name,
+				"Accept-Encoding":  name,
+			}, compressed)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, name, resp.Header.Get("Content-Encoding"))
+			assert.Contains(t, resp.Header.Values("Vary"), "Accept-Encoding")
+			body, err = compress.Decode(c, body)
+			require.NoError(t, err)
+			assert.JSONEq(t, batch, string(body))
+		})
+	}
+
+	t.Run("unknown encoding", func(t *testing.T) {
+		resp, body := do(t, http.MethodPost, "/updates/", map[string]string{
+			"Content-Type":     "application/json",
+			"Content-Encoding": "lz4",
+		}, []byte(batch))
+		assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+		assert.Equal(t, "zstd, br, gzip, deflate", resp.Header.Get("Accept-Encoding"))
+		assert.JSONEq(t, `{"code":"unsupported_media_type","message":"Content-Encoding must be one of zstd, br, gzip, deflate"}`, string(body))
+	})
+
+	t.Run("invalid body", func(t *testing.T) {
+		resp, _ := do(t, http.MethodPost, "/updates/", map[string]string{
+			"Content-Type":     "application/json",
+			"Content-Encoding": "zstd",
+		}, []byte(batch))
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("small response", func(t *testing.T) {
+		resp, body := do(t, http.MethodGet, "/value/gauge/metric1", map[string]string{"Accept-Encoding": "gzip"}, nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Content-Encoding"))
+		assert.NotEmpty(t, body)
+	})
+
+	t.Run("not modified", func(t *testing.T) {
+		resp, _ := do(t, http.MethodGet, "/api/v1/metrics/gauge/metric1", nil, nil)
+		etag := resp.Header.Get("ETag")
+		require.NotEmpty(t, etag)
+		resp, body := do(t, http.MethodGet, "/api/v1/metrics/gauge/metric1", map[string]string{
+			"Accept-Encoding": "gzip",
+			"If-None-Match":   etag,
+		}, nil)
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Content-Encoding"))
+		assert.Empty(t, body)
+	})
+}
+
+func TestBatchUpdate(t *testing.T) {
+	h := newHandlers(&mockMonalert{})
+	h.limits.MaxBatch = 2
+	ts := httptest.NewServer(newRouter(h))
+	defer ts.Close()
+	tests := []struct {
+		name         string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "valid batch",
+			body:         `[{"id":"a","type":"gauge","value":1.5},{"id":"b","type":"counter","delta":2}]`,
+			expectedCode: http.StatusOK,
+			expectedBody: `[{"id":"a","type":"gauge","value":1.5},{"id":"b","type":"counter","delta":2}]`,
+		},
+		{name: "empty batch", body: `[]`, expectedCode: http.StatusOK, expectedBody: `[]`},
+		{name: "invalid metric", body: `[{"id":"a","type":"gauge","value":1},{"id":"b","type":"counter"}]`, expectedCode: http.StatusBadRequest},
+		{name: "too many metrics", body: `[{"id":"a","type":"gauge","value":1},{"id":"b","type":"gauge","value":1},{"id":"c","type":"gauge","value":1}]`, expectedCode: http.StatusRequestEntityTooLarge},
+		{name: "not an array", body: `{"id":"a","type":"gauge","value":1}`, expectedCode: http.StatusBadRequest},
+		{name: "cardinality limit", body: `[{"id":"overflow","type":"gauge","value":1}]`, expectedCode: http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/updates/", strings.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+			if tt.expectedBody != "" {
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				assert.JSONEq(t, tt.expectedBody, string(body))
+			}
+		})
+	}
+}
+
+func TestSignature(t *testing.T) {
+	h := newHandlers(&mockMonalert{})
+	h.key = "secret"
+	ts := httptest.NewServer(newRouter(h))
+	defer ts.Close()
+
+	body := []byte(`{"id":"a","type":"gauge","value":1}`)
+	compressed, err := compress.Compress(body)
+	require.NoError(t, err)
+	signed := func(key, method, uri string, body []byte) string {
+		return hex.EncodeToString(sign(key, signedData(method, uri, body)))
+	}
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		body         []byte
+		signature    string
+		gzip         bool
+		expectedCode int
+	}{
+		{name: "valid signature", body: body, signature: client.SignRequest("secret", http.MethodPost, "/update/", body), expectedCode: http.StatusOK},
+		{name: "signature of uncompressed body", body: compressed, gzip: true, signature: signed("secret", http.MethodPost, "/update/", body), expectedCode: http.StatusOK},
+		{name: "missing signature", body: body, expectedCode: http.StatusBadRequest},
+		{name: "wrong key", body: body, signature: signed("other", http.MethodPost, "/update/", body), expectedCode: http.StatusBadRequest},
+		{name: "not hex", body: body, signature: "zz", expectedCode: http.StatusBadRequest},
+		{name: "body only", body: body, signature: hex.EncodeToString(sign("secret", body)), expectedCode: http.StatusBadRequest},
+		{name: "url form", path: "/update/gauge/a/1", signature: signed("secret", http.MethodPost, "/update/gauge/a/1", nil), expectedCode: http.StatusOK},
+		// подпись одного запроса не подходит к другому с тем же пустым телом
+		{name: "tampered value in path", path: "/update/gauge/a/2", signature: signed("secret", http.MethodPost, "/update/gauge/a/1", nil), expectedCode: http.StatusBadRequest},
+		{name: "tampered name in path", path: "/update/gauge/b/1", signature: signed("secret", http.MethodPost, "/update/gauge/a/1", nil), expectedCode: http.StatusBadRequest},
+		{
+			name: "tampered query", path: "/update/counter/a/1?cumulative=true",
+			signature: signed("secret", http.MethodPost, "/update/counter/a/1", nil), expectedCode: http.StatusBadRequest,
+		},
+		{
+			name: "tampered method", method: http.MethodPut, path: "/meta/a", body: []byte(`{"help":"a"}`),
+			signature: signed("secret", http.MethodPost, "/meta/a", []byte(`{"help":"a"}`)), expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method, path := tt.method, tt.path
+			if method == "" {
+				method = http.MethodPost
+			}
+			if path == "" {
+				path = "/update/"
+			}
+			req, err := http.NewRequest(method, ts.URL+path, bytes.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			if tt.signature != "" {
+				req.Header.Set(signatureHeader, tt.signature)
+			}
+			if tt.gzip {
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+		})
+	}
+
+	// клиент подписывает запросы в том же формате, включая путь с экранированием
+	c := client.New(client.Config{Address: strings.TrimPrefix(ts.URL, "http://"), Key: "secret", Gzip: true})
+	one, gaugeValue := int64(1), 1.0
+	require.NoError(t, c.UpdateURL(context.Background(), &client.Metric{ID: "a b/c", MType: "counter", Delta: &one, Cumulative: true}))
+	_, err = c.Update(context.Background(), &client.Metric{ID: "a", MType: "gauge", Value: &gaugeValue})
+	require.NoError(t, err)
+
+	// чтение подписью не защищается
+	resp, _ := testRequest(t, ts, http.MethodGet, "/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	h := newHandlers(&mockMonalert{})
+	h.timeout = 20 * time.Millisecond
+	ts := httptest.NewServer(newRouter(h))
+	defer ts.Close()
+
+	resp, _ := testRequest(t, ts, http.MethodPost, "/update/gauge/slow/1")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	resp, _ = testRequest(t, ts, http.MethodPost, "/update/gauge/fast/1")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// клиент ушёл раньше таймаута: хендлер отменяется вместе с запросом
+	done := make(chan struct{})
+	slow := newHandlers(&mockMonalert{})
+	slow.timeout = time.Minute
+	router := newRouter(slow)
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		router.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ts.URL+"/update/gauge/slow/1", nil)
+	require.NoError(t, err)
+	_, err = ts.Client().Do(req)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handler was not cancelled after the client disconnected")
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	h := newHandlers(&mockMonalert{})
+	ts := httptest.NewServer(newRouter(h))
+	defer ts.Close()
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		body         string
+		expectedCode int
+		expected     errorResponse
+	}{
+		{
+			name: "malformed JSON", method: http.MethodPost, path: "/update/", body: `{`,
+			expectedCode: http.StatusBadRequest, expected: errorResponse{Code: codeInvalidRequest},
+		},
+		{
+			name: "wrong JSON type", method: http.MethodPost, path: "/update/", body: `{"id":"a","type":"gauge","value":"1"}`,
+			expectedCode: http.StatusBadRequest, expected: errorResponse{Code: codeInvalidRequest, Field: "value"},
+		},
+		{
+			name: "unknown field", method: http.MethodPost, path: "/update/", body: `{"id":"a","type":"gauge","value":1,"color":"red"}`,
+			expectedCode: http.StatusBadRequest, expected: errorResponse{Code: codeInvalidRequest, Field: "color"},
+		},
+		{
+			name: "gauge without value", method: http.MethodPost, path: "/update/", body: `{"id":"a","type":"gauge"}`,
+			expectedCode: http.StatusBadRequest, expected: errorResponse{Code: codeInvalidValue, Field: "value"},
+		},
+		{
+			name: "unsupported type", method: http.MethodPost, path: "/update/", body: `{"id":"a","type":"histogram","value":1}`,
+			expectedCode: http.StatusBadRequest, expected: errorResponse{Code: codeUnsupportedType, Field: "type"},
+		},
+		{
+			name: "reserved name", method: http.MethodPost, path: "/update/gauge/monalert_series/1",
+			expectedCode: http.StatusBadRequest, expected: errorResponse{Code: codeReservedName, Field: "id"},
+		},
+		{
+			name: "non-numeric value in path", method: http.MethodPost, path: "/update/counter/a/1.5",
+			expectedCode: http.StatusBadRequest, expected: errorResponse{Code: codeInvalidValue, Field: "delta"},
+		},
+		{
+			name: "cardinality limit", method: http.MethodPost, path: "/update/gauge/overflow/1",
+			expectedCode: http.StatusUnprocessableEntity, expected: errorResponse{Code: codeCardinalityLimit},
+		},
+		{
+			name: "invalid metric in batch", method: http.MethodPost, path: "/updates/",
+			body:         `[{"id":"a","type":"gauge","value":1},{"id":"b","type":"counter"}]`,
+			expectedCode: http.StatusBadRequest, expected: errorResponse{Code: codeInvalidValue, Field: "[1].delta"},
+		},
+		{
+			name: "new series over limit in batch", method: http.MethodPost, path: "/updates/",
+			body:         `[{"id":"a","type":"gauge","value":1},{"id":"overflow","type":"gauge","value":1}]`,
+			expectedCode: http.StatusUnprocessableEntity, expected: errorResponse{Code: codeCardinalityLimit, Field: "[1].id"},
+		},
+		{
+			name: "lookup of unknown type", method: http.MethodGet, path: "/value/histogram/a",
+			expectedCode: http.StatusNotFound, expected: errorResponse{Code: codeUnsupportedType, Field: "type"},
+		},
+		{
+			name: "delete of missing metric", method: http.MethodDelete, path: "/value/gauge/unknown",
+			expectedCode: http.StatusNotFound, expected: errorResponse{Code: codeNotFound},
+		},
+		{
+			name: "wrong content type", method: http.MethodPost, path: "/update/", body: `{}`,
+			expectedCode: http.StatusUnsupportedMediaType, expected: errorResponse{Code: codeUnsupportedMediaType},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			if tt.body != "" && tt.expectedCode != http.StatusUnsupportedMediaType {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			var got errorResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+			assert.Equal(t, tt.expected.Code, got.Code)
+			assert.Equal(t, tt.expected.Field, got.Field)
+			assert.NotEmpty(t, got.Message)
+		})
+	}
+}
+
+func TestPutMetadata(t *testing.T) {
+	h := newHandlers(&mockMonalert{})
+	ts := httptest.NewServer(newRouter(h))
+	defer ts.Close()
+	tests := []struct {
+		name         string
+		body         string
+		expectedCode int
+	}{
+		{name: "valid", body: `{"unit":"bytes","help":"Heap size.","type":"gauge"}`, expectedCode: http.StatusOK},
+		{name: "display hint", body: `{"display":"percent"}`, expectedCode: http.StatusOK},
+		{name: "unknown type", body: `{"type":"histogram"}`, expectedCode: http.StatusBadRequest},
+		{name: "unknown display", body: `{"display":"pie"}`, expectedCode: http.StatusBadRequest},
+		{name: "unknown field", body: `{"color":"red"}`, expectedCode: http.StatusBadRequest},
+		{name: "broken JSON", body: `{`, expectedCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPut, ts.URL+"/meta/HeapAlloc", strings.NewReader(tt.body))
+			require.NoError(t, err)
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+		})
+	}
+}
+
+func TestPrometheus(t *testing.T) {
+	h := newHandlers(&mockMonalert{})
+	ts := httptest.NewServer(newRouter(h))
+	defer ts.Close()
+	resp, body := testRequest(t, ts, http.MethodGet, "/metrics")
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "# HELP metric1 Allocated heap.\\nIn bytes.\n"+
+		"# TYPE metric1 gauge\n"+
+		"metric1 1.2\n"+
+		"# TYPE metric2 counter\n"+
+		"metric2 1\n", body)
+}
+
+func TestWritePromFamily(t *testing.T) {
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	writePromFamily(bw, service.Family{
+		Name: "requests",
+		Type: "histogram",
+		Help: "Request\nduration.",
+		Series: []service.Series{
+			{Suffix: "_bucket", Labels: []service.Label{{Name: "le", Value: "+Inf"}, {Name: "path", Value: `a"b\c` + "\n"}}, Value: 3},
+			{Suffix: "_sum", Value: 0.25},
+		},
+	})
+	require.NoError(t, bw.Flush())
+	assert.Equal(t, "# HELP requests Request\\nduration.\n"+
+		"# TYPE requests histogram\n"+
+		`requests_bucket{le="+Inf",path="a\"b\\c\n"} 3`+"\n"+
+		"requests_sum 0.25\n", buf.String())
+}
+
+func TestSelfMetrics(t *testing.T) {
+	store := repository.NewStore(filepath.Join(t.TempDir(), "metrics.json"), false)
+	monalert := service.NewMonalert(store, false, hub.New(16, 16))
+	h := newHandlers(monalert)
+	h.requests = monalert
+	ts := httptest.NewServer(newRouter(h))
+	defer ts.Close()
+
+	resp, _ := testRequest(t, ts, http.MethodPost, "/update/gauge/Alloc/1")
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	for range 2 {
+		resp, _ = testRequest(t, ts, http.MethodGet, "/value/gauge/missing")
+		resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+	require.NoError(t, monalert.Persist(context.Background()))
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/value/counter/monalert_http_requests_total.route_post_update_metricType_metricName_metricValue.status_200", want: "1"},
+		{path: "/value/counter/monalert_http_requests_total.route_get_value_metricType_metricName.status_404", want: "2"},
+		{path: "/value/counter/monalert_http_request_duration_seconds_count.route_get_value_metricType_metricName.status_404", want: "2"},
+		{path: "/value/counter/monalert_http_request_duration_seconds_bucket.le_inf.route_get_value_metricType_metricName.status_404", want: "2"},
+		{path: "/value/counter/monalert_ingested_metrics_total", want: "1"},
+		{path: "/value/counter/monalert_persist_duration_seconds_count", want: "1"},
+		{path: "/value/counter/monalert_persist_failures_total", want: "0"},
+	}
+	for _, tt := range tests {
+		resp, body := testRequest(t, ts, http.MethodGet, tt.path)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, tt.path)
+		assert.Equal(t, tt.want, body, tt.path)
+	}
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/metrics")
+	resp.Body.Close()
+	// метрики сервера отдаются семействами с метками, а не развёрнутыми в имена сериями
+	assert.Contains(t, body, "# HELP monalert_http_requests_total Number of HTTP requests by route and status.\n"+
+		"# TYPE monalert_http_requests_total counter\n")
+	assert.Contains(t, body, `monalert_http_requests_total{route="get_value_metricType_metricName",status="404"} 2`+"\n")
+	assert.Contains(t, body, "# TYPE monalert_http_request_duration_seconds histogram\n")
+	assert.Contains(t, body, `monalert_http_request_duration_seconds_bucket{le="+Inf",route="get_value_metricType_metricName",status="404"} 2`+"\n")
+	assert.Contains(t, body, `monalert_http_request_duration_seconds_count{route="get_value_metricType_metricName",status="404"} 2`+"\n")
+	assert.Contains(t, body, "# TYPE monalert_persist_duration_seconds histogram\n")
+	assert.NotContains(t, body, "monalert_http_requests_total_route")
+	assert.NotContains(t, body, "_bucket_le_")
+	assert.Contains(t, body, "# TYPE monalert_go_goroutines gauge\n")
+	assert.Contains(t, body, "# TYPE monalert_ingestion_rate gauge\n")
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/meta/monalert_http_request_duration_seconds_sum.route_root.status_200")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `"unit":"seconds"`)
+}
+
+func TestFormatWithMetadata(t *testing.T) {
+	tests := []struct {
+		value float64
+		md    models.Metadata
+		want  string
+	}{
+		{value: 1.5, want: "1.5"},
+		{value: 512, md: models.Metadata{Unit: "bytes"}, want: "512 B"},
+		{value: 3 << 20, md: models.Metadata{Unit: "bytes"}, want: "3.0 MiB"},
+		{value: 0.25, md: models.Metadata{Unit: "seconds"}, want: "250.0 ms"},
+		{value: 1500, md: models.Metadata{Unit: "nanoseconds"}, want: "1.5 µs"},
+		{value: 0.125, md: models.Metadata{Display: "percent"}, want: "12.50 %"},
+		{value: 3 << 20, md: models.Metadata{Unit: "bytes", Display: "raw"}, want: "3145728 bytes"},
+		{value: 42, md: models.Metadata{Unit: "requests"}, want: "42 requests"},
+		{value: 1.7e18, md: models.Metadata{Unit: "nanoseconds", Display: "timestamp"}, want: "2023-11-14 22:13:20 UTC"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, formatWithMetadata(tt.value, tt.md))
+	}
+}
+
+func TestAPIV1(t *testing.T) {
+	ts := httptest.NewServer(newRouter(newHandlers(&mockMonalert{})))
+	defer ts.Close()
+
+	t.Run("pages", func(t *testing.T) {
+		var ids []string
+		next := "/api/v1/metrics?sort=-id&limit=1"
+		for next != "" {
+			resp, body := testRequest(t, ts, http.MethodGet, next)
+			require.Equal(t, http.StatusOK, resp.StatusCode, body)
+			var page models.MetricList
+			require.NoError(t, json.Unmarshal([]byte(body), &page))
+			assert.Equal(t, 2, page.Total)
+			require.Len(t, page.Metrics, 1)
+			ids = append(ids, page.Metrics[0].ID)
+			next = page.Next
+		}
+		assert.Equal(t, []string{"metric2", "metric1"}, ids)
+	})
+
+	t.Run("cursor of another sort order", func(t *testing.T) {
+		resp, body := testRequest(t, ts, http.MethodGet, "/api/v1/metrics?limit=1")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var page models.MetricList
+		require.NoError(t, json.Unmarshal([]byte(body), &page))
+		next, err := url.Parse(page.Next)
+		require.NoError(t, err)
+		resp, body = testRequest(t, ts, http.MethodGet, "/api/v1/metrics?sort=-id&cursor="+next.Query().Get("cursor"))
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Contains(t, body, `"field":"cursor"`)
+	})
+
+	t.Run("legacy listing pages", func(t *testing.T) {
+		var ids []string
+		next := "/?limit=1"
+		for next != "" {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+next, http.NoBody)
+			require.NoError(t, err)
+			req.Header.Set("Accept", "application/json")
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			var metrics []models.Metrics
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&metrics))
+			resp.Body.Close()
+			for _, m := range metrics {
+				ids = append(ids, m.ID)
+			}
+			next = ""
+			if link := resp.Header.Get("Link"); link != "" {
+				next = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+			}
+		}
+		assert.Equal(t, []string{"metric1", "metric2"}, ids)
+	})
+
+	t.Run("etag", func(t *testing.T) {
+		resp, _ := testRequest(t, ts, http.MethodGet, "/api/v1/metrics/gauge/metric1")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		etag := resp.Header.Get("ETag")
+		require.NotEmpty(t, etag)
+
+		for _, tt := range []struct {
+			ifNoneMatch string
+			want        int
+		}{
+			{ifNoneMatch: etag, want: http.StatusNotModified},
+			{ifNoneMatch: `"other", ` + strings.TrimPrefix(etag, "W/"), want: http.StatusNotModified},
+			{ifNoneMatch: `"other"`, want: http.StatusOK},
+		} {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/metrics/gauge/metric1", http.NoBody)
+			require.NoError(t, err)
+			req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, resp.StatusCode, tt.ifNoneMatch)
+			assert.Equal(t, etag, resp.Header.Get("ETag"))
+			if tt.want == http.StatusNotModified {
+				assert.Empty(t, body)
+			}
+		}
+	})
+
+	t.Run("selector", func(t *testing.T) {
+		for _, tt := range []struct {
+			method, path string
+		}{
+			{method: http.MethodGet, path: "/api/v1/metrics?selector=env%3Dprod"},
+			{method: http.MethodGet, path: "/?selector=env%3Dprod"},
+			{method: http.MethodDelete, path: "/value/?prefix=metric&selector=env%3Dprod"},
+		} {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.path, http.NoBody)
+			require.NoError(t, err)
+			req.Header.Set("Accept", "application/json")
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			var errResp errorResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+			resp.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, tt.path)
+			assert.Equal(t, errorResponse{Code: codeLabelsUnsupported, Message: errLabelFilter, Field: "selector"}, errResp, tt.path)
+		}
+	})
+
+	t.Run("put creates", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/metrics/gauge/missing.temp", strings.NewReader(`{"value":21.5}`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, "/api/v1/metrics/gauge/missing.temp", resp.Header.Get("Location"))
+		var m models.Metrics
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&m))
+		assert.Equal(t, "missing.temp", m.ID)
+		require.NotNil(t, m.Value)
+		assert.Equal(t, 21.5, *m.Value)
+	})
+}
+
+func TestPutMetricIsIdempotent(t *testing.T) {
+	monalert := service.NewMonalert(repository.NewStore("", false), false, hub.New(16, 16))
+	ts := httptest.NewServer(newRouter(newHandlers(monalert)))
+	defer ts.Close()
+
+	do := func(t *testing.T, method, path, body string) (int, int64) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return resp.StatusCode, 0
+		}
+		var m models.Metrics
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&m))
+		require.NotNil(t, m.Delta)
+		return resp.StatusCode, *m.Delta
+	}
+	put := func(t *testing.T, body string) int64 {
+		t.Helper()
+		code, v := do(t, http.MethodPut, "/api/v1/metrics/counter/requests", body)
+		require.Less(t, code, 300)
+		return v
+	}
+	get := func(t *testing.T) int64 {
+		t.Helper()
+		code, v := do(t, http.MethodGet, "/api/v1/metrics/counter/requests", "")
+		require.Equal(t, http.StatusOK, code)
+		return v
+	}
+	for _, d := range []string{"60", "40"} {
+		resp, err := ts.Client().Post(ts.URL+"/update/counter/requests/"+d, "text/plain", nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	require.Equal(t, int64(100), get(t))
+
+	// PUT заменяет значение, а не прибавляет его
+	assert.Equal(t, int64(50), put(t, `{"delta":50}`))
+	assert.Equal(t, int64(50), get(t))
+	// повтор ничего не меняет
+	assert.Equal(t, int64(50), put(t, `{"delta":50}`))
+	assert.Equal(t, int64(50), get(t))
+	// меньшее значение тоже записывается как есть
+	assert.Equal(t, int64(3), put(t, `{"delta":3}`))
+	assert.Equal(t, int64(3), get(t))
+
+	code, _ := do(t, http.MethodPut, "/api/v1/metrics/counter/requests", `{"delta":7,"cumulative":true}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, int64(3), get(t))
+}
+
+func TestContentNegotiation(t *testing.T) {
+	ts := httptest.NewServer(newRouter(newHandlers(&mockMonalert{})))
+	defer ts.Close()
+
+	do := func(t *testing.T, method, path string, header map[string]string, body []byte) (*http.Response, []byte) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, bytes.NewReader(body))
+		require.NoError(t, err)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, data
+	}
+	ids := func(metrics []models.Metrics) []string {
+		var ids []string
+		for _, m := range metrics {
+			ids = append(ids, m.ID)
+		}
+		return ids
+	}
+
+	t.Run("listing", func(t *testing.T) {
+		for _, c := range codec.Default().ContentTypes() {
+			resp, body := do(t, http.MethodGet, "/?sort=id", map[string]string{"Accept": c}, nil)
+			require.Equal(t, http.StatusOK, resp.StatusCode, c)
+			assert.Equal(t, c, resp.Header.Get("Content-Type"))
+			assert.Contains(t, resp.Header.Values("Vary"), "Accept")
+			dec, ok := codec.Default().ForContentType(c)
+			require.True(t, ok)
+			var metrics []models.Metrics
+			require.NoError(t, dec.Decode(bytes.NewReader(body), &metrics), c)
+			assert.Equal(t, []string{"metric1", "metric2"}, ids(metrics), c)
+		}
+	})
+
+	t.Run("browser gets dashboard", func(t *testing.T) {
+		resp, _ := do(t, http.MethodGet, "/", map[string]string{"Accept": "text/html,application/xhtml+xml,*/*;q=0.8"}, nil)
+		assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	})
+
+	t.Run("csv page", func(t *testing.T) {
+		resp, body := do(t, http.MethodGet, "/api/v1/metrics?sort=id&limit=1", map[string]string{"Accept": "text/csv"}, nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "2", resp.Header.Get("X-Total-Count"))
+		assert.Contains(t, resp.Header.Get("Link"), `rel="next"`)
+		assert.True(t, strings.HasPrefix(string(body), "id,type,value,delta\nmetric1,gauge,"), string(body))
+	})
+
+	t.Run("etag per format", func(t *testing.T) {
+		jsonResp, _ := do(t, http.MethodGet, "/api/v1/metrics/gauge/metric1", nil, nil)
+		msgpackResp, _ := do(t, http.MethodGet, "/api/v1/metrics/gauge/metric1", map[string]string{"Accept": "application/msgpack"}, nil)
+		assert.NotEqual(t, jsonResp.Header.Get("ETag"), msgpackResp.Header.Get("ETag"))
+		resp, _ := do(t, http.MethodGet, "/api/v1/metrics/gauge/metric1", map[string]string{
+			"Accept":        "application/msgpack",
+			"If-None-Match": msgpackResp.Header.Get("ETag"),
+		}, nil)
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	})
+
+	t.Run("history", func(t *testing.T) {
+		resp, body := do(t, http.MethodGet, "/metric/gauge/metric1", map[string]string{"Accept": "application/x-protobuf"}, nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var h models.History
+		require.NoError(t, codec.Protobuf{}.Decode(bytes.NewReader(body), &h))
+		assert.Equal(t, "metric1", h.ID)
+		assert.Len(t, h.Samples, 2)
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		resp, body := do(t, http.MethodPost, "/updates/", map[string]string{"Content-Type": "text/csv", "Accept": "*/*"},
+			[]byte("id,type,value,delta\na,gauge,1.5,\nb,counter,,2\n"))
+		require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+		assert.Equal(t, "text/csv", resp.Header.Get("Content-Type"))
+		assert.Equal(t, "id,type,value,delta\na,gauge,1.5,\nb,counter,,2\n", string(body))
+
+		var buf bytes.Buffer
+		value := 1.5
+		require.NoError(t, codec.MessagePack{}.Encode(&buf, []models.Metrics{{ID: "a", MType: "gauge", Value: &value}}))
+		resp, body = do(t, http.MethodPost, "/updates/", map[string]string{
+			"Content-Type": "application/msgpack",
+			"Accept":       "application/json",
+		}, buf.Bytes())
+		require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+		assert.JSONEq(t, `[{"id":"a","type":"gauge","value":1.5}]`, string(body))
+
+		resp, body = do(t, http.MethodPost, "/updates/", map[string]string{"Content-Type": "application/xml"}, []byte("<a/>"))
+		assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+		assert.Contains(t, string(body), "text/csv")
+	})
+}
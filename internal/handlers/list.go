@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"monalert/internal/codec"
+	"monalert/internal/logger"
+	"monalert/internal/models"
+	"monalert/internal/repository"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// maxMatchLength ограничивает длину регулярного выражения в параметре match.
+const maxMatchLength = 1024
+
+// listOptions разбирает параметры выборки: type, prefix, match (регулярное выражение для имени),
+// sort (id или type, с минусом — в обратном порядке), limit и cursor. Лимит по умолчанию —
+// defaultLimit, maxLimit 0 снимает верхнюю границу.
+func listOptions(query url.Values, defaultLimit, maxLimit int) (repository.ListOptions, error) {
+	opts := repository.ListOptions{
+		Type:   query.Get("type"),
+		Prefix: query.Get("prefix"),
+		Limit:  defaultLimit,
+		Cursor: query.Get("cursor"),
+	}
+	if sortOrder := query.Get("sort"); sortOrder != "" {
+		opts.Sort, opts.Desc = strings.CutPrefix(sortOrder, "-")
+	}
+	if match := query.Get("match"); match != "" {
+		if len(match) > maxMatchLength {
+			return opts, repository.NewFieldError("match", repository.ErrInvalidValue, "match is longer than %d bytes", maxMatchLength)
+		}
+		re, err := regexp.Compile(match)
+		if err != nil {
+			return opts, repository.NewFieldError("match", repository.ErrInvalidValue, "invalid regular expression: %v", err)
+		}
+		opts.Match = re
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || (maxLimit > 0 && limit > maxLimit) {
+			if maxLimit > 0 {
+				return opts, repository.NewFieldError("limit", repository.ErrInvalidValue, "limit must be an integer from 1 to %d", maxLimit)
+			}
+			return opts, repository.NewFieldError("limit", repository.ErrInvalidValue, "limit must be a positive integer")
+		}
+		opts.Limit = limit
+	}
+	return opts, opts.Validate()
+}
+
+// rejectSelector отклоняет параметр selector с кодом labels_unsupported, как фильтр labels
+// у /stream: у метрик нет меток, выбирать серии можно только по имени и типу. Молча
+// игнорировать селектор нельзя — клиент принял бы полную выборку за отфильтрованную.
+func rejectSelector(w http.ResponseWriter, r *http.Request) bool {
+	if !r.URL.Query().Has("selector") {
+		return false
+	}
+	writeErrorResponse(w, http.StatusBadRequest, codeLabelsUnsupported, errLabelFilter, "selector")
+	return true
+}
+
+// nextPageQuery — параметры текущего запроса с курсором следующей страницы.
+func nextPageQuery(r *http.Request, cursor string) string {
+	query := r.URL.Query()
+	query.Set("cursor", cursor)
+	return query.Encode()
+}
+
+// streamMetrics пишет выборку в формате c. Без лимита список серий снимается один раз
+// на запрос, а значения читаются порциями и сразу уходят клиенту, так что весь список
+// в памяти не собирается. С лимитом отдаётся
+// одна страница, а ссылка на следующую — в заголовке Link.
+func (h *handlers) streamMetrics(w http.ResponseWriter, r *http.Request, c codec.Codec, opts repository.ListOptions) {
+	if opts.Limit > 0 {
+		page, err := h.monalert.ListMetrics(r.Context(), opts)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if page.Next != "" {
+			w.Header().Set("Link", "<"+r.URL.Path+"?"+nextPageQuery(r, page.Next)+`>; rel="next"`)
+		}
+		bw, list := startList(w, c, len(page.Metrics))
+		for _, m := range page.Metrics {
+			if err := list.Write(m); err != nil {
+				logger.Log.Debug("streamMetrics: write error", zap.Error(err))
+				return
+			}
+		}
+		finishList(bw, list)
+		return
+	}
+	var (
+		bw    *bufio.Writer
+		list  codec.ListWriter
+		total int
+	)
+	count := func(n int) error {
+		total = n
+		return nil
+	}
+	err := h.monalert.WalkMetrics(r.Context(), opts, count, func(m models.Metrics) error {
+		if list == nil {
+			bw, list = startList(w, c, total)
+		}
+		return list.Write(m)
+	})
+	switch {
+	case err != nil && list == nil:
+		// клиенту ещё ничего не отправлено: ошибку можно вернуть кодом
+		writeError(w, err)
+		return
+	case err != nil:
+		// код ответа уже отправлен: обрываем тело, клиент увидит незавершённый список
+		logger.Log.Error("streamMetrics: listing interrupted", zap.Error(err))
+		return
+	case list == nil:
+		bw, list = startList(w, c, total)
+	}
+	finishList(bw, list)
+}
+
+// startList пишет заголовки ответа со списком из n метрик и начинает список.
+func startList(w http.ResponseWriter, c codec.Codec, n int) (*bufio.Writer, codec.ListWriter) {
+	w.Header().Set("Content-Type", c.ContentType())
+	w.Header().Add("Vary", "Accept")
+	bw := bufio.NewWriter(w)
+	return bw, c.NewListWriter(bw, n)
+}
+
+// finishList завершает список и отправляет остаток буфера.
+func finishList(bw *bufio.Writer, list codec.ListWriter) {
+	if err := list.Close(); err != nil {
+		// серии, удалённые во время обхода, не попали в список, длина которого уже отправлена:
+		// остаток буфера не отправляем, клиент увидит незавершённый список
+		if errors.Is(err, codec.ErrListLength) {
+			logger.Log.Warn("streamMetrics: listing changed while streaming", zap.Error(err))
+			return
+		}
+		logger.Log.Debug("streamMetrics: write error", zap.Error(err))
+		return
+	}
+	if err := bw.Flush(); err != nil {
+		logger.Log.Debug("streamMetrics: write error", zap.Error(err))
+	}
+}
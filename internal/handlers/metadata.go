@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+	"monalert/internal/logger"
+	"monalert/internal/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+func (h *handlers) handlePutMetadata(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "metricName")
+	var md models.Metadata
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&md); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if err := h.monalert.SetMetadata(r.Context(), name, md); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *handlers) handleGetMetadata(w http.ResponseWriter, r *http.Request) {
+	md, err := h.monalert.GetMetadata(r.Context(), chi.URLParam(r, "metricName"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, md)
+}
+
+// handleListMetadata отдаёт описания всех метрик в виде объекта имя → описание.
+func (h *handlers) handleListMetadata(w http.ResponseWriter, r *http.Request) {
+	all, err := h.monalert.AllMetadata(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, all)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+	}
+}
+
+// formatWithMetadata форматирует значение для людей с учётом единицы и подсказки отображения.
+// Та же логика повторена в шаблоне index на JavaScript для живого обновления страницы.
+func formatWithMetadata(value float64, md models.Metadata) string {
+	raw := strconv.FormatFloat(value, 'f', -1, 64)
+	switch md.Display {
+	case "raw":
+		return withUnit(raw, md.Unit)
+	case "percent":
+		return strconv.FormatFloat(value*100, 'f', 2, 64) + " %"
+	case "timestamp":
+		if value == 0 {
+			return "—"
+		}
+		if md.Unit == "seconds" {
+			value *= 1e9
+		}
+		return time.Unix(0, int64(value)).UTC().Format(time.DateTime) + " UTC"
+	}
+	switch md.Unit {
+	case "bytes":
+		return formatBytes(value)
+	case "seconds":
+		return formatSeconds(value)
+	case "nanoseconds":
+		return formatSeconds(value / 1e9)
+	default:
+		return withUnit(raw, md.Unit)
+	}
+}
+
+func withUnit(value, unit string) string {
+	if unit == "" {
+		return value
+	}
+	return value + " " + unit
+}
+
+func formatBytes(value float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for math.Abs(value) >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return strconv.FormatFloat(value, 'f', -1, 64) + " B"
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + " " + units[i]
+}
+
+func formatSeconds(value float64) string {
+	abs := math.Abs(value)
+	switch {
+	case abs == 0:
+		return "0 s"
+	case abs < 1e-6:
+		return strconv.FormatFloat(value*1e9, 'f', 0, 64) + " ns"
+	case abs < 1e-3:
+		return strconv.FormatFloat(value*1e6, 'f', 1, 64) + " µs"
+	case abs < 1:
+		return strconv.FormatFloat(value*1e3, 'f', 1, 64) + " ms"
+	default:
+		return strconv.FormatFloat(value, 'f', 2, 64) + " s"
+	}
+}
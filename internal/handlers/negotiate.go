@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"monalert/internal/codec"
+	"monalert/internal/logger"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// responseCodec выбирает формат ответа по Accept. Если клиент не принимает ни один
+// из форматов сервера, ответ всё равно уходит в формате по умолчанию, а не 406:
+// так старые клиенты с Accept вроде text/html продолжают получать JSON.
+func (h *handlers) responseCodec(r *http.Request) codec.Codec {
+	if c, ok := h.codecs.Negotiate(r.Header.Get("Accept")); ok {
+		return c
+	}
+	return h.codecs.Default()
+}
+
+// explicitCodec — формат, явно названный в Accept. Страницы, которые по умолчанию отдают
+// браузеру HTML или текст, по нему понимают, что клиенту нужны данные.
+func (h *handlers) explicitCodec(r *http.Request) (codec.Codec, bool) {
+	return h.codecs.Explicit(r.Header.Get("Accept"))
+}
+
+// requestCodec выбирает формат тела запроса по Content-Type. На неизвестный формат
+// отвечает 415 со списком поддерживаемых и возвращает false.
+func (h *handlers) requestCodec(w http.ResponseWriter, r *http.Request) (codec.Codec, bool) {
+	c, ok := h.codecs.ForContentType(r.Header.Get("Content-Type"))
+	if !ok {
+		writeErrorResponse(w, http.StatusUnsupportedMediaType, codeUnsupportedMediaType,
+			"Content-Type must be one of "+strings.Join(h.codecs.ContentTypes(), ", "), "")
+		return nil, false
+	}
+	return c, true
+}
+
+// writeEncoded отдаёт v в формате c.
+func writeEncoded(w http.ResponseWriter, c codec.Codec, v any) {
+	w.Header().Set("Content-Type", c.ContentType())
+	w.Header().Add("Vary", "Accept")
+	if err := c.Encode(w, v); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+	}
+}
+
+// writeWithETag отдаёт v в формате c с ETag от содержимого. Если клиент прислал
+// If-None-Match с тем же тегом, тело не передаётся: ответ 304.
+// Тег слабый, потому что при сжатии ответа байты на проводе меняются. Разные форматы
+// дают разные байты, поэтому и теги у них разные.
+func writeWithETag(w http.ResponseWriter, r *http.Request, c codec.Codec, v any) {
+	var buf bytes.Buffer
+	if err := c.Encode(&buf, v); err != nil {
+		writeError(w, err)
+		return
+	}
+	data := buf.Bytes()
+	sum := sha256.Sum256(data)
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Add("Vary", "Accept")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", c.ContentType())
+	if _, err := w.Write(data); err != nil {
+		logger.Log.Error("error writing response", zap.Error(err))
+	}
+}
+
+// etagMatches сравнивает теги из If-None-Match слабым сравнением (RFC 9110, 13.1.2).
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"monalert/internal/logger"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// openAPISpec — описание API в формате OpenAPI 3. Его же проверяют контрактные тесты,
+// так что при изменении маршрутов спецификацию нужно править вместе с кодом.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// docsPage собирается из спецификации один раз при старте: с испорченной спецификацией
+// пакет не инициализируется, и это сразу видно в тестах.
+var docsPage = mustDocsPage(openAPISpec)
+
+// openAPIMethods задаёт порядок операций одного пути на странице документации.
+var openAPIMethods = []string{"get", "put", "post", "delete"}
+
+// openAPIDocument — часть спецификации, которая нужна странице /docs.
+type openAPIDocument struct {
+	Info struct {
+		Title       string `json:"title"`
+		Version     string `json:"version"`
+		Description string `json:"description"`
+	} `json:"info"`
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Parameters map[string]openAPIParameter `json:"parameters"`
+		Responses  map[string]openAPIResponse  `json:"responses"`
+		Schemas    map[string]openAPISchema    `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	Tags        []string           `json:"tags"`
+	Summary     string             `json:"summary"`
+	Description string             `json:"description"`
+	Deprecated  bool               `json:"deprecated"`
+	Parameters  []openAPIParameter `json:"parameters"`
+	RequestBody *struct {
+		Content map[string]openAPIMedia `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Ref         string `json:"$ref"`
+	Name        string `json:"name"`
+	In          string `json:"in"`
+	Description string `json:"description"`
+	Required    bool   `json:"required"`
+}
+
+type openAPIResponse struct {
+	Ref         string                  `json:"$ref"`
+	Description string                  `json:"description"`
+	Content     map[string]openAPIMedia `json:"content"`
+}
+
+type openAPIMedia struct {
+	Schema openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Ref         string                   `json:"$ref"`
+	Type        string                   `json:"type"`
+	Description string                   `json:"description"`
+	Enum        []string                 `json:"enum"`
+	Items       *openAPISchema           `json:"items"`
+	Properties  map[string]openAPISchema `json:"properties"`
+	Required    []string                 `json:"required"`
+}
+
+type docsData struct {
+	Title       string
+	Version     string
+	Description string
+	Operations  []docsOperation
+	Schemas     []docsSchema
+}
+
+type docsOperation struct {
+	Anchor      string
+	Method      string
+	Path        string
+	Tag         string
+	Summary     string
+	Description string
+	Deprecated  bool
+	Params      []openAPIParameter
+	Body        string
+	Responses   []docsResponse
+}
+
+type docsResponse struct {
+	Status      string
+	Description string
+	Body        string
+}
+
+type docsSchema struct {
+	Name   string
+	Type   string
+	Fields []docsField
+}
+
+type docsField struct {
+	Name        string
+	Type        string
+	Required    bool
+	Description string
+}
+
+func (h *handlers) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(openAPISpec); err != nil {
+		logger.Log.Error("handleOpenAPI: write error", zap.Error(err))
+	}
+}
+
+func (h *handlers) handleDocs(w http.ResponseWriter, r *http.Request) {
+	h.renderTemplate(w, "docs", docsPage)
+}
+
+func mustDocsPage(spec []byte) docsData {
+	page, err := newDocsPage(spec)
+	if err != nil {
+		panic(fmt.Sprintf("handlers: invalid openapi.json: %v", err))
+	}
+	return page
+}
+
+// newDocsPage готовит данные страницы: операции по путям в порядке openAPIMethods,
+// ссылки $ref на общие параметры и ответы раскрыты.
+func newDocsPage(spec []byte) (docsData, error) {
+	var doc openAPIDocument
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return docsData{}, err
+	}
+	page := docsData{
+		Title:       doc.Info.Title,
+		Version:     doc.Info.Version,
+		Description: doc.Info.Description,
+	}
+	paths := make([]string, 0, len(doc.Paths))
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		item := doc.Paths[path]
+		var common []openAPIParameter
+		if raw, ok := item["parameters"]; ok {
+			if err := json.Unmarshal(raw, &common); err != nil {
+				return docsData{}, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+		for _, method := range openAPIMethods {
+			raw, ok := item[method]
+			if !ok {
+				continue
+			}
+			var op openAPIOperation
+			if err := json.Unmarshal(raw, &op); err != nil {
+				return docsData{}, fmt.Errorf("%s %s: %w", method, path, err)
+			}
+			entry, err := doc.docsOperation(method, path, append(append([]openAPIParameter(nil), common...), op.Parameters...), op)
+			if err != nil {
+				return docsData{}, err
+			}
+			page.Operations = append(page.Operations, entry)
+		}
+	}
+	names := make([]string, 0, len(doc.Components.Schemas))
+	for name := range doc.Components.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		schema := doc.Components.Schemas[name]
+		entry := docsSchema{Name: name, Type: schemaName(schema)}
+		fields := make([]string, 0, len(schema.Properties))
+		for field := range schema.Properties {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			prop := schema.Properties[field]
+			entry.Fields = append(entry.Fields, docsField{
+				Name:        field,
+				Type:        schemaName(prop),
+				Required:    slices.Contains(schema.Required, field),
+				Description: prop.Description,
+			})
+		}
+		page.Schemas = append(page.Schemas, entry)
+	}
+	return page, nil
+}
+
+func (doc *openAPIDocument) docsOperation(method, path string, params []openAPIParameter, op openAPIOperation) (docsOperation, error) {
+	entry := docsOperation{
+		Anchor:      method + strings.NewReplacer("/", "-", "{", "", "}", "", ".", "-").Replace(path),
+		Method:      strings.ToUpper(method),
+		Path:        path,
+		Summary:     op.Summary,
+		Description: op.Description,
+		Deprecated:  op.Deprecated,
+	}
+	if len(op.Tags) > 0 {
+		entry.Tag = op.Tags[0]
+	}
+	for _, p := range params {
+		if p.Ref != "" {
+			resolved, ok := doc.Components.Parameters[refName(p.Ref)]
+			if !ok {
+				return docsOperation{}, fmt.Errorf("%s %s: unknown parameter %s", method, path, p.Ref)
+			}
+			p = resolved
+		}
+		entry.Params = append(entry.Params, p)
+	}
+	if op.RequestBody != nil {
+		entry.Body = contentSummary(op.RequestBody.Content)
+	}
+	statuses := make([]string, 0, len(op.Responses))
+	for status := range op.Responses {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		resp := op.Responses[status]
+		if resp.Ref != "" {
+			resolved, ok := doc.Components.Responses[refName(resp.Ref)]
+			if !ok {
+				return docsOperation{}, fmt.Errorf("%s %s: unknown response %s", method, path, resp.Ref)
+			}
+			resp = resolved
+		}
+		entry.Responses = append(entry.Responses, docsResponse{
+			Status:      status,
+			Description: resp.Description,
+			Body:        contentSummary(resp.Content),
+		})
+	}
+	return entry, nil
+}
+
+// contentSummary описывает тело одной строкой: "application/json: Metric, text/html: string".
+func contentSummary(content map[string]openAPIMedia) string {
+	types := make([]string, 0, len(content))
+	for contentType := range content {
+		types = append(types, contentType)
+	}
+	sort.Strings(types)
+	parts := make([]string, 0, len(types))
+	for _, contentType := range types {
+		parts = append(parts, contentType+": "+schemaName(content[contentType].Schema))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func schemaName(s openAPISchema) string {
+	switch {
+	case s.Ref != "":
+		return refName(s.Ref)
+	case s.Type == "array" && s.Items != nil:
+		return "[]" + schemaName(*s.Items)
+	case len(s.Enum) > 0:
+		return s.Type + " (" + strings.Join(s.Enum, ", ") + ")"
+	default:
+		return s.Type
+	}
+}
+
+// refName возвращает имя компонента из ссылки вида #/components/schemas/Metric.
+func refName(ref string) string {
+	return ref[strings.LastIndex(ref, "/")+1:]
+}
@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"monalert/internal/hub"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// contractSpec — спецификация в виде дерева JSON: числа разобраны как json.Number,
+// чтобы отличать integer от number.
+type contractSpec map[string]any
+
+func loadContractSpec(t *testing.T) contractSpec {
+	t.Helper()
+	dec := json.NewDecoder(bytes.NewReader(openAPISpec))
+	dec.UseNumber()
+	var spec contractSpec
+	require.NoError(t, dec.Decode(&spec))
+	return spec
+}
+
+func (s contractSpec) paths() map[string]any {
+	return s["paths"].(map[string]any)
+}
+
+// resolve раскрывает ссылку $ref на компонент спецификации.
+func (s contractSpec) resolve(node map[string]any) map[string]any {
+	for {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			return node
+		}
+		cur := any(map[string]any(s))
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			cur = cur.(map[string]any)[part]
+		}
+		node = cur.(map[string]any)
+	}
+}
+
+// matchPath находит шаблон пути спецификации для пути запроса. Параметр {x}
+// совпадает с любым непустым сегментом, буквальные пути имеют приоритет.
+func (s contractSpec) matchPath(path string) (string, bool) {
+	segments := strings.Split(path, "/")
+	var best string
+	bestParams := -1
+	for tmpl := range s.paths() {
+		parts := strings.Split(tmpl, "/")
+		if len(parts) != len(segments) {
+			continue
+		}
+		params := 0
+		ok := true
+		for i, part := range parts {
+			if strings.HasPrefix(part, "{") {
+				params++
+				ok = ok && segments[i] != ""
+				continue
+			}
+			ok = ok && part == segments[i]
+		}
+		if ok && (bestParams < 0 || params < bestParams) {
+			best, bestParams = tmpl, params
+		}
+	}
+	return best, bestParams >= 0
+}
+
+// validate проверяет значение по схеме. Поддержано подмножество JSON Schema,
+// которое используется в openapi.json: type, nullable, enum, format date-time,
+// properties, required, additionalProperties и items.
+func (s contractSpec) validate(schema map[string]any, v any, at string) []string {
+	schema = s.resolve(schema)
+	if v == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable {
+			return nil
+		}
+		if _, typed := schema["type"]; typed {
+			return []string{at + ": null is not allowed"}
+		}
+		return nil
+	}
+	var errs []string
+	switch schema["type"] {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: want object, got %T", at, v)}
+		}
+		props, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: missing required property %q", at, name))
+			}
+		}
+		for name, value := range obj {
+			if prop, ok := props[name]; ok {
+				errs = append(errs, s.validate(prop.(map[string]any), value, at+"."+name)...)
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					errs = append(errs, fmt.Sprintf("%s: unexpected property %q", at, name))
+				}
+			case map[string]any:
+				errs = append(errs, s.validate(extra, value, at+"."+name)...)
+			}
+		}
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: want array, got %T", at, v)}
+		}
+		for i, item := range arr {
+			errs = append(errs, s.validate(schema["items"].(map[string]any), item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s: want string, got %T", at, v)}
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %q is not date-time", at, str))
+			}
+		}
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return []string{fmt.Sprintf("%s: want integer, got %T", at, v)}
+		}
+		if _, err := n.Int64(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s is not an integer", at, n))
+		}
+	case "number":
+		if _, ok := v.(json.Number); !ok {
+			return []string{fmt.Sprintf("%s: want number, got %T", at, v)}
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return []string{fmt.Sprintf("%s: want boolean, got %T", at, v)}
+		}
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			found = found || e == v
+		}
+		if !found {
+			errs = append(errs, fmt.Sprintf("%s: %v is not one of %v", at, v, enum))
+		}
+	}
+	return errs
+}
+
+// checkResponse проверяет, что код ответа описан в операции, а тело соответствует схеме
+// для его Content-Type.
+func (s contractSpec) checkResponse(t *testing.T, method, tmpl string, status int, contentType string, body []byte) {
+	t.Helper()
+	op, ok := s.paths()[tmpl].(map[string]any)[strings.ToLower(method)].(map[string]any)
+	require.True(t, ok, "operation %s %s is not documented", method, tmpl)
+	responses := op["responses"].(map[string]any)
+	raw, ok := responses[strconv.Itoa(status)].(map[string]any)
+	require.True(t, ok, "status %d of %s %s is not documented", status, method, tmpl)
+	resp := s.resolve(raw)
+	content, ok := resp["content"].(map[string]any)
+	if !ok {
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	require.NoError(t, err, "response Content-Type %q", contentType)
+	media, ok := content[mediaType].(map[string]any)
+	require.True(t, ok, "content type %s of %s %s %d is not documented", mediaType, method, tmpl, status)
+	if mediaType != "application/json" {
+		return
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	require.NoError(t, dec.Decode(&v), "response body is not JSON: %s", body)
+	errs := s.validate(media["schema"].(map[string]any), v, "body")
+	assert.Empty(t, errs, "%s %s %d: %s", method, tmpl, status, body)
+}
+
+func TestOpenAPIContract(t *testing.T) {
+	spec := loadContractSpec(t)
+
+	h := newHandlers(&mockMonalert{hub: hub.New(16, 16)})
+	ts := httptest.NewServer(newRouter(h))
+	defer ts.Close()
+	authed := newHandlers(&mockMonalert{hub: hub.New(16, 16)})
+	authed.auth = testTokens(t)
+	authTS := httptest.NewServer(newRouter(authed))
+	defer authTS.Close()
+
+	jsonBody := map[string]string{"Content-Type": "application/json"}
+	acceptJSON := map[string]string{"Accept": "application/json"}
+	acceptCSV := map[string]string{"Accept": "text/csv"}
+	csvBody := map[string]string{"Content-Type": "text/csv"}
+	tests := []struct {
+		name   string
+		server *httptest.Server
+		method string
+		url    string
+		header map[string]string
+		body   string
+		want   int
+	}{
+		{name: "metrics json", method: http.MethodGet, url: "/", header: acceptJSON, want: http.StatusOK},
+		{name: "metrics json filtered", method: http.MethodGet, url: "/?type=counter&match=^metric[0-9]$&sort=-type&limit=1", header: acceptJSON, want: http.StatusOK},
+		{name: "metrics json invalid match", method: http.MethodGet, url: "/?match=(", header: acceptJSON, want: http.StatusBadRequest},
+		{name: "metrics json selector", method: http.MethodGet, url: "/?selector=env%3Dprod", header: acceptJSON, want: http.StatusBadRequest},
+		{name: "metrics csv", method: http.MethodGet, url: "/?sort=id", header: acceptCSV, want: http.StatusOK},
+		{name: "metrics protobuf", method: http.MethodGet, url: "/", header: map[string]string{"Accept": "application/x-protobuf"}, want: http.StatusOK},
+		{name: "dashboard", method: http.MethodGet, url: "/", want: http.StatusOK},
+		{name: "no token", server: authTS, method: http.MethodGet, url: "/", want: http.StatusUnauthorized},
+		{name: "value json", method: http.MethodPost, url: "/value/", header: jsonBody, body: `{"id":"metric1","type":"gauge"}`, want: http.StatusOK},
+		{name: "value json unknown type", method: http.MethodPost, url: "/value/", header: jsonBody, body: `{"id":"metric1","type":"summary"}`, want: http.StatusNotFound},
+		{name: "value json broken", method: http.MethodPost, url: "/value/", header: jsonBody, body: `{`, want: http.StatusBadRequest},
+		{name: "value msgpack", method: http.MethodPost, url: "/value/", header: map[string]string{"Content-Type": "application/json", "Accept": "application/msgpack"}, body: `{"id":"metric1","type":"gauge"}`, want: http.StatusOK},
+		{name: "delete by prefix", method: http.MethodDelete, url: "/value/?prefix=node.", want: http.StatusOK},
+		{name: "delete without prefix", method: http.MethodDelete, url: "/value/", want: http.StatusBadRequest},
+		{name: "value", method: http.MethodGet, url: "/value/gauge/metric1", want: http.StatusOK},
+		{name: "value unknown type", method: http.MethodGet, url: "/value/summary/metric1", want: http.StatusNotFound},
+		{name: "value csv", method: http.MethodGet, url: "/value/gauge/metric1", header: acceptCSV, want: http.StatusOK},
+		{name: "delete", method: http.MethodDelete, url: "/value/gauge/metric1", want: http.StatusOK},
+		{name: "delete missing", method: http.MethodDelete, url: "/value/gauge/missing", want: http.StatusNotFound},
+		{name: "history json", method: http.MethodGet, url: "/metric/gauge/metric1", header: acceptJSON, want: http.StatusOK},
+		{name: "history page", method: http.MethodGet, url: "/metric/gauge/metric1", want: http.StatusOK},
+		{name: "history csv", method: http.MethodGet, url: "/metric/gauge/metric1", header: acceptCSV, want: http.StatusOK},
+		{name: "history unknown type", method: http.MethodGet, url: "/metric/summary/metric1", header: acceptJSON, want: http.StatusNotFound},
+		{name: "stream bad last event id", method: http.MethodGet, url: "/stream", header: map[string]string{"Last-Event-ID": "x"}, want: http.StatusBadRequest},
+		{name: "all metadata", method: http.MethodGet, url: "/meta/", want: http.StatusOK},
+		{name: "metadata", method: http.MethodGet, url: "/meta/metric1", want: http.StatusOK},
+		{name: "metadata missing", method: http.MethodGet, url: "/meta/missing", want: http.StatusNotFound},
+		{name: "put metadata", method: http.MethodPut, url: "/meta/metric1", header: jsonBody, body: `{"unit":"bytes","display":"raw"}`, want: http.StatusOK},
+		{name: "put metadata invalid", method: http.MethodPut, url: "/meta/metric1", header: jsonBody, body: `{"display":"fancy"}`, want: http.StatusBadRequest},
+		{name: "put metadata reader", server: authTS, method: http.MethodPut, url: "/meta/metric1", header: map[string]string{"Authorization": "Bearer reader-secret"}, body: `{}`, want: http.StatusForbidden},
+		{name: "prometheus", method: http.MethodGet, url: "/metrics", want: http.StatusOK},
+		{name: "batch", method: http.MethodPost, url: "/updates/", header: jsonBody, body: `[{"id":"a","type":"gauge","value":1.5},{"id":"b","type":"counter","delta":2}]`, want: http.StatusOK},
+		{name: "batch invalid", method: http.MethodPost, url: "/updates/", header: jsonBody, body: `[{"id":"a","type":"gauge"}]`, want: http.StatusBadRequest},
+		{name: "batch not json", method: http.MethodPost, url: "/updates/", body: `[]`, want: http.StatusUnsupportedMediaType},
+		{name: "batch csv", method: http.MethodPost, url: "/updates/", header: csvBody, body: "id,type,value,delta\na,gauge,1.5,\nb,counter,,2\n", want: http.StatusOK},
+		{name: "batch csv unknown column", method: http.MethodPost, url: "/updates/", header: csvBody, body: "id,type,unit\na,gauge,bytes\n", want: http.StatusBadRequest},
+		{name: "batch unsupported format", method: http.MethodPost, url: "/updates/", header: map[string]string{"Content-Type": "text/plain"}, body: `a`, want: http.StatusUnsupportedMediaType},
+		{name: "update", method: http.MethodPost, url: "/update/", header: jsonBody, body: `{"id":"a","type":"counter","delta":3}`, want: http.StatusOK},
+		{name: "update invalid", method: http.MethodPost, url: "/update/", header: jsonBody, body: `{"id":"a","type":"summary"}`, want: http.StatusBadRequest},
+		{name: "update not json", method: http.MethodPost, url: "/update/", body: `{}`, want: http.StatusUnsupportedMediaType},
+		{name: "update over limit", method: http.MethodPost, url: "/update/", header: jsonBody, body: `{"id":"overflow","type":"gauge","value":1}`, want: http.StatusUnprocessableEntity},
+		{name: "update url", method: http.MethodPost, url: "/update/gauge/a/1.5", want: http.StatusOK},
+		{name: "update url cumulative", method: http.MethodPost, url: "/update/counter/a/10?cumulative=true", want: http.StatusOK},
+		{name: "update url invalid", method: http.MethodPost, url: "/update/gauge/a/abc", want: http.StatusBadRequest},
+		{name: "update url without value", method: http.MethodPost, url: "/update/gauge/a", want: http.StatusBadRequest},
+		{name: "cardinality", method: http.MethodGet, url: "/admin/cardinality?depth=2&top=5", want: http.StatusOK},
+		{name: "cardinality invalid", method: http.MethodGet, url: "/admin/cardinality?depth=0", want: http.StatusBadRequest},
+		{name: "v1 list", method: http.MethodGet, url: "/api/v1/metrics?type=gauge&sort=-id&limit=1", want: http.StatusOK},
+		{name: "v1 list not modified", method: http.MethodGet, url: "/api/v1/metrics", header: map[string]string{"If-None-Match": "*"}, want: http.StatusNotModified},
+		{name: "v1 list invalid cursor", method: http.MethodGet, url: "/api/v1/metrics?cursor=garbage", want: http.StatusBadRequest},
+		{name: "v1 list invalid sort", method: http.MethodGet, url: "/api/v1/metrics?sort=value", want: http.StatusBadRequest},
+		{name: "v1 list csv", method: http.MethodGet, url: "/api/v1/metrics?limit=1", header: acceptCSV, want: http.StatusOK},
+		{name: "v1 get", method: http.MethodGet, url: "/api/v1/metrics/counter/metric2", want: http.StatusOK},
+		{name: "v1 get not modified", method: http.MethodGet, url: "/api/v1/metrics/counter/metric2", header: map[string]string{"If-None-Match": "*"}, want: http.StatusNotModified},
+		{name: "v1 get missing", method: http.MethodGet, url: "/api/v1/metrics/gauge/missing", want: http.StatusNotFound},
+		{name: "v1 get msgpack", method: http.MethodGet, url: "/api/v1/metrics/counter/metric2", header: map[string]string{"Accept": "application/vnd.msgpack"}, want: http.StatusOK},
+		{name: "v1 put", method: http.MethodPut, url: "/api/v1/metrics/gauge/metric1", header: jsonBody, body: `{"value":1.5}`, want: http.StatusOK},
+		{name: "v1 put new", method: http.MethodPut, url: "/api/v1/metrics/counter/missing1", header: jsonBody, body: `{"delta":1}`, want: http.StatusCreated},
+		{name: "v1 put id mismatch", method: http.MethodPut, url: "/api/v1/metrics/gauge/metric1", header: jsonBody, body: `{"id":"other","value":1.5}`, want: http.StatusBadRequest},
+		{name: "v1 put not json", method: http.MethodPut, url: "/api/v1/metrics/gauge/metric1", body: `{"value":1.5}`, want: http.StatusUnsupportedMediaType},
+		{name: "v1 batch", method: http.MethodPost, url: "/api/v1/metrics:batch", header: jsonBody, body: `[{"id":"a","type":"gauge","value":1.5}]`, want: http.StatusNoContent},
+		{name: "v1 batch invalid", method: http.MethodPost, url: "/api/v1/metrics:batch", header: jsonBody, body: `[{"id":"a","type":"gauge"}]`, want: http.StatusBadRequest},
+		{name: "v1 batch csv", method: http.MethodPost, url: "/api/v1/metrics:batch", header: csvBody, body: "id,type,value\na,gauge,1.5\n", want: http.StatusNoContent},
+		{name: "ws without handshake", method: http.MethodGet, url: "/ws", want: http.StatusBadRequest},
+		{name: "openapi", server: authTS, method: http.MethodGet, url: "/openapi.json", want: http.StatusOK},
+		{name: "docs", server: authTS, method: http.MethodGet, url: "/docs", want: http.StatusOK},
+	}
+
+	covered := make(map[string]bool)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := tt.server
+			if server == nil {
+				server = ts
+			}
+			req, err := http.NewRequest(tt.method, server.URL+tt.url, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			resp, err := server.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, resp.StatusCode, "%s", body)
+
+			tmpl, ok := spec.matchPath(req.URL.Path)
+			require.True(t, ok, "path %s is not documented", req.URL.Path)
+			spec.checkResponse(t, tt.method, tmpl, resp.StatusCode, resp.Header.Get("Content-Type"), body)
+			covered[tt.method+" "+tmpl] = true
+		})
+	}
+
+	t.Run("stream", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/stream?type=gauge&prefix=Heap", http.NoBody)
+		require.NoError(t, err)
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		// поток бесконечный, проверяем только код и тип содержимого
+		spec.checkResponse(t, http.MethodGet, "/stream", resp.StatusCode, resp.Header.Get("Content-Type"), nil)
+		covered["GET /stream"] = true
+	})
+
+	t.Run("ws", func(t *testing.T) {
+		conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		resp.Body.Close()
+		spec.checkResponse(t, http.MethodGet, "/ws", resp.StatusCode, resp.Header.Get("Content-Type"), nil)
+		covered["GET /ws"] = true
+	})
+
+	// каждая описанная операция должна быть проверена хотя бы одним запросом
+	for path, item := range spec.paths() {
+		for method := range item.(map[string]any) {
+			if method == "parameters" {
+				continue
+			}
+			op := strings.ToUpper(method) + " " + path
+			assert.True(t, covered[op], "documented operation %s is not exercised by the contract test", op)
+		}
+	}
+}
+
+// TestOpenAPIRoutes сверяет маршруты роутера со спецификацией в обе стороны.
+func TestOpenAPIRoutes(t *testing.T) {
+	spec := loadContractSpec(t)
+	var documented []string
+	for path, item := range spec.paths() {
+		for method := range item.(map[string]any) {
+			if method != "parameters" {
+				documented = append(documented, strings.ToUpper(method)+" "+path)
+			}
+		}
+	}
+	sort.Strings(documented)
+
+	var routed []string
+	err := chi.Walk(newRouter(newHandlers(&mockMonalert{})), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		routed = append(routed, method+" "+route)
+		return nil
+	})
+	require.NoError(t, err)
+	sort.Strings(routed)
+
+	assert.Equal(t, documented, routed)
+}
+
+func TestDocsPage(t *testing.T) {
+	ts := httptest.NewServer(newRouter(newHandlers(&mockMonalert{})))
+	defer ts.Close()
+	resp, body := testRequest(t, ts, http.MethodGet, "/docs")
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "/update/{metricType}/{metricName}/{metricValue}")
+	assert.Contains(t, body, "Metric with its current value.")
+	assert.Contains(t, body, "[]Metric")
+	for _, op := range docsPage.Operations {
+		assert.Contains(t, body, `id="`+op.Anchor+`"`)
+	}
+}
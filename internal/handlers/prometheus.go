@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"bufio"
+	"monalert/internal/logger"
+	"monalert/internal/models"
+	"monalert/internal/service"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// handlePrometheus отдаёт все метрики в текстовом формате экспозиции Prometheus.
+// Описания из реестра метаданных попадают в строки # HELP. Метрики сервера отдаются
+// семействами с настоящими метками, гистограммы — с типом histogram, а не развёрнутыми
+// в имена сериями, как в API чтения.
+func (h *handlers) handlePrometheus(w http.ResponseWriter, r *http.Request) {
+	metrics, err := h.monalert.GetAllMetrics(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	metadata, err := h.monalert.AllMetadata(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].ID != metrics[j].ID {
+			return metrics[i].ID < metrics[j].ID
+		}
+		return metrics[i].MType < metrics[j].MType
+	})
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	seen := make(map[string]bool, len(metrics))
+	for _, family := range h.monalert.SelfMetricFamilies() {
+		writePromFamily(bw, family)
+		seen[family.Name] = true
+		for _, series := range family.Series {
+			seen[family.Name+series.Suffix] = true
+		}
+	}
+	for _, m := range metrics {
+		if models.IsReservedName(m.ID) {
+			continue
+		}
+		name := promName(m.ID)
+		if seen[name] {
+			// разные исходные имена могут дать одно имя Prometheus, повтор семейства недопустим
+			logger.Log.Debug("duplicate prometheus metric name", zap.String("id", m.ID), zap.String("name", name))
+			continue
+		}
+		seen[name] = true
+		var value string
+		switch {
+		case m.Value != nil:
+			value = strconv.FormatFloat(*m.Value, 'g', -1, 64)
+		case m.Delta != nil:
+			value = strconv.FormatInt(*m.Delta, 10)
+		default:
+			continue
+		}
+		if help := metadata[m.ID].Help; help != "" {
+			bw.WriteString("# HELP " + name + " " + promEscapeHelp(help) + "\n")
+		}
+		bw.WriteString("# TYPE " + name + " " + m.MType + "\n")
+		bw.WriteString(name + " " + value + "\n")
+	}
+	if err := bw.Flush(); err != nil {
+		logger.Log.Error("error writing prometheus response", zap.Error(err))
+	}
+}
+
+// writePromFamily пишет семейство метрик сервера с метками в {}.
+func writePromFamily(bw *bufio.Writer, family service.Family) {
+	if family.Help != "" {
+		bw.WriteString("# HELP " + family.Name + " " + promEscapeHelp(family.Help) + "\n")
+	}
+	bw.WriteString("# TYPE " + family.Name + " " + family.Type + "\n")
+	for _, series := range family.Series {
+		bw.WriteString(family.Name + series.Suffix)
+		for i, label := range series.Labels {
+			if i == 0 {
+				bw.WriteByte('{')
+			} else {
+				bw.WriteByte(',')
+			}
+			bw.WriteString(label.Name + `="` + promEscapeLabel(label.Value) + `"`)
+		}
+		if len(series.Labels) > 0 {
+			bw.WriteByte('}')
+		}
+		bw.WriteString(" " + strconv.FormatFloat(series.Value, 'g', -1, 64) + "\n")
+	}
+}
+
+// promName приводит имя метрики к допустимому в Prometheus: [a-zA-Z_:][a-zA-Z0-9_:]*.
+func promName(id string) string {
+	var b strings.Builder
+	for i, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+func promEscapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func promEscapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxBodyBytes         = 1 << 20
+	defaultMaxDecompressedBytes = 8 << 20
+	defaultMaxBatch             = 1000
+	// limiterIdleTTL — через сколько простоя корзина клиента удаляется
+	limiterIdleTTL = 10 * time.Minute
+)
+
+// Limits — ограничения на приём обновлений. Нулевые значения заменяются значениями по умолчанию,
+// кроме Rate: при Rate == 0 ограничение частоты выключено.
+type Limits struct {
+	Rate                 float64 // запросов в секунду на клиента
+	Burst                int     // сколько запросов клиент может сделать подряд
+	MaxBodyBytes         int64   // размер тела запроса как он пришёл по сети
+	MaxDecompressedBytes int64   // размер тела после распаковки gzip
+	MaxBatch             int     // сколько метрик можно передать в одном батче
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.Burst <= 0 {
+		l.Burst = int(math.Max(1, math.Ceil(l.Rate)))
+	}
+	if l.MaxBodyBytes <= 0 {
+		l.MaxBodyBytes = defaultMaxBodyBytes
+	}
+	if l.MaxDecompressedBytes <= 0 {
+		l.MaxDecompressedBytes = defaultMaxDecompressedBytes
+	}
+	if l.MaxBatch <= 0 {
+		l.MaxBatch = defaultMaxBatch
+	}
+	return l
+}
+
+// rateLimiter — token bucket на каждого клиента.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mux       sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// allow списывает токен из корзины клиента. Если токенов нет, возвращает,
+// через сколько появится следующий.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	now := l.now()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep удаляет корзины давно не появлявшихся клиентов, чтобы карта не росла бесконечно.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < limiterIdleTTL {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > limiterIdleTTL {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// clientKey определяет клиента для лимита: имя токена, если запрос аутентифицирован,
+// иначе IP-адрес соединения. X-Real-IP не используется, его легко подделать.
+func clientKey(r *http.Request) string {
+	if info := requestInfoFrom(r.Context()); info != nil && info.tokenName != "" {
+		return "token:" + info.tokenName
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// retryAfterSeconds округляет ожидание вверх до целых секунд для заголовка Retry-After.
+func retryAfterSeconds(wait time.Duration) string {
+	return strconv.Itoa(int(math.Max(1, math.Ceil(wait.Seconds()))))
+}
+
+// rateLimit отвечает 429, когда клиент превысил свою частоту запросов.
+func (h *handlers) rateLimit(next http.Handler) http.Handler {
+	if h.limiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := h.limiter.allow(clientKey(r)); !ok {
+			w.Header().Set("Retry-After", retryAfterSeconds(wait))
+			writeErrorResponse(w, http.StatusTooManyRequests, codeRateLimited, "too many requests", "")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitBody ограничивает размер тела запроса до распаковки.
+func limitBody(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isBodyTooLarge сообщает, что чтение тела упёрлось в лимит размера.
+func isBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"monalert/internal/logger"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// signatureHeader — заголовок с HMAC-SHA256 запроса в hex, см. signedData.
+const signatureHeader = "HashSHA256"
+
+// verifySignature пропускает только запросы с верной подписью метода, пути и тела.
+// Стоит после gzipMiddleware, поэтому проверяется уже распакованное тело. Пустой ключ проверку отключает.
+func verifySignature(key string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if key == "" {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, err := hex.DecodeString(r.Header.Get(signatureHeader))
+			if err != nil || len(got) == 0 {
+				logger.Log.Debug("request without valid signature", zap.String("path", r.URL.Path))
+				writeErrorResponse(w, http.StatusBadRequest, codeInvalidSignature, "missing or malformed signature", signatureHeader)
+				return
+			}
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				if isBodyTooLarge(err) {
+					writeErrorResponse(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge, "request body is too large", "")
+					return
+				}
+				writeErrorResponse(w, http.StatusBadRequest, codeInvalidRequest, "cannot read body", "")
+				return
+			}
+			if !hmac.Equal(got, sign(key, signedData(r.Method, requestURI(r), body))) {
+				logger.Log.Debug("request with invalid signature", zap.String("path", r.URL.Path))
+				writeErrorResponse(w, http.StatusBadRequest, codeInvalidSignature, "invalid signature", signatureHeader)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// signedData — что покрывает подпись запроса: "METHOD /path?query\n" и несжатое тело.
+// Без метода и пути запросы /update/{type}/{name}/{value} с пустым телом имели бы
+// одну подпись на все метрики и значения. Формат повторяет client.SignRequest.
+func signedData(method, uri string, body []byte) []byte {
+	data := make([]byte, 0, len(method)+len(uri)+2+len(body))
+	data = append(data, method...)
+	data = append(data, ' ')
+	data = append(data, uri...)
+	data = append(data, '\n')
+	return append(data, body...)
+}
+
+// requestURI — путь с параметрами в том виде, в каком его прислал клиент.
+func requestURI(r *http.Request) string {
+	if r.RequestURI != "" {
+		return r.RequestURI
+	}
+	return r.URL.RequestURI()
+}
+
+func sign(key string, data []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(data)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"monalert/internal/hub"
+	"monalert/internal/logger"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// streamKeepAlive — как часто слать комментарий, чтобы прокси не закрыли простаивающее соединение.
+const streamKeepAlive = 15 * time.Second
+
+// handleStream отдаёт поток принятых обновлений метрик в формате Server-Sent Events.
+// Параметры type и prefix фильтруют события, заголовок Last-Event-ID возобновляет поток.
+// Фильтр labels отклоняется явно, а не игнорируется, чтобы клиент не получил лишние события.
+func (h *handlers) handleStream(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("labels") {
+		writeErrorResponse(w, http.StatusBadRequest, codeLabelsUnsupported, errLabelFilter, "labels")
+		return
+	}
+	filter := hub.Filter{
+		Type:   r.URL.Query().Get("type"),
+		Prefix: r.URL.Query().Get("prefix"),
+	}
+	var lastEventID uint64
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, codeInvalidRequest, "invalid Last-Event-ID", "Last-Event-ID")
+			return
+		}
+		lastEventID = id
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		logger.Log.Error("stream: response writer does not support flush", zap.Error(err))
+		return
+	}
+
+	sub := h.monalert.Subscribe(filter, lastEventID)
+	defer sub.Close()
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case ev, ok := <-sub.Events():
+			if !ok {
+				// хаб отключил нас как медленного подписчика
+				return
+			}
+			data, err := json.Marshal(ev.Metric)
+			if err != nil {
+				logger.Log.Error("stream: cannot marshal event", zap.Error(err))
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: update\ndata: %s\n\n", ev.ID, data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"fmt"
+	"monalert/internal/logger"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// ParseTrustedSubnets разбирает список подсетей в нотации CIDR через запятую.
+func ParseTrustedSubnets(v string) ([]netip.Prefix, error) {
+	var subnets []netip.Prefix
+	for _, s := range strings.Split(v, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted subnet %q: %w", s, err)
+		}
+		subnets = append(subnets, prefix.Masked())
+	}
+	return subnets, nil
+}
+
+// trustedSubnet пропускает только запросы, у которых адрес из заголовка X-Real-IP
+// входит в одну из подсетей. Пустой список подсетей проверку отключает.
+func trustedSubnet(subnets []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(subnets) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			realIP := r.Header.Get("X-Real-IP")
+			addr, err := netip.ParseAddr(strings.TrimSpace(realIP))
+			if err != nil {
+				logger.Log.Debug("request without valid X-Real-IP", zap.String("X-Real-IP", realIP))
+				writeErrorResponse(w, http.StatusForbidden, codeForbidden, "X-Real-IP is missing or invalid", "")
+				return
+			}
+			addr = addr.WithZone("").Unmap()
+			for _, subnet := range subnets {
+				if subnet.Contains(addr) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			logger.Log.Debug("request from untrusted address", zap.String("X-Real-IP", realIP))
+			writeErrorResponse(w, http.StatusForbidden, codeForbidden, "address is not in a trusted subnet", "")
+		})
+	}
+}
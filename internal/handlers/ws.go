@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"monalert/internal/auth"
+	"monalert/internal/hub"
+	"monalert/internal/logger"
+	"monalert/internal/models"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+const (
+	wsWriteWait    = 10 * time.Second
+	wsPongWait     = 60 * time.Second
+	wsPingPeriod   = wsPongWait * 9 / 10
+	wsMaxFrameSize = 1 << 20
+	// wsOutboxSize — сколько исходящих сообщений может ждать отправки в одном соединении
+	wsOutboxSize = 256
+)
+
+// wsMessage — кадр протокола /ws в обе стороны.
+//
+// Клиент отправляет:
+//   - {"type":"metrics","seq":1,"metrics":[...]} — батч обновлений, сервер отвечает "ack".
+//     Если на сервере задан ключ, кадр несёт "signature" — HMAC-SHA256 поля metrics, см. verifyFrame;
+//   - {"type":"subscribe","filter":{"type":"gauge","prefix":"Heap"},"last_event_id":10} — подписка на обновления;
+//   - {"type":"unsubscribe"}.
+//
+// Сервер отправляет "ack", "update" и "error".
+type wsMessage struct {
+	Type        string           `json:"type"`
+	Seq         int64            `json:"seq,omitempty"`
+	Metrics     []models.Metrics `json:"metrics,omitempty"`
+	Filter      *wsFilter        `json:"filter,omitempty"`
+	LastEventID uint64           `json:"last_event_id,omitempty"`
+	Accepted    int              `json:"accepted,omitempty"`
+	Errors      []wsMetricError  `json:"errors,omitempty"`
+	EventID     uint64           `json:"event_id,omitempty"`
+	Metric      *models.Metrics  `json:"metric,omitempty"`
+	Message     string           `json:"message,omitempty"`
+	Signature   string           `json:"signature,omitempty"`
+}
+
+// wsRawMetrics — поле metrics кадра в том виде, в каком его прислал клиент.
+type wsRawMetrics struct {
+	Metrics json.RawMessage `json:"metrics"`
+}
+
+type wsFilter struct {
+	Type   string `json:"type"`
+	Prefix string `json:"prefix"`
+	// Labels не поддерживается: подписка с метками отклоняется ошибкой, см. errLabelFilter
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type wsMetricError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+type wsConn struct {
+	h     *handlers
+	conn  *websocket.Conn
+	token auth.Token
+	// ctx — контекст запроса на upgrade, от него считается таймаут каждого батча
+	ctx context.Context //nolint:containedctx // соединение живёт столько же, сколько запрос
+	// key определяет клиента для ограничения частоты батчей
+	key string
+	// outbox ограничивает число неотправленных сообщений: ответы на батчи ждут места,
+	// тем самым притормаживая клиента, а подписчика, который не успевает читать, отключаем
+	outbox chan wsMessage
+	done   chan struct{}
+
+	mux sync.Mutex
+	sub *hub.Subscription
+}
+
+func (h *handlers) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// права на отправку и подписку проверяются по кадрам, здесь нужен только валидный токен
+	token, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade уже ответил клиенту ошибкой
+		logger.Log.Debug("ws: upgrade failed", zap.Error(err))
+		return
+	}
+	c := &wsConn{
+		h:      h,
+		conn:   conn,
+		ctx:    r.Context(),
+		token:  token,
+		key:    clientKey(r),
+		outbox: make(chan wsMessage, wsOutboxSize),
+		done:   make(chan struct{}),
+	}
+	go c.writeLoop()
+	c.readLoop()
+}
+
+func (c *wsConn) readLoop() {
+	defer func() {
+		c.unsubscribe()
+		close(c.done)
+		c.conn.Close()
+	}()
+	c.conn.SetReadLimit(wsMaxFrameSize)
+	_ = c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logger.Log.Debug("ws: read failed", zap.Error(err))
+			}
+			return
+		}
+		_ = c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		var msg wsMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			logger.Log.Debug("ws: malformed frame", zap.Error(err))
+			return
+		}
+		var reply wsMessage
+		switch msg.Type {
+		case "metrics":
+			if !c.token.HasScope(auth.ScopeWrite) {
+				reply = wsMessage{Type: "error", Seq: msg.Seq, Message: "forbidden: " + auth.ScopeWrite + " scope required"}
+				break
+			}
+			if !c.verifyFrame(data, msg) {
+				logger.Log.Debug("ws: frame without valid signature", zap.Int64("seq", msg.Seq))
+				reply = wsMessage{Type: "error", Seq: msg.Seq, Message: "missing or invalid signature"}
+				break
+			}
+			reply = c.handleMetrics(msg)
+		case "subscribe":
+			if !c.token.HasScope(auth.ScopeRead) {
+				reply = wsMessage{Type: "error", Seq: msg.Seq, Message: "forbidden: " + auth.ScopeRead + " scope required"}
+				break
+			}
+			if msg.Filter != nil && msg.Filter.Labels != nil {
+				reply = wsMessage{Type: "error", Seq: msg.Seq, Message: errLabelFilter}
+				break
+			}
+			c.subscribe(msg)
+			continue
+		case "unsubscribe":
+			c.unsubscribe()
+			continue
+		default:
+			reply = wsMessage{Type: "error", Seq: msg.Seq, Message: "unsupported message type: " + msg.Type}
+		}
+		select {
+		case c.outbox <- reply:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// verifyFrame проверяет подпись кадра metrics, если на сервере задан ключ. Подписываются
+// байты поля metrics в том виде, в каком они пришли, — как тело запроса /updates/.
+// Без этой проверки /ws принимал бы неподписанные записи там, где HTTP их отвергает.
+func (c *wsConn) verifyFrame(data []byte, msg wsMessage) bool {
+	if c.h.key == "" {
+		return true
+	}
+	got, err := hex.DecodeString(msg.Signature)
+	if err != nil || len(got) == 0 {
+		return false
+	}
+	var raw wsRawMetrics
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return false
+	}
+	return hmac.Equal(got, sign(c.h.key, raw.Metrics))
+}
+
+func (c *wsConn) handleMetrics(msg wsMessage) wsMessage {
+	if len(msg.Metrics) > c.h.limits.MaxBatch {
+		return wsMessage{Type: "error", Seq: msg.Seq, Message: "batch is too large"}
+	}
+	if c.h.limiter != nil {
+		if ok, wait := c.h.limiter.allow(c.key); !ok {
+			return wsMessage{Type: "error", Seq: msg.Seq, Message: "too many requests, retry after " + retryAfterSeconds(wait) + "s"}
+		}
+	}
+	// таймаут запроса действует на каждый батч отдельно, само соединение им не ограничено
+	ctx, cancel := withTimeout(c.ctx, c.h.timeout)
+	defer cancel()
+	reply := wsMessage{Type: "ack", Seq: msg.Seq}
+	for i := range msg.Metrics {
+		m := &msg.Metrics[i]
+		m.Source = c.key
+		err := models.ValidateMetric(m)
+		if err == nil {
+			_, err = c.h.monalert.MetricUpdate(ctx, m)
+		}
+		if err != nil {
+			reply.Errors = append(reply.Errors, wsMetricError{Index: i, Error: err.Error()})
+			continue
+		}
+		reply.Accepted++
+	}
+	return reply
+}
+
+func (c *wsConn) subscribe(msg wsMessage) {
+	var filter hub.Filter
+	if msg.Filter != nil {
+		filter = hub.Filter{Type: msg.Filter.Type, Prefix: msg.Filter.Prefix}
+	}
+	c.unsubscribe()
+	sub := c.h.monalert.Subscribe(filter, msg.LastEventID)
+	c.mux.Lock()
+	c.sub = sub
+	c.mux.Unlock()
+	go c.forward(sub)
+}
+
+// forward пересылает события подписки в соединение. Если клиент не вычитывает
+// обновления и очередь переполнена, соединение закрывается, а не копит память.
+func (c *wsConn) forward(sub *hub.Subscription) {
+	for ev := range sub.Events() {
+		metric := ev.Metric
+		select {
+		case c.outbox <- wsMessage{Type: "update", EventID: ev.ID, Metric: &metric}:
+		case <-c.done:
+			return
+		default:
+			logger.Log.Warn("ws: subscriber is too slow, closing connection")
+			c.closeWith(websocket.ClosePolicyViolation, "subscriber is too slow")
+			return
+		}
+	}
+}
+
+func (c *wsConn) unsubscribe() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.sub != nil {
+		c.sub.Close()
+		c.sub = nil
+	}
+}
+
+func (c *wsConn) writeLoop() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case msg := <-c.outbox:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteJSON(msg); err != nil {
+				logger.Log.Debug("ws: write failed", zap.Error(err))
+				c.conn.Close()
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				c.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// closeWith шлёт клиенту кадр закрытия; readLoop после этого завершится и освободит ресурсы.
+func (c *wsConn) closeWith(code int, text string) {
+	_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(wsWriteWait))
+	c.conn.Close()
+}
@@ -0,0 +1,141 @@
+package hub
+
+import (
+	"monalert/internal/logger"
+	"monalert/internal/models"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Event — принятое сервером обновление метрики. ID монотонно растёт,
+// по нему клиент может продолжить поток после переподключения.
+type Event struct {
+	ID     uint64
+	Metric models.Metrics
+}
+
+// Filter отбирает события по типу метрики и префиксу имени. Пустые поля не фильтруют.
+// Фильтра по меткам нет, потому что у метрик нет меток.
+type Filter struct {
+	Type   string
+	Prefix string
+}
+
+func (f Filter) Match(m models.Metrics) bool {
+	if f.Type != "" && f.Type != m.MType {
+		return false
+	}
+	return strings.HasPrefix(m.ID, f.Prefix)
+}
+
+// Hub рассылает обновления метрик подписчикам. Публикация никогда не блокируется:
+// подписчик, который не успевает вычитывать события, отключается.
+type Hub struct {
+	mux        sync.Mutex
+	lastID     uint64
+	backlog    []Event
+	backlogPos int
+	backlogCap int
+	bufferSize int
+	subs       map[*Subscription]struct{}
+}
+
+// Subscription — подписка на события. Канал Events закрывается при Close
+// или когда хаб отключил медленного подписчика.
+type Subscription struct {
+	hub    *Hub
+	filter Filter
+	ch     chan Event
+	closed bool
+}
+
+// New создаёт хаб, который помнит backlogSize последних событий для
+// возобновления потока и даёт каждому подписчику буфер на bufferSize событий.
+func New(backlogSize, bufferSize int) *Hub {
+	return &Hub{
+		backlogCap: backlogSize,
+		bufferSize: bufferSize,
+		subs:       make(map[*Subscription]struct{}),
+	}
+}
+
+func (h *Hub) Publish(m models.Metrics) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.lastID++
+	ev := Event{ID: h.lastID, Metric: m}
+	if h.backlogCap > 0 {
+		if len(h.backlog) < h.backlogCap {
+			h.backlog = append(h.backlog, ev)
+		} else {
+			h.backlog[h.backlogPos] = ev
+			h.backlogPos = (h.backlogPos + 1) % h.backlogCap
+		}
+	}
+	for sub := range h.subs {
+		if !sub.filter.Match(m) {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			logger.Log.Warn("hub: subscriber is too slow, dropping")
+			h.unsubscribe(sub)
+		}
+	}
+}
+
+// Subscribe возвращает подписку на события, подходящие под filter.
+// Если lastEventID больше нуля, сначала отдаются сохранённые события после него.
+func (h *Hub) Subscribe(filter Filter, lastEventID uint64) *Subscription {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	var replay []Event
+	if lastEventID > 0 {
+		for _, ev := range h.orderedBacklog() {
+			if ev.ID > lastEventID && filter.Match(ev.Metric) {
+				replay = append(replay, ev)
+			}
+		}
+	}
+	sub := &Subscription{
+		hub:    h,
+		filter: filter,
+		ch:     make(chan Event, h.bufferSize+len(replay)),
+	}
+	for _, ev := range replay {
+		sub.ch <- ev
+	}
+	h.subs[sub] = struct{}{}
+	logger.Log.Debug("hub: new subscriber", zap.Int("replayed", len(replay)), zap.Int("subscribers", len(h.subs)))
+	return sub
+}
+
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+func (s *Subscription) Close() {
+	s.hub.mux.Lock()
+	defer s.hub.mux.Unlock()
+	s.hub.unsubscribe(s)
+}
+
+// unsubscribe вызывается под блокировкой.
+func (h *Hub) unsubscribe(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	delete(h.subs, sub)
+	close(sub.ch)
+}
+
+// orderedBacklog возвращает сохранённые события от старых к новым. Вызывается под блокировкой.
+func (h *Hub) orderedBacklog() []Event {
+	out := make([]Event, 0, len(h.backlog))
+	out = append(out, h.backlog[h.backlogPos:]...)
+	return append(out, h.backlog[:h.backlogPos]...)
+}
@@ -0,0 +1,153 @@
+package hub
+
+import (
+	"monalert/internal/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gauge(id string) models.Metrics {
+	v := 1.0
+	return models.Metrics{ID: id, MType: "gauge", Value: &v}
+}
+
+func counter(id string) models.Metrics {
+	d := int64(1)
+	return models.Metrics{ID: id, MType: "counter", Delta: &d}
+}
+
+// received вычитывает всё, что уже лежит в канале подписки, и возвращает номера событий.
+func received(sub *Subscription) []uint64 {
+	var ids []uint64
+	for {
+		select {
+		case ev, ok := <-sub.Events():
+			if !ok {
+				return ids
+			}
+			ids = append(ids, ev.ID)
+		default:
+			return ids
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		metric models.Metrics
+		want   bool
+	}{
+		{name: "empty filter", metric: gauge("a"), want: true},
+		{name: "type", filter: Filter{Type: "gauge"}, metric: gauge("a"), want: true},
+		{name: "other type", filter: Filter{Type: "counter"}, metric: gauge("a")},
+		{name: "prefix", filter: Filter{Prefix: "api."}, metric: gauge("api.requests"), want: true},
+		{name: "other prefix", filter: Filter{Prefix: "api."}, metric: gauge("db.requests")},
+		{name: "prefix is not a substring match", filter: Filter{Prefix: "requests"}, metric: gauge("api.requests")},
+		{name: "type and prefix", filter: Filter{Type: "counter", Prefix: "api."}, metric: counter("api.requests"), want: true},
+		{name: "prefix matches, type does not", filter: Filter{Type: "counter", Prefix: "api."}, metric: gauge("api.requests")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.Match(tt.metric))
+		})
+	}
+}
+
+func TestPublishFiltersSubscribers(t *testing.T) {
+	h := New(0, 8)
+	all := h.Subscribe(Filter{}, 0)
+	counters := h.Subscribe(Filter{Type: "counter"}, 0)
+	api := h.Subscribe(Filter{Prefix: "api."}, 0)
+
+	h.Publish(gauge("api.latency"))
+	h.Publish(counter("PollCount"))
+	h.Publish(counter("api.requests"))
+
+	assert.Equal(t, []uint64{1, 2, 3}, received(all))
+	assert.Equal(t, []uint64{2, 3}, received(counters))
+	assert.Equal(t, []uint64{1, 3}, received(api))
+}
+
+func TestSubscribeReplaysBacklog(t *testing.T) {
+	h := New(3, 8)
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		h.Publish(gauge(id))
+	}
+	// в кольцевом буфере остались события 3, 4 и 5
+
+	tests := []struct {
+		name        string
+		filter      Filter
+		lastEventID uint64
+		want        []uint64
+	}{
+		{name: "without last event id", lastEventID: 0},
+		{name: "inside backlog", lastEventID: 3, want: []uint64{4, 5}},
+		{name: "latest event", lastEventID: 5},
+		// часть событий уже вытеснена: отдаётся всё, что сохранилось, по порядку
+		{name: "older than backlog", lastEventID: 1, want: []uint64{3, 4, 5}},
+		{name: "filtered", filter: Filter{Prefix: "d"}, lastEventID: 1, want: []uint64{4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := h.Subscribe(tt.filter, tt.lastEventID)
+			defer sub.Close()
+			assert.Equal(t, tt.want, received(sub))
+		})
+	}
+
+	// после повтора подписка получает новые события
+	sub := h.Subscribe(Filter{}, 4)
+	defer sub.Close()
+	h.Publish(gauge("f"))
+	assert.Equal(t, []uint64{5, 6}, received(sub))
+}
+
+func TestSubscribeReplayDoesNotCountAgainstBuffer(t *testing.T) {
+	h := New(10, 1)
+	for _, id := range []string{"a", "b", "c"} {
+		h.Publish(gauge(id))
+	}
+	sub := h.Subscribe(Filter{}, 1)
+	defer sub.Close()
+	// повтор занял свои места в канале, а на новое событие остался весь буфер
+	h.Publish(gauge("d"))
+	assert.Equal(t, []uint64{2, 3, 4}, received(sub))
+}
+
+func TestPublishDropsSlowSubscriber(t *testing.T) {
+	h := New(0, 2)
+	slow := h.Subscribe(Filter{}, 0)
+	fast := h.Subscribe(Filter{}, 0)
+	other := h.Subscribe(Filter{Type: "counter"}, 0)
+
+	h.Publish(gauge("a"))
+	h.Publish(gauge("b"))
+	require.Equal(t, []uint64{1, 2}, received(fast))
+	// буфер медленного подписчика полон: третье событие его отключает, а не блокирует публикацию
+	h.Publish(gauge("c"))
+
+	var ids []uint64
+	for ev := range slow.Events() {
+		ids = append(ids, ev.ID)
+	}
+	assert.Equal(t, []uint64{1, 2}, ids, "buffered events are delivered before the channel closes")
+	assert.Equal(t, []uint64{3}, received(fast))
+	assert.Empty(t, received(other))
+
+	h.mux.Lock()
+	assert.Len(t, h.subs, 2)
+	h.mux.Unlock()
+
+	// Close после отключения хабом безопасен
+	slow.Close()
+	fast.Close()
+	other.Close()
+	assert.Empty(t, h.subs)
+	_, ok := <-fast.Events()
+	assert.False(t, ok)
+}
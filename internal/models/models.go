@@ -1,9 +1,48 @@
 package models
 
+import "time"
+
 //nolint:govet //не такой нагруженный сервис
 type Metrics struct {
 	ID    string   `json:"id"`              // имя метрики
 	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
 	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
 	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
+	// Cumulative означает, что в Delta передано накопленное клиентом значение счётчика,
+	// а не приращение; сервер сам вычисляет приращение относительно прошлого значения
+	Cumulative bool `json:"cumulative,omitempty"`
+	// Replace — значение заменяет сохранённое, а не прибавляется к нему (PUT);
+	// заполняет сервер, в JSON не передаётся
+	Replace bool `json:"-"`
+	// Source — агент, приславший метрику; заполняет сервер, в JSON не передаётся
+	Source string `json:"-"`
+}
+
+// Sample — значение метрики на момент обновления, из них строится история серии.
+type Sample struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// Metadata — описание метрики: в чём она измеряется и как её показывать.
+type Metadata struct {
+	Unit    string `json:"unit,omitempty"`    // единица измерения: bytes, seconds, nanoseconds, percent или любая другая
+	Help    string `json:"help,omitempty"`    // описание метрики
+	Type    string `json:"type,omitempty"`    // ожидаемый тип метрики: gauge или counter
+	Display string `json:"display,omitempty"` // подсказка для отображения: raw, percent (доля от 1) или timestamp
+}
+
+// History — последние значения серии.
+type History struct {
+	ID      string   `json:"id"`
+	MType   string   `json:"type"`
+	Samples []Sample `json:"samples"`
+}
+
+// MetricList — страница списка метрик. Total — сколько всего метрик подходит под фильтры,
+// Next — ссылка на следующую страницу, на последней странице её нет.
+type MetricList struct {
+	Metrics []Metrics `json:"metrics"`
+	Total   int       `json:"total"`
+	Next    string    `json:"next,omitempty"`
 }
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Ошибки проверки метрики. Проверка общая для сервера и клиентов: агент отбрасывает
+// метрику, которую сервер всё равно не примет, ещё до отправки.
+var (
+	ErrUnsupportedType = errors.New("unsupported metric type")
+	ErrInvalidValue    = errors.New("invalid value")
+	// ErrReservedName — имя занято метрикой самого сервера.
+	ErrReservedName = errors.New("metric name is reserved for server metrics")
+)
+
+// FieldError — ошибка с сообщением для клиента, обычно в конкретном поле метрики: id, type,
+// value или delta. Field пустой, если ошибка не относится к одному полю.
+// Оборачивает одну из ошибок выше или ошибку хранилища, так что errors.Is работает и для неё.
+type FieldError struct {
+	Field   string
+	Message string
+	Err     error
+}
+
+// NewFieldError создаёт ошибку поля с сообщением для клиента.
+func NewFieldError(field string, err error, format string, args ...any) *FieldError {
+	return &FieldError{Field: field, Message: fmt.Sprintf(format, args...), Err: err}
+}
+
+func (e *FieldError) Error() string {
+	return e.Message
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// reservedNames — имена метрик самого сервера без сегментов меток. Зарезервирован не весь
+// префикс monalert_, а только эти имена: клиенты, которые уже пишут свои метрики с таким
+// префиксом, продолжают работать.
+var reservedNames = map[string]bool{
+	"monalert_series":                               true,
+	"monalert_series_limit":                         true,
+	"monalert_new_series_last_minute":               true,
+	"monalert_new_series_per_minute_limit":          true,
+	"monalert_series_per_agent_limit":               true,
+	"monalert_series_rejected_total":                true,
+	"monalert_ingested_metrics_total":               true,
+	"monalert_ingestion_rate":                       true,
+	"monalert_persist_failures_total":               true,
+	"monalert_persist_duration_seconds":             true,
+	"monalert_persist_duration_seconds_bucket":      true,
+	"monalert_persist_duration_seconds_sum":         true,
+	"monalert_persist_duration_seconds_count":       true,
+	"monalert_http_requests_total":                  true,
+	"monalert_http_request_duration_seconds":        true,
+	"monalert_http_request_duration_seconds_bucket": true,
+	"monalert_http_request_duration_seconds_sum":    true,
+	"monalert_http_request_duration_seconds_count":  true,
+	"monalert_uptime_seconds":                       true,
+	"monalert_go_goroutines":                        true,
+	"monalert_go_heap_alloc_bytes":                  true,
+	"monalert_go_heap_inuse_bytes":                  true,
+	"monalert_go_sys_bytes":                         true,
+	"monalert_go_gc_total":                          true,
+	"monalert_go_gc_pause_seconds_total":            true,
+}
+
+// IsReservedName сообщает, что id — метрика самого сервера или серия её семейства
+// с метками, например monalert_http_requests_total.route__update_.status_200.
+// Такие имена клиенты записать не могут.
+func IsReservedName(id string) bool {
+	family, _, _ := strings.Cut(id, ".")
+	return reservedNames[family]
+}
+
+// ValidateMetric проверяет метрику до записи. Ошибка — *FieldError с полем, которое нужно исправить.
+func ValidateMetric(m *Metrics) error {
+	if m.ID == "" {
+		return NewFieldError("id", ErrInvalidValue, "empty metric id")
+	}
+	if IsReservedName(m.ID) {
+		return NewFieldError("id", ErrReservedName, "metric name %s is reserved for server metrics", m.ID)
+	}
+	switch m.MType {
+	case "gauge":
+		if m.Value == nil {
+			return NewFieldError("value", ErrInvalidValue, "gauge %s without value", m.ID)
+		}
+		if math.IsNaN(*m.Value) || math.IsInf(*m.Value, 0) {
+			return NewFieldError("value", ErrInvalidValue, "gauge %s has non-finite value", m.ID)
+		}
+	case "counter":
+		if m.Delta == nil {
+			return NewFieldError("delta", ErrInvalidValue, "counter %s without delta", m.ID)
+		}
+	case "":
+		return NewFieldError("type", ErrUnsupportedType, "metric %s without type", m.ID)
+	default:
+		return NewFieldError("type", ErrUnsupportedType, "unsupported metric type: %s", m.MType)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsReservedName(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{id: "monalert_series", want: true},
+		{id: "monalert_http_requests_total.route__update_.status_200", want: true},
+		{id: "monalert_http_request_duration_seconds_bucket.le_0_1", want: true},
+		{id: "monalert_http_request_duration_seconds", want: true},
+		{id: "monalert_queue_depth"},
+		{id: "monalert_series_custom"},
+		{id: "monalert_"},
+		{id: "series"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, IsReservedName(tt.id), tt.id)
+	}
+}
+
+func TestValidateMetric(t *testing.T) {
+	value, delta := 1.5, int64(2)
+	tests := []struct {
+		name    string
+		metric  Metrics
+		field   string
+		wantErr error
+	}{
+		{name: "gauge", metric: Metrics{ID: "a", MType: "gauge", Value: &value}},
+		{name: "counter", metric: Metrics{ID: "a", MType: "counter", Delta: &delta}},
+		{name: "own metric with server prefix", metric: Metrics{ID: "monalert_queue_depth", MType: "gauge", Value: &value}},
+		{name: "empty id", metric: Metrics{MType: "gauge", Value: &value}, field: "id", wantErr: ErrInvalidValue},
+		{name: "server metric", metric: Metrics{ID: "monalert_series", MType: "gauge", Value: &value}, field: "id", wantErr: ErrReservedName},
+		{name: "gauge without value", metric: Metrics{ID: "a", MType: "gauge"}, field: "value", wantErr: ErrInvalidValue},
+		{name: "counter without delta", metric: Metrics{ID: "a", MType: "counter"}, field: "delta", wantErr: ErrInvalidValue},
+		{name: "without type", metric: Metrics{ID: "a"}, field: "type", wantErr: ErrUnsupportedType},
+		{name: "unknown type", metric: Metrics{ID: "a", MType: "histogram"}, field: "type", wantErr: ErrUnsupportedType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateMetric(&tt.metric)
+			if tt.wantErr == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tt.wantErr)
+			var fieldErr *FieldError
+			require.True(t, errors.As(err, &fieldErr))
+			assert.Equal(t, tt.field, fieldErr.Field)
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrCardinalityLimit возвращается, когда новая серия не помещается в лимиты хранилища.
+var ErrCardinalityLimit = errors.New("cardinality limit exceeded")
+
+var (
+	ErrSeriesLimit      = fmt.Errorf("%w: too many series", ErrCardinalityLimit)
+	ErrNewSeriesRate    = fmt.Errorf("%w: too many new series per minute", ErrCardinalityLimit)
+	ErrAgentSeriesLimit = fmt.Errorf("%w: too many series from one agent", ErrCardinalityLimit)
+)
+
+// CardinalityLimits ограничивает число серий. Нулевое значение — без ограничения.
+type CardinalityLimits struct {
+	MaxSeries          int // всего серий в хранилище
+	MaxNewSeriesPerMin int // новых серий за минуту
+	MaxSeriesPerAgent  int // серий, созданных одним агентом
+}
+
+// CardinalityStats — текущие значения и лимиты для самомониторинга.
+type CardinalityStats struct {
+	Limits              CardinalityLimits
+	Series              int
+	NewSeriesLastMinute int
+	Rejected            int64
+}
+
+// SeriesCount — сколько серий приходится на префикс имени или на агента.
+type SeriesCount struct {
+	Name   string `json:"name"`
+	Series int    `json:"series"`
+}
+
+// CardinalityReport — отчёт для администратора: кто создаёт больше всего серий.
+type CardinalityReport struct {
+	Series      int           `json:"series"`
+	MaxSeries   int           `json:"max_series"`
+	TopPrefixes []SeriesCount `json:"top_prefixes"`
+	TopAgents   []SeriesCount `json:"top_agents"`
+	Rejected    int64         `json:"rejected"`
+}
+
+// cardinality считает серии и проверяет лимиты. Все методы вызываются под блокировкой Store.
+type cardinality struct {
+	limits      CardinalityLimits
+	windowStart time.Time
+	newInWindow int
+	rejected    int64
+	// owners — какой агент создал серию, agentSeries — сколько серий у агента
+	owners      map[seriesKey]string
+	agentSeries map[string]int
+}
+
+func newCardinality() cardinality {
+	return cardinality{
+		owners:      make(map[seriesKey]string),
+		agentSeries: make(map[string]int),
+	}
+}
+
+// admit проверяет, можно ли создать новую серию, и если можно — учитывает её.
+func (c *cardinality) admit(key seriesKey, source string, series int, now time.Time) error {
+	if now.Sub(c.windowStart) >= time.Minute {
+		c.windowStart = now
+		c.newInWindow = 0
+	}
+	var err error
+	switch {
+	case c.limits.MaxSeries > 0 && series >= c.limits.MaxSeries:
+		err = ErrSeriesLimit
+	case c.limits.MaxNewSeriesPerMin > 0 && c.newInWindow >= c.limits.MaxNewSeriesPerMin:
+		err = ErrNewSeriesRate
+	case c.limits.MaxSeriesPerAgent > 0 && source != "" && c.agentSeries[source] >= c.limits.MaxSeriesPerAgent:
+		err = ErrAgentSeriesLimit
+	}
+	if err != nil {
+		c.rejected++
+		return err
+	}
+	c.newInWindow++
+	if source != "" {
+		c.owners[key] = source
+		c.agentSeries[source]++
+	}
+	return nil
+}
+
+// release отменяет admit для серии, которую так и не создали, потому что батч отклонён целиком.
+// window — начало окна, в котором серию допустили: если окно с тех пор сменилось,
+// серия в счётчике нового окна не учтена и вычитать её не из чего.
+func (c *cardinality) release(key seriesKey, window time.Time) {
+	c.forget(key)
+	if window.Equal(c.windowStart) {
+		c.newInWindow--
+	}
+}
+
+// forget освобождает место удалённой серии в лимите её агента.
+func (c *cardinality) forget(key seriesKey) {
+	source, ok := c.owners[key]
+	if !ok {
+		return
+	}
+	delete(c.owners, key)
+	if c.agentSeries[source]--; c.agentSeries[source] <= 0 {
+		delete(c.agentSeries, source)
+	}
+}
+
+// SetCardinalityLimits задаёт лимиты на число серий. Уже существующие серии не удаляются.
+func (s *Store) SetCardinalityLimits(limits CardinalityLimits) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.cardinality.limits = limits
+	// серии, восстановленные из файла, не должны съедать лимит новых серий за минуту
+	s.cardinality.windowStart = time.Time{}
+	s.cardinality.newInWindow = 0
+}
+
+func (s *Store) CardinalityStats() CardinalityStats {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	stats := CardinalityStats{
+		Limits:   s.cardinality.limits,
+		Series:   len(s.gaugeStore) + len(s.counterStore),
+		Rejected: s.cardinality.rejected,
+	}
+	if time.Since(s.cardinality.windowStart) < time.Minute {
+		stats.NewSeriesLastMinute = s.cardinality.newInWindow
+	}
+	return stats
+}
+
+// CardinalityReport группирует серии по первым depth сегментам имени (разделитель '.')
+// и возвращает top самых больших групп и агентов.
+func (s *Store) CardinalityReport(depth, top int) CardinalityReport {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	prefixes := make(map[string]int)
+	count := func(id string) {
+		parts := strings.SplitN(id, ".", depth+1)
+		if len(parts) > depth {
+			parts = parts[:depth]
+		}
+		prefixes[strings.Join(parts, ".")]++
+	}
+	for id := range s.gaugeStore {
+		count(id)
+	}
+	for id := range s.counterStore {
+		count(id)
+	}
+	return CardinalityReport{
+		Series:      len(s.gaugeStore) + len(s.counterStore),
+		MaxSeries:   s.cardinality.limits.MaxSeries,
+		TopPrefixes: topCounts(prefixes, top),
+		TopAgents:   topCounts(s.cardinality.agentSeries, top),
+		Rejected:    s.cardinality.rejected,
+	}
+}
+
+func topCounts(counts map[string]int, top int) []SeriesCount {
+	result := make([]SeriesCount, 0, len(counts))
+	for name, n := range counts {
+		result = append(result, SeriesCount{Name: name, Series: n})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Series != result[j].Series {
+			return result[i].Series > result[j].Series
+		}
+		return result[i].Name < result[j].Name
+	})
+	if top > 0 && len(result) > top {
+		result = result[:top]
+	}
+	return result
+}
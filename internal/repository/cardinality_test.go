@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCardinalityReleaseAcrossWindows(t *testing.T) {
+	c := newCardinality()
+	c.limits.MaxNewSeriesPerMin = 2
+	start := time.Now()
+	old, fresh := seriesKey{mType: "gauge", id: "old"}, seriesKey{mType: "gauge", id: "fresh"}
+
+	require.NoError(t, c.admit(old, "agent", 0, start))
+	oldWindow := c.windowStart
+	// батч затянулся за границу минуты: окно сменилось между двумя сериями
+	require.NoError(t, c.admit(fresh, "agent", 1, start.Add(time.Minute)))
+	freshWindow := c.windowStart
+	require.Equal(t, 1, c.newInWindow)
+
+	// серия из прошлого окна в новом не учтена, её отмена счётчик не трогает
+	c.release(old, oldWindow)
+	assert.Equal(t, 1, c.newInWindow)
+	c.release(fresh, freshWindow)
+	assert.Equal(t, 0, c.newInWindow)
+	assert.Empty(t, c.agentSeries)
+
+	// счётчик не уходит в минус, и лимит окна остаётся прежним
+	now := start.Add(time.Minute + time.Second)
+	require.NoError(t, c.admit(old, "", 0, now))
+	require.NoError(t, c.admit(fresh, "", 1, now))
+	assert.ErrorIs(t, c.admit(seriesKey{mType: "gauge", id: "third"}, "", 2, now), ErrNewSeriesRate)
+}
@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"monalert/internal/logger"
+	"monalert/internal/models"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DeleteMetric удаляет серию вместе с историей.
+func (s *Store) DeleteMetric(ctx context.Context, req *models.Metrics) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("repository: delete cancelled: %w", err)
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	switch req.MType {
+	case "gauge":
+		if _, ok := s.gaugeStore[req.ID]; !ok {
+			return notFound(req.MType, req.ID)
+		}
+	case "counter":
+		if _, ok := s.counterStore[req.ID]; !ok {
+			return notFound(req.MType, req.ID)
+		}
+	default:
+		return unsupportedType(req.MType)
+	}
+	s.delete(seriesKey{mType: req.MType, id: req.ID})
+	logger.Log.Debug("repository: storage deleted metric", zap.String("type", req.MType), zap.String("name", req.ID))
+	return nil
+}
+
+// DeleteByPrefix удаляет все серии, имя которых начинается с prefix.
+// Пустой mType означает серии обоих типов. Возвращает число удалённых серий.
+func (s *Store) DeleteByPrefix(ctx context.Context, mType, prefix string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("repository: delete cancelled: %w", err)
+	}
+	if mType != "" && mType != "gauge" && mType != "counter" {
+		return 0, unsupportedType(mType)
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	var keys []seriesKey
+	for _, key := range s.keys() {
+		if (mType == "" || key.mType == mType) && strings.HasPrefix(key.id, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys {
+		s.delete(key)
+	}
+	logger.Log.Debug("repository: storage deleted metrics by prefix", zap.String("prefix", prefix), zap.Int("count", len(keys)))
+	return len(keys), nil
+}
+
+// Expire удаляет серии, которые не обновлялись дольше ttl. Возвращает число удалённых серий.
+func (s *Store) Expire(ctx context.Context, ttl time.Duration) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("repository: expiry cancelled: %w", err)
+	}
+	cutoff := time.Now().Add(-ttl)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	var expired []seriesKey
+	for _, key := range s.keys() {
+		// серия без истории пишется только вместе с историей, так что это не должно случаться
+		if h, ok := s.history[key]; ok && h.updated().Before(cutoff) {
+			expired = append(expired, key)
+		}
+	}
+	for _, key := range expired {
+		s.delete(key)
+	}
+	return len(expired), nil
+}
+
+// keys возвращает ключи всех серий. Вызывается под блокировкой.
+func (s *Store) keys() []seriesKey {
+	keys := make([]seriesKey, 0, len(s.gaugeStore)+len(s.counterStore))
+	for id := range s.gaugeStore {
+		keys = append(keys, seriesKey{mType: "gauge", id: id})
+	}
+	for id := range s.counterStore {
+		keys = append(keys, seriesKey{mType: "counter", id: id})
+	}
+	return keys
+}
+
+// delete убирает серию из всех структур хранилища. Вызывается под блокировкой.
+func (s *Store) delete(key seriesKey) {
+	switch key.mType {
+	case "gauge":
+		delete(s.gaugeStore, key.id)
+	case "counter":
+		delete(s.counterStore, key.id)
+		delete(s.cumulativeStore, key.id)
+	}
+	delete(s.history, key)
+	s.cardinality.forget(key)
+}
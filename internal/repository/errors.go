@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"monalert/internal/models"
+)
+
+// Ошибки хранилища и сервиса. Проверяются через errors.Is, по ним хендлеры выбирают код ответа.
+// Ошибки проверки метрики определены в models, чтобы ту же проверку выполняли клиенты.
+var (
+	ErrNotFound        = errors.New("not found")
+	ErrUnsupportedType = models.ErrUnsupportedType
+	ErrInvalidValue    = models.ErrInvalidValue
+)
+
+// FieldError — ошибка с сообщением для клиента в конкретном поле, см. models.FieldError.
+type FieldError = models.FieldError
+
+// NewFieldError создаёт ошибку поля с сообщением для клиента.
+func NewFieldError(field string, err error, format string, args ...any) *FieldError {
+	return models.NewFieldError(field, err, format, args...)
+}
+
+// BatchFieldError указывает в ошибке номер метрики в батче: поле value метрики 3
+// становится [3].value, а ошибка без поля относится к её имени, [3].id.
+func BatchFieldError(i int, err error) *FieldError {
+	var fieldErr *FieldError
+	if errors.As(err, &fieldErr) {
+		field := fieldErr.Field
+		if field == "" {
+			field = "id"
+		}
+		return NewFieldError(fmt.Sprintf("[%d].%s", i, field), fieldErr.Err, "metric %d: %s", i, fieldErr.Message)
+	}
+	return NewFieldError(fmt.Sprintf("[%d].id", i), err, "metric %d: %s", i, err)
+}
+
+func unsupportedType(mType string) error {
+	return NewFieldError("type", ErrUnsupportedType, "unsupported metric type: %s", mType)
+}
+
+func notFound(mType, id string) error {
+	return NewFieldError("", ErrNotFound, "no %s metric %s in storage", mType, id)
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"monalert/internal/models"
+	"time"
+)
+
+// historySize — сколько последних значений хранится по каждой серии.
+const historySize = 120
+
+type seriesKey struct {
+	mType string
+	id    string
+}
+
+// history — кольцевой буфер последних значений серии. Хранится только в памяти.
+type history struct {
+	samples []models.Sample
+	next    int
+}
+
+func (h *history) add(value float64, t time.Time) {
+	sample := models.Sample{Time: t, Value: value}
+	if len(h.samples) < historySize {
+		h.samples = append(h.samples, sample)
+		return
+	}
+	h.samples[h.next] = sample
+	h.next = (h.next + 1) % historySize
+}
+
+// list возвращает копию значений от старых к новым.
+func (h *history) list() []models.Sample {
+	out := make([]models.Sample, 0, len(h.samples))
+	out = append(out, h.samples[h.next:]...)
+	return append(out, h.samples[:h.next]...)
+}
+
+// updated возвращает время последнего значения серии.
+func (h *history) updated() time.Time {
+	if len(h.samples) == 0 {
+		return time.Time{}
+	}
+	if len(h.samples) < historySize {
+		return h.samples[len(h.samples)-1].Time
+	}
+	return h.samples[(h.next+historySize-1)%historySize].Time
+}
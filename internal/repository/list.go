@@ -0,0 +1,262 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"monalert/internal/models"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Порядок сортировки в ListOptions.Sort.
+const (
+	SortByID   = "id"   // по имени, при равных именах — по типу
+	SortByType = "type" // по типу, внутри типа — по имени
+)
+
+// ListOptions — параметры выборки метрик. Нулевое значение — все метрики по имени.
+type ListOptions struct {
+	Type   string         // gauge или counter, пустой — оба типа
+	Prefix string         // начало имени
+	Match  *regexp.Regexp // регулярное выражение для имени, nil — без проверки
+	Sort   string         // SortByID или SortByType, пустой — SortByID
+	Desc   bool           // обратный порядок
+	Limit  int            // размер страницы, 0 — без ограничения
+	Cursor string         // ListPage.Next предыдущей страницы
+}
+
+// ListPage — страница выборки.
+type ListPage struct {
+	Metrics []models.Metrics
+	// Total — сколько метрик подходит под фильтры без учёта курсора и лимита
+	Total int
+	// Next — курсор следующей страницы, пустой на последней
+	Next string
+}
+
+// listCursor — содержимое курсора: последняя отданная серия и порядок, в котором её отдали.
+// Курсор указывает на серию, а не на номер позиции, поэтому страницы не съезжают, когда
+// между запросами серии добавляются или удаляются.
+type listCursor struct {
+	Sort string `json:"s"`
+	Desc bool   `json:"d,omitempty"`
+	Type string `json:"t"`
+	ID   string `json:"i"`
+}
+
+// Validate проверяет параметры выборки, ошибки возвращаются с полем запроса.
+func (o ListOptions) Validate() error {
+	if o.Type != "" && o.Type != "gauge" && o.Type != "counter" {
+		return unsupportedType(o.Type)
+	}
+	if o.Sort != "" && o.Sort != SortByID && o.Sort != SortByType {
+		return NewFieldError("sort", ErrInvalidValue, "unsupported sort order: %s", o.Sort)
+	}
+	if o.Limit < 0 {
+		return NewFieldError("limit", ErrInvalidValue, "limit must not be negative")
+	}
+	_, err := o.cursor()
+	return err
+}
+
+// Matches сообщает, подходит ли метрика под фильтры. Курсор не учитывается.
+func (o ListOptions) Matches(m models.Metrics) bool {
+	return o.matches(seriesKey{mType: m.MType, id: m.ID})
+}
+
+// After сообщает, идёт ли метрика после курсора. Без курсора — всегда true.
+func (o ListOptions) After(m models.Metrics) bool {
+	c, err := o.cursor()
+	if err != nil || c == nil {
+		return err == nil
+	}
+	return o.less(seriesKey{mType: c.Type, id: c.ID}, seriesKey{mType: m.MType, id: m.ID})
+}
+
+// Less сравнивает метрики в порядке выборки.
+func (o ListOptions) Less(a, b models.Metrics) bool {
+	return o.less(seriesKey{mType: a.MType, id: a.ID}, seriesKey{mType: b.MType, id: b.ID})
+}
+
+// NextCursor возвращает курсор страницы, которая начинается сразу после m.
+func (o ListOptions) NextCursor(m models.Metrics) string {
+	data, err := json.Marshal(listCursor{Sort: o.sortOrder(), Desc: o.Desc, Type: m.MType, ID: m.ID})
+	if err != nil {
+		// структура из строк и bool всегда сериализуется
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (o ListOptions) sortOrder() string {
+	if o.Sort == "" {
+		return SortByID
+	}
+	return o.Sort
+}
+
+func (o ListOptions) matches(key seriesKey) bool {
+	return (o.Type == "" || key.mType == o.Type) &&
+		strings.HasPrefix(key.id, o.Prefix) &&
+		(o.Match == nil || o.Match.MatchString(key.id))
+}
+
+func (o ListOptions) less(a, b seriesKey) bool {
+	if o.Desc {
+		a, b = b, a
+	}
+	if o.sortOrder() == SortByType {
+		if a.mType != b.mType {
+			return a.mType < b.mType
+		}
+		return a.id < b.id
+	}
+	if a.id != b.id {
+		return a.id < b.id
+	}
+	return a.mType < b.mType
+}
+
+// cursor разбирает курсор. Курсор от выборки с другим порядком не подходит:
+// по нему нельзя понять, какие серии уже отданы.
+func (o ListOptions) cursor() (*listCursor, error) {
+	if o.Cursor == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(o.Cursor)
+	var c listCursor
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil {
+		return nil, NewFieldError("cursor", ErrInvalidValue, "invalid cursor")
+	}
+	if c.Sort != o.sortOrder() || c.Desc != o.Desc {
+		return nil, NewFieldError("cursor", ErrInvalidValue, "cursor belongs to a listing with another sort order")
+	}
+	return &c, nil
+}
+
+// ListMetrics отдаёт страницу метрик, отфильтрованных и упорядоченных по opts.
+// Под блокировкой собираются только ключи подходящих серий, значения копируются
+// лишь для серий, попавших на страницу.
+func (s *Store) ListMetrics(ctx context.Context, opts ListOptions) (ListPage, error) {
+	if err := ctx.Err(); err != nil {
+		return ListPage{}, fmt.Errorf("repository: list cancelled: %w", err)
+	}
+	if err := opts.Validate(); err != nil {
+		return ListPage{}, err
+	}
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	var page ListPage
+	var keys []seriesKey
+	keys, page.Total = s.listKeys(opts)
+	if err := ctx.Err(); err != nil {
+		return ListPage{}, fmt.Errorf("repository: list cancelled: %w", err)
+	}
+	sort.Slice(keys, func(i, j int) bool { return opts.less(keys[i], keys[j]) })
+	if opts.Limit > 0 && len(keys) > opts.Limit {
+		keys = keys[:opts.Limit]
+		last := keys[len(keys)-1]
+		page.Next = opts.NextCursor(models.Metrics{MType: last.mType, ID: last.id})
+	}
+	page.Metrics = s.readMetrics(keys)
+	return page, nil
+}
+
+// WalkMetrics передаёт fn все метрики выборки порциями по chunk в порядке opts, Limit не
+// учитывается. Ключи подходящих серий собираются и сортируются один раз, а значения каждой
+// порции читаются под своей короткой блокировкой, так что запись не ждёт, пока fn отдаёт
+// порцию клиенту. До первой порции start получает число собранных ключей. Серии, удалённые
+// после того как собраны ключи, пропускаются, и метрик тогда меньше, чем сообщено start;
+// новые в выборку не попадают.
+func (s *Store) WalkMetrics(ctx context.Context, opts ListOptions, chunk int, start func(n int) error, fn func([]models.Metrics) error) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("repository: list cancelled: %w", err)
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	s.mux.RLock()
+	keys, _ := s.listKeys(opts)
+	s.mux.RUnlock()
+	sort.Slice(keys, func(i, j int) bool { return opts.less(keys[i], keys[j]) })
+	if err := start(len(keys)); err != nil {
+		return err
+	}
+	if chunk < 1 {
+		chunk = len(keys)
+	}
+	for start := 0; start < len(keys); start += chunk {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("repository: list cancelled: %w", err)
+		}
+		s.mux.RLock()
+		metrics := s.readMetrics(keys[start:min(start+chunk, len(keys))])
+		s.mux.RUnlock()
+		if len(metrics) == 0 {
+			continue
+		}
+		if err := fn(metrics); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listKeys собирает ключи серий, подходящих под фильтры и идущих после курсора, без сортировки,
+// и считает, сколько серий подходит под фильтры без учёта курсора. Вызывается под блокировкой.
+func (s *Store) listKeys(opts ListOptions) ([]seriesKey, int) {
+	c, _ := opts.cursor()
+	var keys []seriesKey
+	total := 0
+	visit := func(key seriesKey) {
+		if !opts.matches(key) {
+			return
+		}
+		total++
+		if c != nil && !opts.less(seriesKey{mType: c.Type, id: c.ID}, key) {
+			return
+		}
+		keys = append(keys, key)
+	}
+	if opts.Type != "counter" {
+		for id := range s.gaugeStore {
+			visit(seriesKey{mType: "gauge", id: id})
+		}
+	}
+	if opts.Type != "gauge" {
+		for id := range s.counterStore {
+			visit(seriesKey{mType: "counter", id: id})
+		}
+	}
+	return keys, total
+}
+
+// readMetrics копирует значения серий в порядке keys, пропуская серии, которых уже нет.
+// Вызывается под блокировкой.
+func (s *Store) readMetrics(keys []seriesKey) []models.Metrics {
+	metrics := make([]models.Metrics, 0, len(keys))
+	for _, key := range keys {
+		m := models.Metrics{ID: key.id, MType: key.mType}
+		if key.mType == "gauge" {
+			value, ok := s.gaugeStore[key.id]
+			if !ok {
+				continue
+			}
+			m.Value = &value
+		} else {
+			delta, ok := s.counterStore[key.id]
+			if !ok {
+				continue
+			}
+			m.Delta = &delta
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
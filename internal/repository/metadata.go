@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"monalert/internal/models"
+)
+
+// SetMetadata сохраняет описание метрики. Описание привязано к имени, а не к серии,
+// поэтому его можно задать заранее и оно переживает удаление серии.
+func (s *Store) SetMetadata(ctx context.Context, name string, md models.Metadata) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("repository: metadata update cancelled: %w", err)
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.metadata[name] = md
+	return nil
+}
+
+func (s *Store) GetMetadata(ctx context.Context, name string) (*models.Metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("repository: read cancelled: %w", err)
+	}
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	md, ok := s.metadata[name]
+	if !ok {
+		return nil, NewFieldError("", ErrNotFound, "no metadata for metric %s", name)
+	}
+	return &md, nil
+}
+
+// AllMetadata возвращает копию всех описаний.
+func (s *Store) AllMetadata(ctx context.Context) (map[string]models.Metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("repository: read cancelled: %w", err)
+	}
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return maps.Clone(s.metadata), nil
+}
@@ -2,13 +2,17 @@ package repository
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"maps"
 	"monalert/internal/logger"
 	"monalert/internal/models"
 	"os"
+	"path/filepath"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -17,47 +21,206 @@ type Store struct {
 	mux          *sync.RWMutex
 	gaugeStore   map[string]float64
 	counterStore map[string]int64
-	filePath     string
+	// последние накопленные значения счётчиков, присланных в режиме cumulative:
+	// имя счётчика → источник → значение. У каждого агента свой накопленный итог,
+	// поэтому агенты, которые пишут один счётчик, не сбрасывают друг другу базу
+	cumulativeStore map[string]map[string]int64
+	history         map[seriesKey]*history
+	metadata        map[string]models.Metadata
+	cardinality     cardinality
+	filePath        string
+	// persisting — семафор на одну запись файла. Держится от снимка до переименования:
+	// иначе запись со старым снимком могла бы закончиться позже и затереть более новый файл
+	persisting chan struct{}
+}
+
+// snapshot — формат файла, в который сохраняется хранилище.
+type snapshot struct {
+	Metrics []models.Metrics `json:"metrics"`
+	// Cumulative — базы счётчиков из файлов старого формата, без источника
+	Cumulative        map[string]int64            `json:"cumulative,omitempty"`
+	CumulativeSources map[string]map[string]int64 `json:"cumulative_sources,omitempty"`
+	Metadata          map[string]models.Metadata  `json:"metadata,omitempty"`
 }
 
 func NewStore(filepath string, syncOnUpdate bool) *Store {
 	return &Store{
-		mux:          &sync.RWMutex{},
-		gaugeStore:   make(map[string]float64),
-		counterStore: make(map[string]int64),
-		filePath:     filepath,
+		mux:             &sync.RWMutex{},
+		gaugeStore:      make(map[string]float64),
+		counterStore:    make(map[string]int64),
+		cumulativeStore: make(map[string]map[string]int64),
+		history:         make(map[seriesKey]*history),
+		metadata:        make(map[string]models.Metadata),
+		cardinality:     newCardinality(),
+		filePath:        filepath,
+		persisting:      make(chan struct{}, 1),
+	}
+}
+
+func (s *Store) MetricUpdate(ctx context.Context, req *models.Metrics) (*models.Metrics, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("repository: update cancelled: %w", err)
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := checkUpdate(req); err != nil {
+		return nil, err
+	}
+	if !s.exists(req) {
+		if err := s.admitSeries(req, 0); err != nil {
+			return nil, err
+		}
 	}
+	resp := s.apply(req)
+	return &resp, nil
 }
 
-func (s *Store) MetricUpdate(req *models.Metrics) (*models.Metrics, error) {
+// MetricUpdates записывает батч атомарно. Под одной блокировкой сначала проверяются все метрики
+// и лимиты на новые серии, и только если проходит весь батч, он применяется. Поэтому отклонённый
+// батч можно повторить целиком: приращения счётчиков не учтутся дважды. Ошибка указывает
+// на метрику батча полем вида [3].value, см. BatchFieldError.
+func (s *Store) MetricUpdates(ctx context.Context, reqs []models.Metrics) ([]models.Metrics, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("repository: update cancelled: %w", err)
+	}
 	s.mux.Lock()
 	defer s.mux.Unlock()
+	// новые серии батча и начало окна лимита, в котором каждую допустили
+	created := make(map[seriesKey]time.Time)
+	for i := range reqs {
+		req := &reqs[i]
+		if err := checkUpdate(req); err != nil {
+			s.releaseSeries(created)
+			return nil, BatchFieldError(i, err)
+		}
+		key := seriesKey{mType: req.MType, id: req.ID}
+		if _, ok := created[key]; ok || s.exists(req) {
+			continue
+		}
+		if err := s.admitSeries(req, len(created)); err != nil {
+			s.releaseSeries(created)
+			return nil, BatchFieldError(i, err)
+		}
+		created[key] = s.cardinality.windowStart
+	}
+	resp := make([]models.Metrics, 0, len(reqs))
+	for i := range reqs {
+		resp = append(resp, s.apply(&reqs[i]))
+	}
+	logger.Log.Debug("repository: storage updated metric batch", zap.Int("count", len(reqs)), zap.Int("new_series", len(created)))
+	return resp, nil
+}
+
+// checkUpdate проверяет, что обновление можно применить.
+func checkUpdate(req *models.Metrics) error {
 	switch req.MType {
 	case "gauge":
-		logger.Log.Debug("repository: storage updated metric request", zap.String("type", req.MType), zap.String("name", req.ID))
+		if req.Value == nil {
+			return NewFieldError("value", ErrInvalidValue, "gauge %s without value", req.ID)
+		}
+	case "counter":
+		if req.Delta == nil {
+			return NewFieldError("delta", ErrInvalidValue, "counter %s without delta", req.ID)
+		}
+	default:
+		return unsupportedType(req.MType)
+	}
+	return nil
+}
+
+// exists сообщает, есть ли уже серия метрики. Вызывается под блокировкой.
+func (s *Store) exists(req *models.Metrics) bool {
+	var ok bool
+	if req.MType == "gauge" {
+		_, ok = s.gaugeStore[req.ID]
+	} else {
+		_, ok = s.counterStore[req.ID]
+	}
+	return ok
+}
+
+// apply записывает проверенное обновление и возвращает значение после записи.
+// Вызывается под блокировкой.
+func (s *Store) apply(req *models.Metrics) models.Metrics {
+	if req.MType == "gauge" {
 		s.gaugeStore[req.ID] = *req.Value
 		val := s.gaugeStore[req.ID]
+		s.record(req.MType, req.ID, val)
 		logger.Log.Debug("repository: storage updated metric", zap.String("type", req.MType), zap.String("name", req.ID), zap.Float64("value:", val))
-		return &models.Metrics{
+		return models.Metrics{
 			ID:    req.ID,
 			MType: "gauge",
 			Value: &val,
-		}, nil
-	case "counter":
-		s.counterStore[req.ID] += *req.Delta
-		val := s.counterStore[req.ID]
-		logger.Log.Debug("repository: storage updated metric", zap.String("type", req.MType), zap.String("name", req.ID), zap.Int64("value:", val))
-		return &models.Metrics{
-			ID:    req.ID,
-			MType: "counter",
-			Delta: &val,
-		}, nil
+		}
+	}
+	switch {
+	case req.Replace:
+		s.setCounter(req.ID, req.Source, *req.Delta)
+	case req.Cumulative:
+		s.counterStore[req.ID] += s.cumulativeDelta(req.ID, req.Source, *req.Delta)
 	default:
-		return nil, fmt.Errorf("repository: storage doesn't support this type of metrics: %s", req.MType)
+		s.counterStore[req.ID] += *req.Delta
 	}
+	val := s.counterStore[req.ID]
+	s.record(req.MType, req.ID, float64(val))
+	logger.Log.Debug("repository: storage updated metric", zap.String("type", req.MType), zap.String("name", req.ID), zap.Int64("value:", val))
+	return models.Metrics{
+		ID:    req.ID,
+		MType: "counter",
+		Delta: &val,
+	}
+}
+
+// admitSeries проверяет лимиты перед созданием новой серии. pending — сколько новых серий
+// батча уже допущено, но ещё не записано. Вызывается под блокировкой.
+func (s *Store) admitSeries(req *models.Metrics, pending int) error {
+	key := seriesKey{mType: req.MType, id: req.ID}
+	series := len(s.gaugeStore) + len(s.counterStore) + pending
+	if err := s.cardinality.admit(key, req.Source, series, time.Now()); err != nil {
+		logger.Log.Warn("repository: new series rejected", zap.String("type", req.MType), zap.String("name", req.ID),
+			zap.String("source", req.Source), zap.Error(err))
+		return NewFieldError("id", err, "cannot create series %s: %s", req.ID, err)
+	}
+	return nil
 }
 
-func (s *Store) GetMetric(req *models.Metrics) (*models.Metrics, error) {
+// releaseSeries возвращает в лимиты серии отклонённого батча. Вызывается под блокировкой.
+func (s *Store) releaseSeries(created map[seriesKey]time.Time) {
+	for key, window := range created {
+		s.cardinality.release(key, window)
+	}
+}
+
+// cumulativeDelta переводит накопленное клиентом значение в приращение. База хранится
+// отдельно для каждого источника. Если значение меньше прошлого, считаем, что счётчик
+// клиента сбросился, и всё новое значение — приращение. Вызывается под блокировкой.
+func (s *Store) cumulativeDelta(id, source string, value int64) int64 {
+	sources := s.cumulativeStore[id]
+	if sources == nil {
+		sources = make(map[string]int64)
+		s.cumulativeStore[id] = sources
+	}
+	last, ok := sources[source]
+	sources[source] = value
+	if !ok || value < last {
+		return value
+	}
+	return value - last
+}
+
+// setCounter записывает значение счётчика вместо прибавления. База накопленных значений
+// источника, записавшего счётчик, сбрасывается: его следующее накопленное значение
+// считается с нуля. Базы других источников остаются, чтобы их следующие значения
+// добавили только настоящее приращение. Вызывается под блокировкой.
+func (s *Store) setCounter(id, source string, value int64) {
+	s.counterStore[id] = value
+	delete(s.cumulativeStore[id], source)
+}
+
+func (s *Store) GetMetric(ctx context.Context, req *models.Metrics) (*models.Metrics, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("repository: read cancelled: %w", err)
+	}
 	s.mux.RLock()
 	defer s.mux.RUnlock()
 	switch req.MType {
@@ -70,7 +233,7 @@ func (s *Store) GetMetric(req *models.Metrics) (*models.Metrics, error) {
 				Value: &val,
 			}, nil
 		} else {
-			return nil, fmt.Errorf("repository: no metric in storage with provided name: %s", req.ID)
+			return nil, notFound(req.MType, req.ID)
 		}
 	case "counter":
 		if val, ok := s.counterStore[req.ID]; ok {
@@ -81,16 +244,56 @@ func (s *Store) GetMetric(req *models.Metrics) (*models.Metrics, error) {
 				Delta: &val,
 			}, nil
 		} else {
-			return nil, fmt.Errorf("repository: no metric in storage with provided type: %s and name: %s", req.MType, req.ID)
+			return nil, notFound(req.MType, req.ID)
 		}
 	default:
-		return nil, fmt.Errorf("repository: storage doesn't support this type of metrics: %s", req.MType)
+		return nil, unsupportedType(req.MType)
+	}
+}
+
+// record добавляет значение в историю серии. Вызывается под блокировкой.
+func (s *Store) record(mType, id string, value float64) {
+	key := seriesKey{mType: mType, id: id}
+	h, ok := s.history[key]
+	if !ok {
+		h = &history{}
+		s.history[key] = h
 	}
+	h.add(value, time.Now())
 }
 
-func (s *Store) GetAllMetrics() []models.Metrics {
+// GetHistory возвращает последние значения серии от старых к новым.
+func (s *Store) GetHistory(ctx context.Context, req *models.Metrics) ([]models.Sample, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("repository: read cancelled: %w", err)
+	}
 	s.mux.RLock()
 	defer s.mux.RUnlock()
+	switch req.MType {
+	case "gauge", "counter":
+		h, ok := s.history[seriesKey{mType: req.MType, id: req.ID}]
+		if !ok {
+			return nil, notFound(req.MType, req.ID)
+		}
+		return h.list(), nil
+	default:
+		return nil, unsupportedType(req.MType)
+	}
+}
+
+func (s *Store) GetAllMetrics(ctx context.Context) ([]models.Metrics, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("repository: read cancelled: %w", err)
+	}
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	allMetrics := s.allMetrics()
+	logger.Log.Debug("repository: storage provided all metric")
+	return allMetrics, nil
+}
+
+// allMetrics вызывается под блокировкой.
+func (s *Store) allMetrics() []models.Metrics {
 	allMetrics := make([]models.Metrics, 0, len(s.gaugeStore)+len(s.counterStore))
 	for name, value := range s.gaugeStore {
 		allMetrics = append(allMetrics, models.Metrics{
@@ -106,30 +309,71 @@ func (s *Store) GetAllMetrics() []models.Metrics {
 			Delta: &delta,
 		})
 	}
-	logger.Log.Debug("repository: storage provided all metric")
 	return allMetrics
 }
 
-func (s *Store) Persist() error {
-	file, err := os.OpenFile(s.filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+func (s *Store) snapshot() snapshot {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	cumulative := make(map[string]map[string]int64, len(s.cumulativeStore))
+	for id, sources := range s.cumulativeStore {
+		cumulative[id] = maps.Clone(sources)
+	}
+	return snapshot{
+		Metrics:           s.allMetrics(),
+		CumulativeSources: cumulative,
+		Metadata:          maps.Clone(s.metadata),
+	}
+}
+
+// persistChunkSize — по сколько байт пишется файл между проверками отмены.
+const persistChunkSize = 64 << 10
+
+// Persist сохраняет хранилище в файл. Данные пишутся во временный файл рядом и заменяют
+// старый переименованием, поэтому отмена ctx посреди записи оставляет прежний файл целым.
+// Одновременные вызовы выполняются по очереди, и снимок берётся уже после ожидания,
+// так что последним в файл попадает самый свежий снимок.
+func (s *Store) Persist(ctx context.Context) error {
+	select {
+	case s.persisting <- struct{}{}:
+	case <-ctx.Done():
+		return fmt.Errorf("repository: persist cancelled: %w", ctx.Err())
+	}
+	defer func() { <-s.persisting }()
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("repository: persist cancelled: %w", err)
+	}
+	data, err := json.MarshalIndent(s.snapshot(), "", " ")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	data, err := json.MarshalIndent(s.GetAllMetrics(), "", " ")
+	file, err := os.CreateTemp(filepath.Dir(s.filePath), filepath.Base(s.filePath)+".tmp*")
 	if err != nil {
 		return err
 	}
-
-	_, err = file.Write(data)
-	if err != nil {
+	defer os.Remove(file.Name()) //nolint:errcheck // после переименования временного файла уже нет
+	defer file.Close()
+	for len(data) > 0 {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("repository: persist cancelled: %w", err)
+		}
+		n, err := file.Write(data[:min(len(data), persistChunkSize)])
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(file.Name(), s.filePath); err != nil {
 		return err
 	}
 	logger.Log.Debug("data saved to file")
 	return nil
 }
 
-func (s *Store) Restore() error {
+func (s *Store) Restore(ctx context.Context) error {
 	file, err := os.OpenFile(s.filePath, os.O_RDONLY|os.O_CREATE, 0o600)
 	if err != nil {
 		return fmt.Errorf("cannot open file for restore: %w, file path: %s", err, s.filePath)
@@ -145,16 +389,34 @@ func (s *Store) Restore() error {
 		return nil
 	}
 
-	var allMetrics []models.Metrics
-	if err := json.Unmarshal(data, &allMetrics); err != nil {
+	var snap snapshot
+	if data = bytes.TrimSpace(data); data[0] == '[' {
+		// старый формат файла — просто массив метрик
+		err = json.Unmarshal(data, &snap.Metrics)
+	} else {
+		err = json.Unmarshal(data, &snap)
+	}
+	if err != nil {
 		return fmt.Errorf("cannot unmarshal data in restore file %w", err)
 	}
-	for _, metric := range allMetrics {
-		_, err = s.MetricUpdate(&metric)
+	for _, metric := range snap.Metrics {
+		metric.Cumulative = false
+		_, err = s.MetricUpdate(ctx, &metric)
 		if err != nil {
 			return fmt.Errorf("cannot add metric from restore file %w", err)
 		}
 	}
+	s.mux.Lock()
+	for id, value := range snap.Cumulative {
+		s.cumulativeStore[id] = map[string]int64{"": value}
+	}
+	for id, sources := range snap.CumulativeSources {
+		s.cumulativeStore[id] = maps.Clone(sources)
+	}
+	for name, md := range snap.Metadata {
+		s.metadata[name] = md
+	}
+	s.mux.Unlock()
 	fmt.Println("data restored from file")
 	return nil
 }
@@ -0,0 +1,377 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"monalert/internal/models"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gauge(id string, v float64) models.Metrics {
+	return models.Metrics{ID: id, MType: "gauge", Value: &v}
+}
+
+func counter(id string, d int64) models.Metrics {
+	return models.Metrics{ID: id, MType: "counter", Delta: &d}
+}
+
+func counterValue(t *testing.T, s *Store, id string) int64 {
+	t.Helper()
+	m, err := s.GetMetric(context.Background(), &models.Metrics{ID: id, MType: "counter"})
+	require.NoError(t, err)
+	return *m.Delta
+}
+
+func TestMetricUpdatesAppliesBatch(t *testing.T) {
+	s := NewStore("", false)
+	ctx := context.Background()
+	cumulative := counter("total", 10)
+	cumulative.Cumulative = true
+	resp, err := s.MetricUpdates(ctx, []models.Metrics{counter("hits", 2), gauge("temp", 1.5), counter("hits", 3), cumulative})
+	require.NoError(t, err)
+	assert.Equal(t, []models.Metrics{counter("hits", 2), gauge("temp", 1.5), counter("hits", 5), counter("total", 10)}, resp)
+	assert.Equal(t, 3, s.CardinalityStats().Series)
+}
+
+func TestCumulativeDeltaPerSource(t *testing.T) {
+	ctx := context.Background()
+	total := func(source string, v int64) *models.Metrics {
+		m := counter("PollCount", v)
+		m.Cumulative, m.Source = true, source
+		return &m
+	}
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	s := NewStore(path, false)
+	// итоги двух агентов чередуются, но у каждого своя база, и сбросом это не считается
+	for _, m := range []*models.Metrics{total("a", 5), total("b", 2), total("a", 7), total("b", 3), total("a", 7)} {
+		_, err := s.MetricUpdate(ctx, m)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int64(10), counterValue(t, s, "PollCount"))
+
+	// повтор уже принятого итога после перезапуска сервера ничего не прибавляет
+	require.NoError(t, s.Persist(ctx))
+	restored := NewStore(path, false)
+	require.NoError(t, restored.Restore(ctx))
+	_, err := restored.MetricUpdate(ctx, total("b", 3))
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), counterValue(t, restored, "PollCount"))
+
+	// база из файла старого формата относится к источнику без имени
+	require.NoError(t, os.WriteFile(path, []byte(`{"metrics":[{"id":"PollCount","type":"counter","delta":4}],"cumulative":{"PollCount":4}}`), 0o600))
+	legacy := NewStore(path, false)
+	require.NoError(t, legacy.Restore(ctx))
+	_, err = legacy.MetricUpdate(ctx, total("", 6))
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), counterValue(t, legacy, "PollCount"))
+}
+
+func TestReplaceSetsCounter(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore("", false)
+	update := func(m models.Metrics, replace, cumulative bool, source string) {
+		t.Helper()
+		m.Replace, m.Cumulative, m.Source = replace, cumulative, source
+		_, err := s.MetricUpdate(ctx, &m)
+		require.NoError(t, err)
+	}
+	update(counter("hits", 60), false, false, "")
+	update(counter("hits", 40), false, true, "a")
+	update(counter("hits", 5), false, true, "b")
+	update(counter("hits", 50), true, false, "a")
+	assert.Equal(t, int64(50), counterValue(t, s, "hits"))
+	update(counter("hits", 3), true, false, "a")
+	assert.Equal(t, int64(3), counterValue(t, s, "hits"))
+
+	// база записавшего источника сброшена, база другого источника осталась
+	update(counter("hits", 40), false, true, "a")
+	update(counter("hits", 7), false, true, "b")
+	assert.Equal(t, int64(45), counterValue(t, s, "hits"))
+}
+
+func TestPersistIsSerialized(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	s := NewStore(path, false)
+	_, err := s.MetricUpdate(ctx, ptr(counter("hits", 1)))
+	require.NoError(t, err)
+
+	// пока идёт другая запись, Persist ждёт и снимок берёт только после неё
+	s.persisting <- struct{}{}
+	done := make(chan error, 1)
+	go func() { done <- s.Persist(ctx) }()
+	_, err = s.MetricUpdate(ctx, ptr(counter("hits", 2)))
+	require.NoError(t, err)
+	select {
+	case err := <-done:
+		t.Fatalf("Persist did not wait for the running write: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-s.persisting
+	require.NoError(t, <-done)
+	restored := NewStore(path, false)
+	require.NoError(t, restored.Restore(ctx))
+	assert.Equal(t, int64(3), counterValue(t, restored, "hits"))
+
+	// ожидание очереди прерывается отменой
+	s.persisting <- struct{}{}
+	cancelCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, s.Persist(cancelCtx), context.DeadlineExceeded)
+	<-s.persisting
+
+	// параллельные записи не оставляют устаревший файл
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.MetricUpdate(ctx, ptr(counter("hits", 1)))
+			assert.NoError(t, err)
+			assert.NoError(t, s.Persist(ctx))
+		}()
+	}
+	wg.Wait()
+	restored = NewStore(path, false)
+	require.NoError(t, restored.Restore(ctx))
+	assert.Equal(t, int64(23), counterValue(t, restored, "hits"))
+}
+
+func TestMetricUpdatesIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	newStore := func(t *testing.T) *Store {
+		s := NewStore("", false)
+		_, err := s.MetricUpdate(ctx, ptr(counter("hits", 1)))
+		require.NoError(t, err)
+		return s
+	}
+
+	t.Run("invalid metric", func(t *testing.T) {
+		s := newStore(t)
+		_, err := s.MetricUpdates(ctx, []models.Metrics{counter("hits", 5), {ID: "broken", MType: "counter"}})
+		require.ErrorIs(t, err, ErrInvalidValue)
+		var fieldErr *FieldError
+		require.True(t, errors.As(err, &fieldErr))
+		assert.Equal(t, "[1].delta", fieldErr.Field)
+		assert.Equal(t, "metric 1: counter broken without delta", fieldErr.Message)
+		assert.Equal(t, int64(1), counterValue(t, s, "hits"))
+	})
+
+	t.Run("cardinality limit", func(t *testing.T) {
+		s := newStore(t)
+		s.SetCardinalityLimits(CardinalityLimits{MaxSeries: 2, MaxNewSeriesPerMin: 10})
+		batch := []models.Metrics{counter("hits", 5), gauge("a", 1), gauge("a", 2), gauge("b", 1)}
+		_, err := s.MetricUpdates(ctx, batch)
+		require.ErrorIs(t, err, ErrSeriesLimit)
+		var fieldErr *FieldError
+		require.True(t, errors.As(err, &fieldErr))
+		assert.Equal(t, "[3].id", fieldErr.Field)
+
+		// ничего не записано, а допущенная было серия a вернулась в лимиты
+		assert.Equal(t, int64(1), counterValue(t, s, "hits"))
+		stats := s.CardinalityStats()
+		assert.Equal(t, 1, stats.Series)
+		assert.Zero(t, stats.NewSeriesLastMinute)
+		assert.Equal(t, int64(1), stats.Rejected)
+
+		// повтор того же батча после увеличения лимита учитывает приращение один раз
+		s.SetCardinalityLimits(CardinalityLimits{MaxSeries: 3})
+		resp, err := s.MetricUpdates(ctx, batch)
+		require.NoError(t, err)
+		assert.Equal(t, counter("hits", 6), resp[0])
+		assert.Equal(t, 3, s.CardinalityStats().Series)
+	})
+
+	t.Run("per agent limit", func(t *testing.T) {
+		s := newStore(t)
+		s.SetCardinalityLimits(CardinalityLimits{MaxSeriesPerAgent: 1})
+		a, b := gauge("a", 1), gauge("b", 1)
+		a.Source, b.Source = "agent", "agent"
+		_, err := s.MetricUpdates(ctx, []models.Metrics{a, b})
+		require.ErrorIs(t, err, ErrAgentSeriesLimit)
+		// серия a не записана и не занимает лимит агента
+		_, err = s.MetricUpdates(ctx, []models.Metrics{a})
+		require.NoError(t, err)
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		s := newStore(t)
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := s.MetricUpdates(cancelled, []models.Metrics{counter("hits", 5)})
+		require.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, int64(1), counterValue(t, s, "hits"))
+	})
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestWalkMetrics(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore("", false)
+	_, err := s.MetricUpdates(ctx, []models.Metrics{gauge("d", 4), counter("a", 1), gauge("b", 2), gauge("c", 3), gauge("f", 6)})
+	require.NoError(t, err)
+
+	var chunks [][]models.Metrics
+	total := 0
+	count := func(n int) error {
+		total = n
+		return nil
+	}
+	err = s.WalkMetrics(ctx, ListOptions{Type: "gauge", Limit: 1}, 2, count, func(chunk []models.Metrics) error {
+		chunks = append(chunks, chunk)
+		if len(chunks) == 1 {
+			// серия, удалённая после снятия списка, пропускается, а новая в него не попадает
+			require.NoError(t, s.DeleteMetric(ctx, &models.Metrics{ID: "d", MType: "gauge"}))
+			_, err := s.MetricUpdate(ctx, ptr(gauge("e", 5)))
+			require.NoError(t, err)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	// Limit не учитывается, порции идут в порядке выборки; start получил число ключей до удаления
+	assert.Equal(t, [][]models.Metrics{{gauge("b", 2), gauge("c", 3)}, {gauge("f", 6)}}, chunks)
+	assert.Equal(t, 4, total)
+
+	t.Run("callback error", func(t *testing.T) {
+		stop := errors.New("stop")
+		calls := 0
+		err := s.WalkMetrics(ctx, ListOptions{}, 1, count, func([]models.Metrics) error {
+			calls++
+			return stop
+		})
+		require.ErrorIs(t, err, stop)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		err := s.WalkMetrics(ctx, ListOptions{Sort: "value"}, 1, count, func([]models.Metrics) error {
+			t.Fatal("callback called")
+			return nil
+		})
+		require.ErrorIs(t, err, ErrInvalidValue)
+	})
+}
+
+func TestListMetrics(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore("", false)
+	_, err := s.MetricUpdates(ctx, []models.Metrics{
+		gauge("node.cpu", 0.5), counter("node.cpu", 7), gauge("node.mem", 2), gauge("app.rps", 3), counter("app.errors", 1),
+	})
+	require.NoError(t, err)
+	key := func(m models.Metrics) string { return m.MType + "/" + m.ID }
+	keys := func(metrics []models.Metrics) []string {
+		keys := []string{}
+		for _, m := range metrics {
+			keys = append(keys, key(m))
+		}
+		return keys
+	}
+
+	tests := []struct {
+		name      string
+		opts      ListOptions
+		want      []string
+		wantTotal int
+	}{
+		{
+			name:      "all by id",
+			want:      []string{"counter/app.errors", "gauge/app.rps", "counter/node.cpu", "gauge/node.cpu", "gauge/node.mem"},
+			wantTotal: 5,
+		},
+		{
+			name:      "by type descending",
+			opts:      ListOptions{Sort: SortByType, Desc: true},
+			want:      []string{"gauge/node.mem", "gauge/node.cpu", "gauge/app.rps", "counter/node.cpu", "counter/app.errors"},
+			wantTotal: 5,
+		},
+		{
+			name:      "type and prefix",
+			opts:      ListOptions{Type: "gauge", Prefix: "node."},
+			want:      []string{"gauge/node.cpu", "gauge/node.mem"},
+			wantTotal: 2,
+		},
+		{
+			name:      "match",
+			opts:      ListOptions{Match: regexp.MustCompile(`\.(cpu|rps)$`)},
+			want:      []string{"gauge/app.rps", "counter/node.cpu", "gauge/node.cpu"},
+			wantTotal: 3,
+		},
+		{name: "nothing matches", opts: ListOptions{Prefix: "db."}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := s.ListMetrics(ctx, tt.opts)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, keys(page.Metrics))
+			assert.Equal(t, tt.wantTotal, page.Total)
+			assert.Empty(t, page.Next)
+		})
+	}
+
+	t.Run("values", func(t *testing.T) {
+		page, err := s.ListMetrics(ctx, ListOptions{Prefix: "node.cpu"})
+		require.NoError(t, err)
+		assert.Equal(t, []models.Metrics{counter("node.cpu", 7), gauge("node.cpu", 0.5)}, page.Metrics)
+	})
+
+	t.Run("pages", func(t *testing.T) {
+		opts := ListOptions{Sort: SortByType, Limit: 2}
+		var got []string
+		for {
+			page, err := s.ListMetrics(ctx, opts)
+			require.NoError(t, err)
+			assert.Equal(t, 5, page.Total)
+			got = append(got, keys(page.Metrics)...)
+			if page.Next == "" {
+				break
+			}
+			assert.Len(t, page.Metrics, 2)
+			opts.Cursor = page.Next
+			if len(got) == 2 {
+				// страницы не съезжают, когда серии удаляются или добавляются между запросами
+				require.NoError(t, s.DeleteMetric(ctx, &models.Metrics{ID: "app.errors", MType: "counter"}))
+				_, err := s.MetricUpdate(ctx, ptr(counter("zz", 1)))
+				require.NoError(t, err)
+			}
+		}
+		assert.Equal(t, []string{"counter/app.errors", "counter/node.cpu", "counter/zz", "gauge/app.rps", "gauge/node.cpu", "gauge/node.mem"}, got)
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		next := ListOptions{Limit: 1}.NextCursor(gauge("app.rps", 0))
+		for _, tt := range []struct {
+			opts  ListOptions
+			field string
+		}{
+			{opts: ListOptions{Type: "histogram"}, field: "type"},
+			{opts: ListOptions{Sort: "value"}, field: "sort"},
+			{opts: ListOptions{Limit: -1}, field: "limit"},
+			{opts: ListOptions{Cursor: "%%%"}, field: "cursor"},
+			{opts: ListOptions{Cursor: next, Desc: true}, field: "cursor"},
+		} {
+			_, err := s.ListMetrics(ctx, tt.opts)
+			var fieldErr *FieldError
+			require.True(t, errors.As(err, &fieldErr), tt.field)
+			assert.Equal(t, tt.field, fieldErr.Field)
+		}
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := s.ListMetrics(cancelled, ListOptions{})
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"monalert/internal/logger"
+	"monalert/internal/models"
+	"monalert/internal/repository"
+)
+
+// selfMetadata описывает метрики самого сервера, задать их описания через API нельзя.
+// Ключ — имя без сегментов меток, см. selfMetricFamily.
+var selfMetadata = map[string]models.Metadata{
+	"monalert_series":                               {Type: "gauge", Help: "Number of series in storage."},
+	"monalert_series_limit":                         {Type: "gauge", Help: "Max number of series, 0 if unlimited."},
+	"monalert_new_series_last_minute":               {Type: "gauge", Help: "Number of series created during the current minute."},
+	"monalert_new_series_per_minute_limit":          {Type: "gauge", Help: "Max number of new series per minute, 0 if unlimited."},
+	"monalert_series_per_agent_limit":               {Type: "gauge", Help: "Max number of series created by one agent, 0 if unlimited."},
+	"monalert_series_rejected_total":                {Type: "counter", Help: "Number of new series rejected by cardinality limits."},
+	"monalert_ingested_metrics_total":               {Type: "counter", Help: "Number of metric updates accepted."},
+	"monalert_ingestion_rate":                       {Type: "gauge", Help: "Metric updates accepted per second, averaged over the last minute."},
+	"monalert_persist_failures_total":               {Type: "counter", Help: "Number of failed saves of the storage file."},
+	"monalert_persist_duration_seconds_bucket":      {Type: "counter", Help: "Storage file saves that took no longer than le seconds."},
+	"monalert_persist_duration_seconds_sum":         {Type: "gauge", Unit: "seconds", Help: "Total time spent saving the storage file."},
+	"monalert_persist_duration_seconds_count":       {Type: "counter", Help: "Number of storage file saves."},
+	"monalert_http_requests_total":                  {Type: "counter", Help: "Number of HTTP requests by route and status."},
+	"monalert_http_request_duration_seconds_bucket": {Type: "counter", Help: "HTTP requests by route and status that took no longer than le seconds."},
+	"monalert_http_request_duration_seconds_sum":    {Type: "gauge", Unit: "seconds", Help: "Total time spent serving HTTP requests by route and status."},
+	"monalert_http_request_duration_seconds_count":  {Type: "counter", Help: "Number of HTTP requests by route and status."},
+	"monalert_uptime_seconds":                       {Type: "gauge", Unit: "seconds", Help: "Time since the server started."},
+	"monalert_go_goroutines":                        {Type: "gauge", Help: "Number of goroutines."},
+	"monalert_go_heap_alloc_bytes":                  {Type: "gauge", Unit: "bytes", Help: "Bytes of allocated heap objects."},
+	"monalert_go_heap_inuse_bytes":                  {Type: "gauge", Unit: "bytes", Help: "Bytes in in-use heap spans."},
+	"monalert_go_sys_bytes":                         {Type: "gauge", Unit: "bytes", Help: "Bytes of memory obtained from the OS."},
+	"monalert_go_gc_total":                          {Type: "counter", Help: "Number of completed GC cycles."},
+	"monalert_go_gc_pause_seconds_total":            {Type: "gauge", Unit: "seconds", Help: "Total time of GC stop-the-world pauses."},
+}
+
+// selfHistograms описывает гистограммы сервера целиком: так они отдаются в /metrics.
+// Описания их серий _bucket, _sum и _count — в selfMetadata.
+var selfHistograms = map[string]models.Metadata{
+	"monalert_persist_duration_seconds":      {Unit: "seconds", Help: "Time spent saving the storage file."},
+	"monalert_http_request_duration_seconds": {Unit: "seconds", Help: "Time spent serving HTTP requests by route and status."},
+}
+
+func (m *Monalert) SetMetadata(ctx context.Context, name string, md models.Metadata) error {
+	logger.Log.Debug("service: request for metadata update")
+	if models.IsReservedName(name) {
+		return fmt.Errorf("service: failed to update metadata: %w",
+			repository.NewFieldError("name", ErrReservedName, "metric name %s is reserved for server metrics", name))
+	}
+	if err := ValidateMetadata(&md); err != nil {
+		return fmt.Errorf("service: failed to update metadata: %w", err)
+	}
+	if err := m.store.SetMetadata(ctx, name, md); err != nil {
+		return fmt.Errorf("service: failed to update metadata: %w", err)
+	}
+	m.persistSync(ctx, "metadata update")
+	return nil
+}
+
+func (m *Monalert) GetMetadata(ctx context.Context, name string) (*models.Metadata, error) {
+	if md, ok := selfMetadata[selfMetricFamily(name)]; ok {
+		return &md, nil
+	}
+	md, err := m.store.GetMetadata(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("service: failed to get metadata: %w", err)
+	}
+	return md, nil
+}
+
+// AllMetadata возвращает описания всех метрик, включая метрики сервера.
+func (m *Monalert) AllMetadata(ctx context.Context) (map[string]models.Metadata, error) {
+	all, err := m.store.AllMetadata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("service: failed to get metadata: %w", err)
+	}
+	// у серий с метками описание общее на всё семейство
+	for _, metric := range m.selfMetrics() {
+		if md, ok := selfMetadata[selfMetricFamily(metric.ID)]; ok {
+			all[metric.ID] = md
+		}
+	}
+	return all, nil
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"monalert/internal/models"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SelfMetricsPrefix — общий префикс имён метрик самого сервера. Зарезервирован не весь префикс,
+// а только имена этих метрик, см. models.IsReservedName.
+const SelfMetricsPrefix = "monalert_"
+
+// Family — семейство метрик сервера в модели Prometheus: серии с общим именем, типом
+// и описанием, которые различаются метками. Type — gauge, counter или histogram.
+type Family struct {
+	Name   string
+	Type   string
+	Help   string
+	Series []Series
+}
+
+// Series — серия семейства. Suffix у гистограммы — _bucket, _sum или _count, у остальных
+// типов пустой. Метки идут в порядке имён.
+type Series struct {
+	Suffix string
+	Labels []Label
+	Value  float64
+}
+
+// Label — метка серии.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// SelfMetricFamilies собирает метрики сервера на момент запроса семействами с метками,
+// в порядке имён. Так их отдаёт /metrics, а API чтения получает их развёрнутыми в серии без меток.
+func (m *Monalert) SelfMetricFamilies() []Family {
+	stats := m.store.CardinalityStats()
+	// нулевой лимит означает, что ограничение выключено
+	families := []Family{
+		selfGauge("monalert_series", float64(stats.Series)),
+		selfGauge("monalert_series_limit", float64(stats.Limits.MaxSeries)),
+		selfGauge("monalert_new_series_last_minute", float64(stats.NewSeriesLastMinute)),
+		selfGauge("monalert_new_series_per_minute_limit", float64(stats.Limits.MaxNewSeriesPerMin)),
+		selfGauge("monalert_series_per_agent_limit", float64(stats.Limits.MaxSeriesPerAgent)),
+		selfCounter("monalert_series_rejected_total", stats.Rejected),
+	}
+	families = append(families, m.stats.families(time.Now())...)
+	for i := range families {
+		if md, ok := selfHistograms[families[i].Name]; ok {
+			families[i].Help = md.Help
+		} else {
+			families[i].Help = selfMetadata[families[i].Name].Help
+		}
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].Name < families[j].Name })
+	return families
+}
+
+// selfMetrics собирает метрики сервера на момент запроса, в хранилище они не сохраняются.
+// У метрик хранилища нет меток, поэтому метки дописываются к имени сегментами .key_value,
+// как у агента при сборе метрик Prometheus, а граница +Inf становится le_inf. Бакеты
+// и _count гистограмм — счётчики, _sum — gauge.
+func (m *Monalert) selfMetrics() []models.Metrics {
+	var metrics []models.Metrics
+	for _, family := range m.SelfMetricFamilies() {
+		for _, series := range family.Series {
+			var id strings.Builder
+			id.WriteString(family.Name + series.Suffix)
+			for _, label := range series.Labels {
+				value := label.Value
+				if value == "+Inf" {
+					value = "inf"
+				}
+				id.WriteString("." + label.Name + "_" + value)
+			}
+			value := series.Value
+			if family.Type == "gauge" || series.Suffix == "_sum" {
+				metrics = append(metrics, models.Metrics{ID: id.String(), MType: "gauge", Value: &value})
+				continue
+			}
+			delta := int64(value)
+			metrics = append(metrics, models.Metrics{ID: id.String(), MType: "counter", Delta: &delta})
+		}
+	}
+	return metrics
+}
+
+// ObserveRequest учитывает обработанный HTTP-запрос. route — значение из RouteLabel.
+func (m *Monalert) ObserveRequest(route string, status int, duration time.Duration) {
+	m.stats.observeRequest(route, status, duration)
+}
+
+// Persist сохраняет хранилище в файл и учитывает длительность и ошибки сохранения.
+func (m *Monalert) Persist(ctx context.Context) error {
+	start := time.Now()
+	err := m.store.Persist(ctx)
+	m.stats.observePersist(time.Since(start), err)
+	return err
+}
+
+// selfMetricFamily — имя метрики сервера без сегментов меток: по нему ищется описание.
+func selfMetricFamily(id string) string {
+	family, _, _ := strings.Cut(id, ".")
+	return family
+}
+
+func selfGauge(name string, value float64) Family {
+	return Family{Name: name, Type: "gauge", Series: []Series{{Value: value}}}
+}
+
+func selfCounter(name string, value int64) Family {
+	return Family{Name: name, Type: "counter", Series: []Series{{Value: float64(value)}}}
+}
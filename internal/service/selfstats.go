@@ -0,0 +1,223 @@
+package service
+
+import (
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ingestionWindow — за сколько последних секунд считается скорость приёма метрик
+	ingestionWindow = 60
+	// runtimeStatsTTL — как долго переиспользуются runtime-метрики: ReadMemStats
+	// останавливает мир, а метрики сервера собираются на каждом чтении списка
+	runtimeStatsTTL = time.Second
+)
+
+// durationBuckets — верхние границы гистограмм длительности в секундах, как у клиентов
+// Prometheus по умолчанию. Последняя граница +Inf подразумевается.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogram — гистограмма длительностей. counts[i] — число наблюдений в i-м интервале,
+// накопительные значения бакетов считаются при выдаче.
+type histogram struct {
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]int64, len(durationBuckets))}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	v := d.Seconds()
+	if i := sort.SearchFloat64s(durationBuckets, v); i < len(durationBuckets) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+}
+
+// series разворачивает гистограмму в серии _bucket, _sum и _count семейства с метками labels.
+// Граница le идёт первой, как в порядке ключей у агента при сборе метрик Prometheus.
+func (h *histogram) series(labels []Label) []Series {
+	series := make([]Series, 0, len(durationBuckets)+3)
+	bucket := func(le string, count int64) Series {
+		return Series{Suffix: "_bucket", Labels: append([]Label{{Name: "le", Value: le}}, labels...), Value: float64(count)}
+	}
+	var cumulative int64
+	for i, le := range durationBuckets {
+		cumulative += h.counts[i]
+		series = append(series, bucket(strconv.FormatFloat(le, 'g', -1, 64), cumulative))
+	}
+	return append(series,
+		bucket("+Inf", h.count),
+		Series{Suffix: "_sum", Labels: labels, Value: h.sum},
+		Series{Suffix: "_count", Labels: labels, Value: float64(h.count)},
+	)
+}
+
+type requestKey struct {
+	route  string
+	status int
+}
+
+// selfStats накапливает метрики сервера в памяти процесса: сервер не отправляет их сам себе
+// по HTTP, а отдаёт вместе с метриками хранилища.
+type selfStats struct {
+	mux      sync.Mutex
+	started  time.Time
+	requests map[requestKey]*histogram
+
+	persist         *histogram
+	persistFailures int64
+
+	ingested int64
+	// ingestedBySecond — принятые метрики по секундам, ingestedSecond — какой секунде
+	// (unix-время) принадлежит ячейка; ячейки переиспользуются по кругу
+	ingestedBySecond [ingestionWindow]int64
+	ingestedSecond   [ingestionWindow]int64
+
+	runtime     []Family
+	runtimeRead time.Time
+}
+
+func newSelfStats() *selfStats {
+	return &selfStats{
+		started:  time.Now(),
+		requests: make(map[requestKey]*histogram),
+		persist:  newHistogram(),
+	}
+}
+
+func (s *selfStats) observeRequest(route string, status int, d time.Duration) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	key := requestKey{route: route, status: status}
+	h, ok := s.requests[key]
+	if !ok {
+		h = newHistogram()
+		s.requests[key] = h
+	}
+	h.observe(d)
+}
+
+func (s *selfStats) observePersist(d time.Duration, err error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.persist.observe(d)
+	if err != nil {
+		s.persistFailures++
+	}
+}
+
+func (s *selfStats) observeIngested(n int, now time.Time) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.ingested += int64(n)
+	sec := now.Unix()
+	i := sec % ingestionWindow
+	if s.ingestedSecond[i] != sec {
+		s.ingestedSecond[i] = sec
+		s.ingestedBySecond[i] = 0
+	}
+	s.ingestedBySecond[i] += int64(n)
+}
+
+// ingestionRate — метрик в секунду в среднем за последние ingestionWindow секунд.
+func (s *selfStats) ingestionRate(now time.Time) float64 {
+	sec := now.Unix()
+	var total int64
+	for i, second := range s.ingestedSecond {
+		if sec-second < ingestionWindow {
+			total += s.ingestedBySecond[i]
+		}
+	}
+	return float64(total) / ingestionWindow
+}
+
+// families возвращает накопленные метрики на момент now, без описаний.
+func (s *selfStats) families(now time.Time) []Family {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	families := []Family{
+		selfCounter("monalert_ingested_metrics_total", s.ingested),
+		selfGauge("monalert_ingestion_rate", s.ingestionRate(now)),
+		selfCounter("monalert_persist_failures_total", s.persistFailures),
+		selfGauge("monalert_uptime_seconds", now.Sub(s.started).Seconds()),
+		{Name: "monalert_persist_duration_seconds", Type: "histogram", Series: s.persist.series(nil)},
+	}
+	keys := make([]requestKey, 0, len(s.requests))
+	for key := range s.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].status < keys[j].status
+	})
+	requests := Family{Name: "monalert_http_requests_total", Type: "counter"}
+	durations := Family{Name: "monalert_http_request_duration_seconds", Type: "histogram"}
+	for _, key := range keys {
+		h := s.requests[key]
+		labels := []Label{{Name: "route", Value: key.route}, {Name: "status", Value: strconv.Itoa(key.status)}}
+		requests.Series = append(requests.Series, Series{Labels: labels, Value: float64(h.count)})
+		durations.Series = append(durations.Series, h.series(labels)...)
+	}
+	if len(keys) > 0 {
+		families = append(families, requests, durations)
+	}
+	if now.Sub(s.runtimeRead) >= runtimeStatsTTL {
+		s.runtime = readRuntimeStats()
+		s.runtimeRead = now
+	}
+	return append(families, s.runtime...)
+}
+
+func readRuntimeStats() []Family {
+	var rtm runtime.MemStats
+	runtime.ReadMemStats(&rtm)
+	return []Family{
+		selfGauge("monalert_go_goroutines", float64(runtime.NumGoroutine())),
+		selfGauge("monalert_go_heap_alloc_bytes", float64(rtm.HeapAlloc)),
+		selfGauge("monalert_go_heap_inuse_bytes", float64(rtm.HeapInuse)),
+		selfGauge("monalert_go_sys_bytes", float64(rtm.Sys)),
+		selfCounter("monalert_go_gc_total", int64(rtm.NumGC)),
+		selfGauge("monalert_go_gc_pause_seconds_total", time.Duration(rtm.PauseTotalNs).Seconds()),
+	}
+}
+
+// RouteLabel приводит метод и шаблон маршрута к значению метки route: get_value_metricType_metricName.
+// Пустой шаблон — запрос не попал ни в один маршрут, такие запросы считаются вместе,
+// чтобы случайные пути и методы не создавали новых серий.
+func RouteLabel(method, pattern string) string {
+	if pattern == "" {
+		return "unmatched"
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+	default:
+		method = "other"
+	}
+	path := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '{', r == '}':
+			return -1
+		default:
+			return '_'
+		}
+	}, strings.Trim(pattern, "/"))
+	if path == "" {
+		path = "root"
+	}
+	return strings.ToLower(method) + "_" + path
+}
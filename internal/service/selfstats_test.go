@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"monalert/internal/hub"
+	"monalert/internal/models"
+	"monalert/internal/repository"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogramSeries(t *testing.T) {
+	h := newHistogram()
+	for _, d := range []time.Duration{3 * time.Millisecond, 5 * time.Millisecond, 70 * time.Millisecond, 20 * time.Second} {
+		h.observe(d)
+	}
+	labels := []Label{{Name: "route", Value: "root"}}
+	series := h.series(labels)
+	require.Len(t, series, len(durationBuckets)+3)
+
+	buckets := map[string]float64{}
+	for _, s := range series[:len(durationBuckets)+1] {
+		assert.Equal(t, "_bucket", s.Suffix)
+		require.Len(t, s.Labels, 2)
+		// граница le идёт перед остальными метками
+		assert.Equal(t, "le", s.Labels[0].Name)
+		assert.Equal(t, labels[0], s.Labels[1])
+		buckets[s.Labels[0].Value] = s.Value
+	}
+	// бакеты накопительные, граница включается в свой бакет, а 20s попадает только в +Inf
+	assert.Equal(t, 2.0, buckets["0.005"])
+	assert.Equal(t, 2.0, buckets["0.05"])
+	assert.Equal(t, 3.0, buckets["0.1"])
+	assert.Equal(t, 3.0, buckets["10"])
+	assert.Equal(t, 4.0, buckets["+Inf"])
+
+	sum, count := series[len(series)-2], series[len(series)-1]
+	assert.Equal(t, "_sum", sum.Suffix)
+	assert.Equal(t, labels, sum.Labels)
+	assert.InDelta(t, 20.078, sum.Value, 1e-9)
+	assert.Equal(t, Series{Suffix: "_count", Labels: labels, Value: 4}, count)
+}
+
+func TestIngestionRate(t *testing.T) {
+	s := newSelfStats()
+	now := time.Unix(1_000_000, 0)
+	assert.Zero(t, s.ingestionRate(now))
+
+	s.observeIngested(60, now.Add(-70*time.Second)) // за пределами окна
+	s.observeIngested(30, now.Add(-30*time.Second))
+	s.observeIngested(50, now.Add(-time.Second))
+	s.observeIngested(40, now)
+	assert.InDelta(t, 2.0, s.ingestionRate(now), 1e-9)
+
+	// ячейка секунды, которая ушла из окна, переиспользуется, а не суммируется со старым значением
+	later := now.Add(ingestionWindow * time.Second)
+	s.observeIngested(6, later)
+	assert.InDelta(t, 0.1, s.ingestionRate(later), 1e-9)
+	assert.Equal(t, int64(186), s.ingested)
+}
+
+func TestRouteLabel(t *testing.T) {
+	tests := []struct {
+		method, pattern string
+		want            string
+	}{
+		{method: http.MethodGet, pattern: "/value/{metricType}/{metricName}", want: "get_value_metricType_metricName"},
+		{method: http.MethodPost, pattern: "/updates/", want: "post_updates"},
+		{method: http.MethodPut, pattern: "/api/v1/metrics/{metricType}/{metricName}", want: "put_api_v1_metrics_metricType_metricName"},
+		{method: http.MethodPost, pattern: "/api/v1/metrics:batch", want: "post_api_v1_metrics_batch"},
+		{method: http.MethodGet, pattern: "/", want: "get_root"},
+		{method: "BREW", pattern: "/", want: "other_root"},
+		{method: http.MethodGet, pattern: "", want: "unmatched"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, RouteLabel(tt.method, tt.pattern), tt.method+" "+tt.pattern)
+	}
+}
+
+func TestSelfMetricFamilies(t *testing.T) {
+	m := NewMonalert(repository.NewStore("", false), false, hub.New(16, 16))
+	m.ObserveRequest("get_root", http.StatusOK, 30*time.Millisecond)
+	m.ObserveRequest("get_root", http.StatusNotFound, time.Millisecond)
+
+	families := map[string]Family{}
+	var names []string
+	for _, f := range m.SelfMetricFamilies() {
+		families[f.Name] = f
+		names = append(names, f.Name)
+	}
+	assert.IsIncreasing(t, names)
+
+	requests := families["monalert_http_requests_total"]
+	assert.Equal(t, "counter", requests.Type)
+	assert.Equal(t, "Number of HTTP requests by route and status.", requests.Help)
+	assert.Equal(t, []Series{
+		{Labels: []Label{{Name: "route", Value: "get_root"}, {Name: "status", Value: "200"}}, Value: 1},
+		{Labels: []Label{{Name: "route", Value: "get_root"}, {Name: "status", Value: "404"}}, Value: 1},
+	}, requests.Series)
+
+	durations := families["monalert_http_request_duration_seconds"]
+	assert.Equal(t, "histogram", durations.Type)
+	assert.NotEmpty(t, durations.Help)
+	assert.Len(t, durations.Series, 2*(len(durationBuckets)+3))
+	assert.Equal(t, "histogram", families["monalert_persist_duration_seconds"].Type)
+	assert.Equal(t, "gauge", families["monalert_series"].Type)
+
+	// в API чтения семейства развёрнуты в серии без меток с прежними именами
+	metrics := map[string]models.Metrics{}
+	for _, metric := range m.selfMetrics() {
+		metrics[metric.ID] = metric
+	}
+	for id, want := range map[string]string{
+		"monalert_http_requests_total.route_get_root.status_404":                          "counter",
+		"monalert_http_request_duration_seconds_bucket.le_0.05.route_get_root.status_200": "counter",
+		"monalert_http_request_duration_seconds_bucket.le_inf.route_get_root.status_200":  "counter",
+		"monalert_http_request_duration_seconds_sum.route_get_root.status_200":            "gauge",
+		"monalert_http_request_duration_seconds_count.route_get_root.status_200":          "counter",
+		"monalert_persist_duration_seconds_bucket.le_inf":                                 "counter",
+		"monalert_uptime_seconds": "gauge",
+	} {
+		require.Contains(t, metrics, id)
+		assert.Equal(t, want, metrics[id].MType, id)
+	}
+	assert.Equal(t, int64(1), *metrics["monalert_http_request_duration_seconds_bucket.le_0.05.route_get_root.status_200"].Delta)
+
+	md, err := m.AllMetadata(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "seconds", md["monalert_http_request_duration_seconds_sum.route_get_root.status_200"].Unit)
+}
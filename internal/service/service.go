@@ -1,56 +1,133 @@
 package service
 
 import (
+	"context"
 	"fmt"
+	"monalert/internal/hub"
 	"monalert/internal/logger"
 	"monalert/internal/models"
+	"monalert/internal/repository"
+	"sort"
+	"strings"
+	"time"
 
 	"go.uber.org/zap"
 )
 
+// Repository — хранилище метрик. Методы, которые обращаются к данным, принимают контекст:
+// реализация должна прерывать работу при его отмене и возвращать ошибку с ctx.Err() внутри.
 type Repository interface {
-	MetricUpdate(req *models.Metrics) (*models.Metrics, error)
-	GetMetric(req *models.Metrics) (*models.Metrics, error)
-	GetAllMetrics() []models.Metrics
-	Persist() error
+	MetricUpdate(ctx context.Context, req *models.Metrics) (*models.Metrics, error)
+	MetricUpdates(ctx context.Context, reqs []models.Metrics) ([]models.Metrics, error)
+	GetMetric(ctx context.Context, req *models.Metrics) (*models.Metrics, error)
+	GetAllMetrics(ctx context.Context) ([]models.Metrics, error)
+	ListMetrics(ctx context.Context, opts repository.ListOptions) (repository.ListPage, error)
+	WalkMetrics(ctx context.Context, opts repository.ListOptions, chunk int, start func(n int) error, fn func([]models.Metrics) error) error
+	GetHistory(ctx context.Context, req *models.Metrics) ([]models.Sample, error)
+	Persist(ctx context.Context) error
+	DeleteMetric(ctx context.Context, req *models.Metrics) error
+	DeleteByPrefix(ctx context.Context, mType, prefix string) (int, error)
+	Expire(ctx context.Context, ttl time.Duration) (int, error)
+	SetMetadata(ctx context.Context, name string, md models.Metadata) error
+	GetMetadata(ctx context.Context, name string) (*models.Metadata, error)
+	AllMetadata(ctx context.Context) (map[string]models.Metadata, error)
+	CardinalityStats() repository.CardinalityStats
+	CardinalityReport(depth, top int) repository.CardinalityReport
 }
 
+// walkChunk — сколько метрик WalkMetrics читает из хранилища под одной блокировкой.
+const walkChunk = 500
+
+// ErrReservedName — клиент пытается записать метрику с именем метрики самого сервера.
+var ErrReservedName = models.ErrReservedName
+
 type Monalert struct {
 	store          Repository
 	persistentMode bool
+	hub            *hub.Hub
+	stats          *selfStats
 }
 
-func NewMonalert(store Repository, persistentMode bool) *Monalert {
+func NewMonalert(store Repository, persistentMode bool, updates *hub.Hub) *Monalert {
 	return &Monalert{
 		store:          store,
 		persistentMode: persistentMode,
+		hub:            updates,
+		stats:          newSelfStats(),
 	}
 }
 
-func (m *Monalert) MetricUpdate(req *models.Metrics) (*models.Metrics, error) {
+func (m *Monalert) MetricUpdate(ctx context.Context, req *models.Metrics) (*models.Metrics, error) {
 	logger.Log.Debug("service: request for metric update")
-	resp, err := m.store.MetricUpdate(&models.Metrics{
-		ID:    req.ID,
-		MType: req.MType,
-		Value: req.Value,
-		Delta: req.Delta,
+	if err := models.ValidateMetric(req); err != nil {
+		return nil, fmt.Errorf("service: failed to update metric value: %w", err)
+	}
+	resp, err := m.store.MetricUpdate(ctx, &models.Metrics{
+		ID:         req.ID,
+		MType:      req.MType,
+		Value:      req.Value,
+		Delta:      req.Delta,
+		Cumulative: req.Cumulative,
+		Replace:    req.Replace,
+		Source:     req.Source,
 	})
 	if err != nil {
 		logger.Log.Debug("service: failed for metric update", zap.Error(err))
 		return nil, fmt.Errorf("service: failed to update metric value: %w", err)
 	}
-	if m.persistentMode {
-		err := m.store.Persist()
-		if err != nil {
-			return nil, err
+	m.stats.observeIngested(1, time.Now())
+	m.persistSync(ctx, "metric update")
+	m.hub.Publish(*resp)
+	return resp, nil
+}
+
+// MetricUpdates записывает батч целиком или не записывает ничего: сначала проверяются все метрики,
+// затем хранилище применяет батч под одной блокировкой. Ошибка указывает на метрику полем вида [3].value.
+func (m *Monalert) MetricUpdates(ctx context.Context, reqs []models.Metrics) ([]models.Metrics, error) {
+	logger.Log.Debug("service: request for metric batch update", zap.Int("count", len(reqs)))
+	batch := make([]models.Metrics, len(reqs))
+	for i := range reqs {
+		if err := models.ValidateMetric(&reqs[i]); err != nil {
+			return nil, fmt.Errorf("service: failed to update metrics: %w", repository.BatchFieldError(i, err))
 		}
+		batch[i] = models.Metrics{
+			ID:         reqs[i].ID,
+			MType:      reqs[i].MType,
+			Value:      reqs[i].Value,
+			Delta:      reqs[i].Delta,
+			Cumulative: reqs[i].Cumulative,
+			Replace:    reqs[i].Replace,
+			Source:     reqs[i].Source,
+		}
+	}
+	resp, err := m.store.MetricUpdates(ctx, batch)
+	if err != nil {
+		logger.Log.Debug("service: failed for metric batch update", zap.Error(err))
+		return nil, fmt.Errorf("service: failed to update metrics: %w", err)
+	}
+	m.stats.observeIngested(len(resp), time.Now())
+	m.persistSync(ctx, "metric batch update")
+	for _, metric := range resp {
+		m.hub.Publish(metric)
 	}
 	return resp, nil
 }
 
-func (m *Monalert) GetMetric(req *models.Metrics) (*models.Metrics, error) {
+// Subscribe подписывает на принятые обновления метрик.
+func (m *Monalert) Subscribe(filter hub.Filter, lastEventID uint64) *hub.Subscription {
+	return m.hub.Subscribe(filter, lastEventID)
+}
+
+func (m *Monalert) GetMetric(ctx context.Context, req *models.Metrics) (*models.Metrics, error) {
 	logger.Log.Debug("service: request for get metric")
-	resp, err := m.store.GetMetric(&models.Metrics{
+	if strings.HasPrefix(req.ID, SelfMetricsPrefix) {
+		for _, metric := range m.selfMetrics() {
+			if metric.ID == req.ID && metric.MType == req.MType {
+				return &metric, nil
+			}
+		}
+	}
+	resp, err := m.store.GetMetric(ctx, &models.Metrics{
 		ID:    req.ID,
 		MType: req.MType,
 		Value: req.Value,
@@ -69,7 +146,150 @@ func (m *Monalert) GetMetric(req *models.Metrics) (*models.Metrics, error) {
 	}, nil
 }
 
-func (m *Monalert) GetAllMetrics() []models.Metrics {
-	metrics := m.store.GetAllMetrics()
-	return metrics
+func (m *Monalert) GetAllMetrics(ctx context.Context) ([]models.Metrics, error) {
+	metrics, err := m.store.GetAllMetrics(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("service: failed to get metrics: %w", err)
+	}
+	return append(metrics, m.selfMetrics()...), nil
+}
+
+// ListMetrics отдаёт страницу метрик. Метрики самомониторинга в хранилище не лежат,
+// поэтому они подмешиваются к странице хранилища с учётом фильтров, курсора и лимита.
+func (m *Monalert) ListMetrics(ctx context.Context, opts repository.ListOptions) (repository.ListPage, error) {
+	page, err := m.store.ListMetrics(ctx, opts)
+	if err != nil {
+		return repository.ListPage{}, fmt.Errorf("service: failed to list metrics: %w", err)
+	}
+	var extra []models.Metrics
+	for _, metric := range m.selfMetrics() {
+		if !opts.Matches(metric) {
+			continue
+		}
+		page.Total++
+		// если у хранилища есть следующая страница, метрики после её начала отдадим позже
+		if opts.After(metric) && (page.Next == "" || opts.Less(metric, page.Metrics[len(page.Metrics)-1])) {
+			extra = append(extra, metric)
+		}
+	}
+	if len(extra) == 0 {
+		return page, nil
+	}
+	merged := append(page.Metrics, extra...)
+	sort.Slice(merged, func(i, j int) bool { return opts.Less(merged[i], merged[j]) })
+	if opts.Limit > 0 && len(merged) > opts.Limit {
+		merged = merged[:opts.Limit]
+		page.Next = opts.NextCursor(merged[len(merged)-1])
+	}
+	page.Metrics = merged
+	return page, nil
+}
+
+// WalkMetrics передаёт fn все метрики выборки в порядке opts, Limit не учитывается. Список серий
+// хранилища снимается один раз на вызов, значения читаются порциями по walkChunk, а метрики
+// самомониторинга подмешиваются в том же порядке. До первой метрики start получает размер
+// выборки; серии, удалённые во время обхода, в неё входят, но fn не передаются.
+func (m *Monalert) WalkMetrics(ctx context.Context, opts repository.ListOptions, start func(n int) error, fn func(models.Metrics) error) error {
+	var extra []models.Metrics
+	for _, metric := range m.selfMetrics() {
+		if opts.Matches(metric) && opts.After(metric) {
+			extra = append(extra, metric)
+		}
+	}
+	sort.Slice(extra, func(i, j int) bool { return opts.Less(extra[i], extra[j]) })
+	count := func(n int) error { return start(n + len(extra)) }
+	err := m.store.WalkMetrics(ctx, opts, walkChunk, count, func(chunk []models.Metrics) error {
+		for _, metric := range chunk {
+			for len(extra) > 0 && opts.Less(extra[0], metric) {
+				if err := fn(extra[0]); err != nil {
+					return err
+				}
+				extra = extra[1:]
+			}
+			if err := fn(metric); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("service: failed to list metrics: %w", err)
+	}
+	for _, metric := range extra {
+		if err := fn(metric); err != nil {
+			return fmt.Errorf("service: failed to list metrics: %w", err)
+		}
+	}
+	return nil
+}
+
+func (m *Monalert) GetHistory(ctx context.Context, req *models.Metrics) ([]models.Sample, error) {
+	logger.Log.Debug("service: request for metric history")
+	samples, err := m.store.GetHistory(ctx, &models.Metrics{
+		ID:    req.ID,
+		MType: req.MType,
+	})
+	if err != nil {
+		logger.Log.Debug("service: failed to get metric history", zap.Error(err))
+		return nil, fmt.Errorf("service: failed to get metric history: %w", err)
+	}
+	return samples, nil
+}
+
+// Cardinality возвращает отчёт о том, какие префиксы имён и агенты создают больше всего серий.
+func (m *Monalert) Cardinality(depth, top int) repository.CardinalityReport {
+	return m.store.CardinalityReport(depth, top)
+}
+
+func (m *Monalert) DeleteMetric(ctx context.Context, req *models.Metrics) error {
+	logger.Log.Debug("service: request for metric delete")
+	if err := m.store.DeleteMetric(ctx, &models.Metrics{ID: req.ID, MType: req.MType}); err != nil {
+		logger.Log.Debug("service: failed to delete metric", zap.Error(err))
+		return fmt.Errorf("service: failed to delete metric: %w", err)
+	}
+	m.persistSync(ctx, "delete")
+	return nil
+}
+
+// DeleteByPrefix удаляет все серии с именем, начинающимся на prefix; пустой mType — оба типа.
+func (m *Monalert) DeleteByPrefix(ctx context.Context, mType, prefix string) (int, error) {
+	logger.Log.Debug("service: request for metric delete by prefix")
+	deleted, err := m.store.DeleteByPrefix(ctx, mType, prefix)
+	if err != nil {
+		return 0, fmt.Errorf("service: failed to delete metrics: %w", err)
+	}
+	if deleted == 0 {
+		return 0, nil
+	}
+	m.persistSync(ctx, "delete")
+	return deleted, nil
+}
+
+// Expire удаляет серии, не обновлявшиеся дольше ttl.
+func (m *Monalert) Expire(ctx context.Context, ttl time.Duration) (int, error) {
+	expired, err := m.store.Expire(ctx, ttl)
+	if err != nil {
+		return 0, fmt.Errorf("service: failed to expire metrics: %w", err)
+	}
+	if expired == 0 {
+		return 0, nil
+	}
+	logger.Log.Info("service: expired metrics", zap.Int("count", expired))
+	m.persistSync(ctx, "expire")
+	return expired, nil
+}
+
+// persistSync в синхронном режиме сразу сохраняет хранилище после изменения, иначе изменение
+// попадёт в файл при следующем периодическом сохранении. Ошибка сохранения только пишется в лог
+// и учитывается в monalert_persist_failures_total: изменение уже применено в памяти и попадёт
+// в файл со следующим сохранением, а ошибка в ответе заставила бы клиента повторить запрос
+// и учесть приращения счётчиков дважды.
+func (m *Monalert) persistSync(ctx context.Context, change string) {
+	if !m.persistentMode {
+		return
+	}
+	if err := m.Persist(ctx); err != nil {
+		logger.Log.Error("service: failed to persist storage, change is kept in memory",
+			zap.String("change", change), zap.Error(err))
+	}
 }
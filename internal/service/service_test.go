@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"monalert/internal/hub"
+	"monalert/internal/models"
+	"monalert/internal/repository"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncPersistFailureKeepsChange(t *testing.T) {
+	// каталог хранилища — обычный файл, поэтому каждое сохранение завершается ошибкой
+	dir := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(dir, nil, 0o600))
+	updates := hub.New(16, 16)
+	sub := updates.Subscribe(hub.Filter{}, 0)
+	defer sub.Close()
+	m := NewMonalert(repository.NewStore(filepath.Join(dir, "metrics.json"), true), true, updates)
+	ctx := context.Background()
+
+	delta := int64(2)
+	resp, err := m.MetricUpdate(ctx, &models.Metrics{ID: "hits", MType: "counter", Delta: &delta})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), *resp.Delta)
+	select {
+	case event := <-sub.Events():
+		assert.Equal(t, "hits", event.Metric.ID)
+	case <-time.After(time.Second):
+		t.Fatal("update was not published")
+	}
+
+	_, err = m.MetricUpdates(ctx, []models.Metrics{{ID: "hits", MType: "counter", Delta: &delta}})
+	require.NoError(t, err)
+	require.NoError(t, m.SetMetadata(ctx, "hits", models.Metadata{Type: "counter"}))
+	require.NoError(t, m.DeleteMetric(ctx, &models.Metrics{ID: "hits", MType: "counter"}))
+
+	stat, err := m.GetMetric(ctx, &models.Metrics{ID: "monalert_persist_failures_total", MType: "counter"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), *stat.Delta)
+}
+
+func TestWalkMetrics(t *testing.T) {
+	m := NewMonalert(repository.NewStore("", false), false, hub.New(16, 16))
+	ctx := context.Background()
+	value := 1.0
+	for _, id := range []string{"HeapAlloc", "monalert_queue_depth", "monalert_a"} {
+		_, err := m.MetricUpdate(ctx, &models.Metrics{ID: id, MType: "gauge", Value: &value})
+		require.NoError(t, err)
+	}
+	ids := func(metrics []models.Metrics) []string {
+		var ids []string
+		for _, metric := range metrics {
+			ids = append(ids, metric.ID)
+		}
+		return ids
+	}
+
+	for _, opts := range []repository.ListOptions{
+		{},
+		{Prefix: "monalert_", Desc: true},
+		{Type: "gauge", Sort: repository.SortByType},
+	} {
+		var walked []models.Metrics
+		total := -1
+		count := func(n int) error {
+			require.Empty(t, walked, "start after the first metric")
+			total = n
+			return nil
+		}
+		require.NoError(t, m.WalkMetrics(ctx, opts, count, func(metric models.Metrics) error {
+			walked = append(walked, metric)
+			return nil
+		}))
+		assert.Len(t, walked, total)
+		// метрики сервера подмешиваются в том же порядке, что и у постраничной выборки
+		page, err := m.ListMetrics(ctx, opts)
+		require.NoError(t, err)
+		assert.Equal(t, ids(page.Metrics), ids(walked))
+		assert.Contains(t, ids(walked), "monalert_queue_depth")
+		assert.Contains(t, ids(walked), "monalert_uptime_seconds")
+	}
+}
+
+func TestListMetricsMergesSelfMetrics(t *testing.T) {
+	m := NewMonalert(repository.NewStore("", false), false, hub.New(16, 16))
+	ctx := context.Background()
+	value, delta := 1.0, int64(1)
+	for _, metric := range []models.Metrics{
+		{ID: "HeapAlloc", MType: "gauge", Value: &value},
+		{ID: "monalert_queue_depth", MType: "gauge", Value: &value},
+		{ID: "monalert_a", MType: "counter", Delta: &delta},
+		{ID: "zz", MType: "counter", Delta: &delta},
+	} {
+		_, err := m.MetricUpdate(ctx, &metric)
+		require.NoError(t, err)
+	}
+	keys := func(metrics []models.Metrics) []string {
+		var keys []string
+		for _, metric := range metrics {
+			keys = append(keys, metric.MType+"/"+metric.ID)
+		}
+		return keys
+	}
+
+	for _, opts := range []repository.ListOptions{
+		{},
+		{Desc: true},
+		{Sort: repository.SortByType},
+		{Prefix: "monalert_"},
+		{Type: "counter", Sort: repository.SortByType, Desc: true},
+	} {
+		all, err := m.ListMetrics(ctx, opts)
+		require.NoError(t, err)
+		assert.Equal(t, len(all.Metrics), all.Total)
+		assert.Empty(t, all.Next)
+		assert.True(t, sort.SliceIsSorted(all.Metrics, func(i, j int) bool { return opts.Less(all.Metrics[i], all.Metrics[j]) }))
+
+		// постраничная выборка отдаёт те же метрики без пропусков и повторов
+		for _, limit := range []int{1, 2, 3} {
+			paged := opts
+			paged.Limit = limit
+			var got []models.Metrics
+			for {
+				page, err := m.ListMetrics(ctx, paged)
+				require.NoError(t, err)
+				assert.Equal(t, all.Total, page.Total)
+				assert.LessOrEqual(t, len(page.Metrics), limit)
+				got = append(got, page.Metrics...)
+				if page.Next == "" {
+					break
+				}
+				paged.Cursor = page.Next
+			}
+			assert.Equal(t, keys(all.Metrics), keys(got), "limit %d", limit)
+		}
+	}
+
+	page, err := m.ListMetrics(ctx, repository.ListOptions{Prefix: "monalert_"})
+	require.NoError(t, err)
+	assert.Contains(t, keys(page.Metrics), "gauge/monalert_queue_depth")
+	assert.Contains(t, keys(page.Metrics), "counter/monalert_ingested_metrics_total")
+	assert.NotContains(t, keys(page.Metrics), "gauge/HeapAlloc")
+}
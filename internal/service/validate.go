@@ -0,0 +1,32 @@
+package service
+
+import (
+	"monalert/internal/models"
+	"monalert/internal/repository"
+)
+
+const (
+	maxMetadataHelp = 1024
+	maxMetadataUnit = 32
+)
+
+// ValidateMetadata проверяет описание метрики.
+func ValidateMetadata(md *models.Metadata) error {
+	switch md.Type {
+	case "", "gauge", "counter":
+	default:
+		return repository.NewFieldError("type", repository.ErrUnsupportedType, "unsupported metric type: %s", md.Type)
+	}
+	switch md.Display {
+	case "", "raw", "percent", "timestamp":
+	default:
+		return repository.NewFieldError("display", repository.ErrInvalidValue, "unsupported display hint: %s", md.Display)
+	}
+	if len(md.Help) > maxMetadataHelp {
+		return repository.NewFieldError("help", repository.ErrInvalidValue, "help is too long")
+	}
+	if len(md.Unit) > maxMetadataUnit {
+		return repository.NewFieldError("unit", repository.ErrInvalidValue, "unit is too long")
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"context"
+	"monalert/internal/hub"
+	"monalert/internal/models"
+	"monalert/internal/repository"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestReservedNamesCoverSelfMetrics проверяет, что список зарезервированных имён в models
+// не отстал от метрик сервера: иначе клиент смог бы перезаписать одну из них.
+func TestReservedNamesCoverSelfMetrics(t *testing.T) {
+	for name := range selfMetadata {
+		assert.True(t, models.IsReservedName(name), name)
+	}
+	for name := range selfHistograms {
+		for _, suffix := range []string{"", "_bucket", "_sum", "_count"} {
+			assert.True(t, models.IsReservedName(name+suffix), name+suffix)
+		}
+	}
+	for _, m := range NewMonalert(repository.NewStore("", false), false, hub.New(16, 16)).selfMetrics() {
+		assert.True(t, models.IsReservedName(m.ID), m.ID)
+	}
+}
+
+func TestSetMetadataReservedName(t *testing.T) {
+	m := NewMonalert(repository.NewStore("", false), false, hub.New(16, 16))
+	require.ErrorIs(t, m.SetMetadata(context.Background(), "monalert_series", models.Metadata{Help: "mine"}), ErrReservedName)
+	require.NoError(t, m.SetMetadata(context.Background(), "monalert_queue_depth", models.Metadata{Help: "mine"}))
+	md, err := m.GetMetadata(context.Background(), "monalert_queue_depth")
+	require.NoError(t, err)
+	assert.Equal(t, "mine", md.Help)
+}
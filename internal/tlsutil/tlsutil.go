@@ -0,0 +1,138 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"monalert/internal/logger"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+// ServerCerts хранит сертификат сервера и CA для проверки клиентов
+// и умеет перечитывать их с диска без перезапуска.
+type ServerCerts struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mux       sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// NewServerCerts загружает сертификат и ключ сервера. Если задан clientCAFile,
+// сервер требует у клиентов сертификат, подписанный одним из CA из этого файла (mTLS).
+func NewServerCerts(certFile, keyFile, clientCAFile string) (*ServerCerts, error) {
+	c := &ServerCerts{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload перечитывает файлы. При ошибке продолжают использоваться старые сертификаты.
+func (c *ServerCerts) Reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load server certificate: %w", err)
+	}
+	var clientCAs *x509.CertPool
+	if c.clientCAFile != "" {
+		clientCAs, err = loadCertPool(c.clientCAFile)
+		if err != nil {
+			return err
+		}
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.cert = &cert
+	c.clientCAs = clientCAs
+	return nil
+}
+
+// TLSConfig возвращает конфигурацию, которая на каждое подключение берёт
+// актуальные сертификаты, поэтому Reload действует на новые соединения сразу.
+// Конфигурация для клиента наследует NextProtos базовой, иначе ALPN не договорится
+// о HTTP/2 и сервер будет отвечать только по HTTP/1.1.
+func (c *ServerCerts) TLSConfig() *tls.Config {
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c.mux.RLock()
+		defer c.mux.RUnlock()
+		cfg := &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{*c.cert},
+			NextProtos:   base.NextProtos,
+		}
+		if c.clientCAs != nil {
+			cfg.ClientCAs = c.clientCAs
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		return cfg, nil
+	}
+	return base
+}
+
+// ReloadOnSIGHUP перечитывает сертификаты при каждом SIGHUP.
+func (c *ServerCerts) ReloadOnSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			if err := c.Reload(); err != nil {
+				logger.Log.Error("certificate reload failed, keeping previous certificate", zap.Error(err))
+				continue
+			}
+			logger.Log.Info("certificates reloaded")
+		}
+	}()
+}
+
+// ClientConfig собирает конфигурацию TLS для клиента: caFile — CA для проверки сервера
+// (если пусто, используются системные), certFile и keyFile — сертификат клиента для mTLS.
+func ClientConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("client certificate and key must be set together")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", path)
+	}
+	return pool, nil
+}
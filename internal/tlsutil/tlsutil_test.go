@@ -0,0 +1,226 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+var serial int64
+
+// issue выпускает сертификат, подписанный parent, либо самоподписанный CA, если parent == nil.
+func issue(t *testing.T, cn string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+		tmpl.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCert{cert: cert, key: key}
+}
+
+// write сохраняет сертификат и ключ в PEM и возвращает пути к файлам.
+func (c *testCert) write(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0o600))
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func startServer(t *testing.T, certs *ServerCerts) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.TLS = certs.TLSConfig()
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func get(client *http.Client, url string) (*http.Response, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "test-ca", nil, 0)
+	caFile, _ := ca.write(t, dir, "ca")
+	serverCert, serverKey := issue(t, "server", ca, x509.ExtKeyUsageServerAuth).write(t, dir, "server")
+	clientCert, clientKey := issue(t, "agent", ca, x509.ExtKeyUsageClientAuth).write(t, dir, "client")
+	otherCA := issue(t, "other-ca", nil, 0)
+	strangerCert, strangerKey := issue(t, "stranger", otherCA, x509.ExtKeyUsageClientAuth).write(t, dir, "stranger")
+
+	certs, err := NewServerCerts(serverCert, serverKey, caFile)
+	require.NoError(t, err)
+	srv := startServer(t, certs)
+
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+		wantErr  bool
+	}{
+		{name: "trusted client certificate", certFile: clientCert, keyFile: clientKey},
+		{name: "no client certificate", wantErr: true},
+		{name: "certificate from another CA", certFile: strangerCert, keyFile: strangerKey, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ClientConfig(caFile, tt.certFile, tt.keyFile)
+			require.NoError(t, err)
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+			resp, err := get(client, srv.URL)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
+}
+
+func TestServerTLSWithoutClientCA(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "test-ca", nil, 0)
+	caFile, _ := ca.write(t, dir, "ca")
+	serverCert, serverKey := issue(t, "server", ca, x509.ExtKeyUsageServerAuth).write(t, dir, "server")
+
+	certs, err := NewServerCerts(serverCert, serverKey, "")
+	require.NoError(t, err)
+	srv := startServer(t, certs)
+
+	cfg, err := ClientConfig(caFile, "", "")
+	require.NoError(t, err)
+	resp, err := get(&http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}, srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// без CA сервер не проходит проверку системными корнями
+	_, err = get(&http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{MinVersion: tls.VersionTLS12}}}, srv.URL)
+	assert.Error(t, err)
+}
+
+func TestServerTLSNegotiatesHTTP2(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "test-ca", nil, 0)
+	caFile, _ := ca.write(t, dir, "ca")
+	serverCert, serverKey := issue(t, "server", ca, x509.ExtKeyUsageServerAuth).write(t, dir, "server")
+	certs, err := NewServerCerts(serverCert, serverKey, "")
+	require.NoError(t, err)
+
+	// как в handlers.Serve: http.Server сам настраивает HTTP/2 поверх TLSConfig
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}),
+		TLSConfig:         certs.TLSConfig(),
+		ReadHeaderTimeout: time.Second,
+	}
+	go srv.ServeTLS(ln, "", "") //nolint:errcheck // сервер закрывается в Cleanup
+	t.Cleanup(func() { srv.Close() })
+
+	cfg, err := ClientConfig(caFile, "", "")
+	require.NoError(t, err)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg, ForceAttemptHTTP2: true}}
+	resp, err := get(client, "https://"+ln.Addr().String())
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 2, resp.ProtoMajor)
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "test-ca", nil, 0)
+	caFile, _ := ca.write(t, dir, "ca")
+	serverCert, serverKey := issue(t, "server-1", ca, x509.ExtKeyUsageServerAuth).write(t, dir, "server")
+
+	certs, err := NewServerCerts(serverCert, serverKey, "")
+	require.NoError(t, err)
+	srv := startServer(t, certs)
+
+	cfg, err := ClientConfig(caFile, "", "")
+	require.NoError(t, err)
+	peerName := func() string {
+		// новое соединение на каждый запрос, чтобы увидеть текущий сертификат
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg, DisableKeepAlives: true}}
+		resp, err := client.Get(srv.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	assert.Equal(t, "server-1", peerName())
+
+	issue(t, "server-2", ca, x509.ExtKeyUsageServerAuth).write(t, dir, "server")
+	require.NoError(t, certs.Reload())
+	assert.Equal(t, "server-2", peerName())
+
+	// битый файл не ломает уже загруженный сертификат
+	require.NoError(t, os.WriteFile(serverCert, []byte("garbage"), 0o600))
+	assert.Error(t, certs.Reload())
+	assert.Equal(t, "server-2", peerName())
+}
+
+func TestClientConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.pem")
+	require.NoError(t, os.WriteFile(empty, []byte("no certificates"), 0o600))
+
+	_, err := ClientConfig(filepath.Join(dir, "missing.pem"), "", "")
+	assert.Error(t, err)
+	_, err = ClientConfig(empty, "", "")
+	assert.Error(t, err)
+	_, err = ClientConfig("", "client.crt", "")
+	assert.Error(t, err)
+}